@@ -0,0 +1,12 @@
+package acbrun
+
+import (
+	"os"
+)
+
+// IsCgroupV2Unified reports whether the host has the unified cgroup v2 hierarchy
+// mounted at /sys/fs/cgroup, rather than the v1 (or hybrid) layout.
+func IsCgroupV2Unified() bool {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	return err == nil
+}
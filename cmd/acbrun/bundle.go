@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tidwall/sjson"
+)
+
+// dumpBundle copies the runc bundle's config.json along with a listing of the
+// rootfs into dir, so that a failed container can be inspected or re-run by hand.
+// The dumped config refers to the rootfs by its absolute path.
+func dumpBundle(dir, workingDir, rootFS string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	configJSON, err := os.ReadFile(filepath.Join(workingDir, "config.json"))
+	if err != nil {
+		return err
+	}
+	absRootFS, err := filepath.Abs(rootFS)
+	if err != nil {
+		return err
+	}
+	dumpedConfigJSON, err := sjson.SetBytes(configJSON, "root.path", absRootFS)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), dumpedConfigJSON, 0644); err != nil {
+		return err
+	}
+
+	listing, err := os.Create(filepath.Join(dir, "rootfs.txt"))
+	if err != nil {
+		return err
+	}
+	defer listing.Close()
+	return filepath.WalkDir(rootFS, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(rootFS, path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(listing, "%s %10d %s\n", info.Mode(), info.Size(), relPath)
+		return err
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// cniHookCommand is the hidden command which the hooks added by --cni run
+	cniHookCommand = "cni-hook"
+
+	// defaultCNIPath is where CNI plugins are looked for unless CNI_PATH is set
+	defaultCNIPath = "/opt/cni/bin"
+
+	// cniIfName is the name of the interface the plugin creates in the container
+	cniIfName = "eth0"
+)
+
+// cniNetwork is the part of a CNI network config which acbrun reads; the whole
+// config is passed on to the plugin as it is.
+type cniNetwork struct {
+	CNIVersion string `json:"cniVersion"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+}
+
+// cniPlugin is a --cni network config along with the plugin which configures it.
+type cniPlugin struct {
+	config  string // absolute path of the network config
+	path    string // absolute path of the plugin binary
+	cniPath string // the directories searched for plugins, passed on as CNI_PATH
+}
+
+// resolveCNIPlugin reads the network config at configPath and finds the plugin
+// named by its type in CNI_PATH (or defaultCNIPath).
+func resolveCNIPlugin(configPath string) (cniPlugin, error) {
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return cniPlugin{}, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return cniPlugin{}, err
+	}
+	var network cniNetwork
+	if err := json.Unmarshal(data, &network); err != nil {
+		return cniPlugin{}, fmt.Errorf("expected a CNI network config: %w", err)
+	}
+	if network.Type == "" || strings.ContainsRune(network.Type, '/') {
+		return cniPlugin{}, fmt.Errorf("expected a CNI network config with the plugin's type (plugin lists are not supported)")
+	}
+	cniPath := os.Getenv("CNI_PATH")
+	if cniPath == "" {
+		cniPath = defaultCNIPath
+	}
+	for _, dir := range filepath.SplitList(cniPath) {
+		path := filepath.Join(dir, network.Type)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return cniPlugin{}, err
+			}
+			return cniPlugin{config: configPath, path: path, cniPath: cniPath}, nil
+		}
+	}
+	return cniPlugin{}, fmt.Errorf("plugin %s was not found in %s", network.Type, cniPath)
+}
+
+// hookArgs returns the args of the hook which runs the plugin with the CNI command
+// (ADD or DEL) against the container's network namespace.
+func (p cniPlugin) hookArgs(command string) ([]string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return []string{self, cniHookCommand, command, p.config, p.path, p.cniPath}, nil
+}
+
+// containerState is the part of the OCI state which runc passes to hooks on stdin.
+type containerState struct {
+	ID  string `json:"id"`
+	Pid int    `json:"pid"`
+}
+
+// runCNIHook is the cni-hook command run by the hooks which --cni adds: it reads
+// the container's state from stdin and runs the plugin with the CNI command,
+// passing it the network namespace of the container's process, and the network
+// config on its stdin. The namespace is gone by the time the poststop hook runs,
+// in which case the plugin is only given the container's id to clean up after.
+func runCNIHook(command, configPath, pluginPath, cniPath string) error {
+	var state containerState
+	if err := json.NewDecoder(os.Stdin).Decode(&state); err != nil {
+		return fmt.Errorf("unable to read the container state: %w", err)
+	}
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+state.ID,
+		"CNI_IFNAME="+cniIfName,
+		"CNI_PATH="+cniPath,
+	)
+	if state.Pid > 0 {
+		netns := fmt.Sprintf("/proc/%d/ns/net", state.Pid)
+		if _, err := os.Stat(netns); err == nil || command == "ADD" {
+			env = append(env, "CNI_NETNS="+netns)
+		}
+	}
+	cmd := exec.Command(pluginPath)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(config)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// plugins report their errors as JSON on stdout
+		return fmt.Errorf("%s %s: %w: %s", filepath.Base(pluginPath), command, err, bytes.TrimSpace(stdout.Bytes()))
+	}
+	return nil
+}
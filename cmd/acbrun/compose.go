@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// composeSpec describes a group of reentrant containers started by --compose. The
+// first container gets its own network namespace (or the host's, per Network),
+// and every other container joins it, so they can reach one another on localhost.
+type composeSpec struct {
+	Network    string             `json:"network,omitempty"`
+	Containers []composeContainer `json:"containers"`
+}
+
+type composeContainer struct {
+	Name    string         `json:"name"`
+	Image   string         `json:"image"`
+	Sha256  string         `json:"sha256"`
+	Command string         `json:"command,omitempty"` // run once the container has started, and must return
+	Mounts  []composeMount `json:"mounts,omitempty"`
+}
+
+type composeMount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"readonly,omitempty"`
+}
+
+// readComposeSpec reads and validates a --compose spec file. Relative image and
+// mount source paths are resolved relative to the spec file.
+func readComposeSpec(path string) (composeSpec, error) {
+	var spec composeSpec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, err
+	}
+	if len(spec.Containers) == 0 {
+		return spec, fmt.Errorf("no containers are defined")
+	}
+	if spec.Network == "" {
+		spec.Network = "none"
+	}
+	if spec.Network != "none" && spec.Network != "host" {
+		return spec, fmt.Errorf("invalid network %q; expected none or host", spec.Network)
+	}
+	specDir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(specDir, p)
+	}
+	names := map[string]bool{}
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		if c.Name == "" {
+			return spec, fmt.Errorf("container %d has no name", i)
+		}
+		if err := validateContainerName(c.Name); err != nil {
+			return spec, err
+		}
+		if names[c.Name] {
+			return spec, fmt.Errorf("container %s is defined more than once", c.Name)
+		}
+		names[c.Name] = true
+		if c.Image == "" || c.Sha256 == "" {
+			return spec, fmt.Errorf("container %s requires an image and sha256", c.Name)
+		}
+		c.Image = resolve(c.Image)
+		for j := range c.Mounts {
+			m := &c.Mounts[j]
+			if m.Source == "" || !filepath.IsAbs(m.Destination) {
+				return spec, fmt.Errorf("container %s has an invalid mount; a source and absolute destination are required", c.Name)
+			}
+			m.Source = resolve(m.Source)
+			// checked here too, so that no container is started when any mount is invalid
+			if err := validateBindSource(m.Source, m.ReadOnly); err != nil {
+				return spec, fmt.Errorf("container %s: %w", c.Name, err)
+			}
+		}
+	}
+	return spec, nil
+}
+
+// composeArgs returns the acbrun arguments which start (or re-enter) the i-th
+// container of spec.
+func composeArgs(spec composeSpec, i int, verbose bool) []string {
+	c := spec.Containers[i]
+	args := []string{"--reentrant", "--name", c.Name}
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	if i == 0 {
+		args = append(args, "--network", spec.Network)
+	} else {
+		args = append(args, "--network", "container:"+spec.Containers[0].Name)
+	}
+	return append(args, c.Image, c.Sha256, c.Command)
+}
+
+// composeMountsEnv passes the mounts of a container, as JSON, from runCompose to
+// the acbrun which starts it; there is no command line flag for read-write bind
+// mounts.
+const composeMountsEnv = "ACBRUN_COMPOSE_MOUNTS"
+
+// addComposeMounts bind mounts the mounts which runCompose passed in
+// composeMountsEnv, if any, in order, so that their sources are validated as any
+// other bind mount's are.
+func addComposeMounts(configJSON string) (string, error) {
+	data := os.Getenv(composeMountsEnv)
+	if data == "" {
+		return configJSON, nil
+	}
+	var mounts []composeMount
+	if err := json.Unmarshal([]byte(data), &mounts); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", composeMountsEnv, err)
+	}
+	for _, m := range mounts {
+		var err error
+		configJSON, err = addBindMount(configJSON, m.Source, m.Destination, m.ReadOnly)
+		if err != nil {
+			return "", err
+		}
+	}
+	return configJSON, nil
+}
+
+// runCompose starts the containers of the spec file in order, by running acbrun
+// itself in reentrant mode for each of them.
+func runCompose(path string, verbose bool) error {
+	spec, err := readComposeSpec(path)
+	if err != nil {
+		return fmt.Errorf("invalid compose spec %s: %w", path, err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	for i, c := range spec.Containers {
+		args := composeArgs(spec, i, verbose)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "starting container %s\n", c.Name)
+		}
+		mounts, err := json.Marshal(c.Mounts)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(self, args...)
+		// replaces any value acbrun itself was given, as the last value wins
+		cmd.Env = append(os.Environ(), composeMountsEnv+"="+string(mounts))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to start container %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
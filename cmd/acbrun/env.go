@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseEnv validates a KEY=VALUE environment variable definition, returning its key.
+func parseEnv(s string) (string, error) {
+	key, _, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", fmt.Errorf("expected KEY=VALUE")
+	}
+	return key, nil
+}
+
+func envKey(s string) string {
+	key, _, _ := strings.Cut(s, "=")
+	return key
+}
+
+// mergeEnv returns env with each of the KEY=VALUE definitions in overrides applied;
+// an existing definition of a key is replaced in place, and new keys are appended.
+func mergeEnv(env, overrides []string) []string {
+	merged := append([]string{}, env...)
+	for _, override := range overrides {
+		replaced := false
+		for i, existing := range merged {
+			if envKey(existing) == envKey(override) {
+				merged[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// mergeEnvDefaults behaves like mergeEnv, except that keys which env already
+// defines are left unchanged.
+func mergeEnvDefaults(env, defaults []string) []string {
+	var missing []string
+	for _, d := range defaults {
+		if !envHasKey(env, envKey(d)) && !envHasKey(missing, envKey(d)) {
+			missing = append(missing, d)
+		}
+	}
+	return mergeEnv(env, missing)
+}
+
+func envHasKey(env []string, key string) bool {
+	for _, e := range env {
+		if envKey(e) == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// checkHealth runs healthcheck inside the container once, reporting whether it
+// exited successfully. Its output is only shown in verbose mode.
+func checkHealth(containerName, workingDir, healthcheck string, verbose bool) (bool, error) {
+	var output io.Writer
+	if verbose {
+		output = os.Stderr
+	}
+	err := acbrun.ExecContainer(containerName, []string{"/bin/sh", "-c", healthcheck}, acbrun.RunOptions{
+		BundleDir: workingDir,
+		Stdout:    output,
+		Stderr:    output,
+	})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitHealthy runs healthcheck every interval until it passes, returning an error
+// if it has not passed within timeout.
+func waitHealthy(containerName, workingDir, healthcheck string, timeout, interval time.Duration, verbose bool) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		healthy, err := checkHealth(containerName, workingDir, healthcheck, verbose)
+		if err != nil {
+			return err
+		}
+		if healthy {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "container %s is healthy after %d attempt(s)\n", containerName, attempt)
+			}
+			return nil
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "container %s healthcheck attempt %d failed\n", containerName, attempt)
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("container %s did not become healthy within %s (%d attempts)", containerName, timeout, attempt)
+		}
+		time.Sleep(interval)
+	}
+}
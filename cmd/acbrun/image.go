@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+
+	"github.com/alexcb/acbrun/v2"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type Manifest struct {
+	Config   string   `json:"Config,omitempty"`
+	RepoTags []string `json:"RepoTags,omitempty"`
+	Layers   []string `json:"Layers,omitempty"`
+}
+
+func parseManifest(manifestData []byte) (Manifest, error) {
+	var result []Manifest
+	err := json.Unmarshal(manifestData, &result)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(result) != 1 {
+		return Manifest{}, fmt.Errorf("expected 1 manifest entry; got %d", len(result))
+	}
+	return result[0], nil
+}
+
+// canonicalJSON marshals v with the keys of every object, including those of
+// structs, sorted, without insignificant whitespace, and without escaping HTML
+// characters, so that equal values always produce the same bytes, and so the same
+// digest, as other tools which write canonical JSON.
+func canonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// maps are always marshaled with sorted keys, so converting structs to maps
+	// sorts their fields too; json.Number keeps numbers exactly as they were
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func getManifest(manifestPath string) (Manifest, error) {
+	manifestData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return parseManifest(manifestData)
+}
+
+// readImageConfig reads the config of the image which was extracted to workingDir.
+func readImageConfig(workingDir string) (imagespec.Image, error) {
+	var imageConfig imagespec.Image
+	manifest, err := getManifest(filepath.Join(workingDir, "manifest.json"))
+	if err != nil {
+		return imageConfig, err
+	}
+	if manifest.Config == "" {
+		return imageConfig, nil
+	}
+	imageConfigData, err := os.ReadFile(filepath.Join(workingDir, manifest.Config))
+	if err != nil {
+		return imageConfig, err
+	}
+	err = json.Unmarshal(imageConfigData, &imageConfig)
+	return imageConfig, err
+}
+
+// readImageManifest reads manifest.json straight out of the image without extracting it.
+func readImageManifest(image string) (Manifest, error) {
+	r, err := os.Open(image)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer r.Close()
+	var manifestData []byte
+	err = acbrun.WalkTarGz(r, func(header *tar.Header, tr io.Reader) error {
+		if path.Clean(header.Name) != "manifest.json" {
+			return nil
+		}
+		manifestData, err = io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		return fs.SkipAll
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	if manifestData == nil {
+		return Manifest{}, fmt.Errorf("%w: %s does not contain a manifest.json", acbrun.ErrInvalidArchive, image)
+	}
+	return parseManifest(manifestData)
+}
+
+// extractImageOptions controls how extractImage unpacks an image.
+type extractImageOptions struct {
+	// KeepLayers always writes the layer archives to the working directory (rather
+	// than streaming them) and prints their paths.
+	KeepLayers bool
+
+	// Overlay extracts each layer to its own directory (see overlayLayerDir), ready
+	// to be mounted with mountOverlay, instead of applying them all to the rootfs.
+	Overlay bool
+
+	Verbose bool
+
+	// Extract is passed on when extracting each layer.
+	Extract acbrun.ExtractOptions
+}
+
+// defaultExtractConcurrency is the number of files written at once during
+// extraction unless --extract-concurrency is given: a few per CPU, while leaving
+// most of the open file limit to everything else which runs alongside it.
+func defaultExtractConcurrency() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 1
+	}
+	return int(max(1, min(uint64(runtime.NumCPU()*4), limit.Cur/4)))
+}
+
+// extractImage extracts the image's metadata into workingDir and applies its
+// layers, in order, to rootFS. The image is read once: the layer of a single layer
+// image whose manifest comes first is streamed straight into rootFS, and otherwise
+// the layers are written to workingDir until the manifest has been read.
+func extractImage(image, workingDir, rootFS string, opts extractImageOptions) error {
+	r, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var streamLayer func(name string, r io.Reader) error
+	if !opts.KeepLayers {
+		streamLayer = func(name string, r io.Reader) error {
+			if opts.Verbose {
+				fmt.Fprintf(os.Stderr, "extracting %s\n", name)
+			}
+			dst, err := layerDst(workingDir, rootFS, 0, opts)
+			if err != nil {
+				return err
+			}
+			if err := extractLayer(r, name, dst, opts); err != nil {
+				return err
+			}
+			return layerDone(dst, opts)
+		}
+	}
+	s := newImageSpooler(workingDir, streamLayer)
+	if err := acbrun.WalkTarGz(r, s.spoolEntry); err != nil {
+		return err
+	}
+	manifest, err := s.finish()
+	if err != nil || s.streamed {
+		return err
+	}
+	return applyLayers(workingDir, rootFS, manifest, opts)
+}
+
+// layerDst is the directory which the n-th layer of the image is extracted to.
+func layerDst(workingDir, rootFS string, n int, opts extractImageOptions) (string, error) {
+	if !opts.Overlay {
+		return rootFS, nil
+	}
+	dir := overlayLayerDir(workingDir, n)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// layerDone finishes off a layer once it has been extracted to dst.
+func layerDone(dst string, opts extractImageOptions) error {
+	if !opts.Overlay {
+		return nil
+	}
+	return convertWhiteouts(dst)
+}
+
+// applyLayers applies the manifest's layers, which have been extracted along with
+// the rest of the image to workingDir, to rootFS.
+func applyLayers(workingDir, rootFS string, manifest Manifest, opts extractImageOptions) error {
+	layers, err := layersInDiffIDOrder(workingDir, manifest)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(layers, manifest.Layers) {
+		fmt.Fprintf(os.Stderr, "WARNING: the manifest lists the layers in a different order from the config's DiffIDs; applying them in the config's order\n")
+	}
+	for i, layer := range layers {
+		if opts.Verbose {
+			fmt.Fprintf(os.Stderr, "extracting %s\n", layer)
+		}
+		dst, err := layerDst(workingDir, rootFS, i, opts)
+		if err != nil {
+			return err
+		}
+		layerPath := filepath.Join(workingDir, layer)
+		err = extractLayerFile(layerPath, dst, opts)
+		if err != nil {
+			return err
+		}
+		err = layerDone(dst, opts)
+		if err != nil {
+			return err
+		}
+		if opts.KeepLayers {
+			fmt.Fprintf(os.Stderr, "keeping layer %s\n", layerPath)
+		}
+	}
+	return nil
+}
+
+// layersInDiffIDOrder returns the manifest's layers, which were extracted to
+// workingDir, in the order of the config's RootFS.DiffIDs, which is the order they
+// must be applied in; each layer is matched by the digest of its uncompressed
+// contents. The manifest's order is used when the config lists no DiffIDs.
+func layersInDiffIDOrder(workingDir string, manifest Manifest) ([]string, error) {
+	imageConfig, err := readImageConfig(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := imageConfig.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		return manifest.Layers, nil
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("config lists %d layers but the manifest lists %d", len(diffIDs), len(manifest.Layers))
+	}
+	if len(diffIDs) == 1 {
+		// a single layer can only be applied in one order, so it is not hashed
+		return manifest.Layers, nil
+	}
+	layerByDiffID := map[digest.Digest]string{}
+	for _, layer := range manifest.Layers {
+		diffID, err := layerDiffID(filepath.Join(workingDir, layer))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", layer, err)
+		}
+		layerByDiffID[diffID] = layer
+	}
+	layers := make([]string, len(diffIDs))
+	for i, diffID := range diffIDs {
+		layer, ok := layerByDiffID[diffID]
+		if !ok {
+			return nil, fmt.Errorf("none of the manifest's layers match config diff id %s", diffID)
+		}
+		layers[i] = layer
+	}
+	for _, layer := range manifest.Layers {
+		if !slices.Contains(layers, layer) {
+			return nil, fmt.Errorf("layer %s does not match any of the config's diff ids", layer)
+		}
+	}
+	return layers, nil
+}
+
+// layerDiffID returns the digest of the uncompressed contents of the layer at layerPath.
+func layerDiffID(layerPath string) (digest.Digest, error) {
+	r, err := os.Open(layerPath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	uncompressedStream, err := acbrun.NewDecompressReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer uncompressedStream.Close()
+	return digest.SHA256.FromReader(uncompressedStream)
+}
+
+func extractLayerFile(layerPath, rootFS string, opts extractImageOptions) error {
+	r, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extractLayer(r, filepath.Base(layerPath), rootFS, opts)
+}
+
+func extractLayer(r io.Reader, name, rootFS string, opts extractImageOptions) error {
+	var stats acbrun.ExtractStats
+	diffID, err := acbrun.StreamLayer(r, rootFS, acbrun.LayerOptions{
+		ExtractOptions: opts.Extract,
+		// overlayfs has its own whiteout format, which convertWhiteouts produces
+		KeepWhiteouts: opts.Overlay,
+		Stats:         &stats,
+	})
+	if errors.Is(err, acbrun.ErrSkippedEntries) {
+		fmt.Fprintf(os.Stderr, "WARNING: %d entries of %s could not be extracted and were skipped\n", stats.Skipped, name)
+	} else if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		fmt.Fprintf(os.Stderr, "extracted %s (%s): %s\n", name, diffID, stats)
+	}
+	return nil
+}
+
+// removeWorkingDir removes workingDir, or when keepLayers is set, everything in
+// it except for the extracted image files (i.e. the rootfs and runtime files).
+func removeWorkingDir(workingDir string, keepLayers bool) error {
+	if !keepLayers {
+		return os.RemoveAll(workingDir)
+	}
+	for _, name := range []string{"rootfs", "config.json", "runc.log"} {
+		if err := os.RemoveAll(filepath.Join(workingDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileFromReader(dst string, r io.Reader) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTestImage writes a single layer image holding files to imagePath, listing
+// its manifest before or after the layer.
+func writeTestImage(tb testing.TB, imagePath string, files map[string]string, manifestFirst bool) {
+	tb.Helper()
+	var layerTar bytes.Buffer
+	tw := tar.NewWriter(&layerTar)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	dirs := map[string]bool{}
+	for _, name := range names {
+		if dir := path.Dir(name); !dirs[dir] {
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755}); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		contents := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	diffID := sha256.Sum256(layerTar.Bytes())
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	gw.Write(layerTar.Bytes())
+	gw.Close()
+
+	entries := [][2]string{
+		{"config.json", fmt.Sprintf(`{"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:%s"]}}`, hex.EncodeToString(diffID[:]))},
+		{"layer.tar.gz", layer.String()},
+	}
+	manifest := [2]string{"manifest.json", `[{"Config":"config.json","Layers":["layer.tar.gz"]}]`}
+	if manifestFirst {
+		entries = append([][2]string{manifest}, entries...)
+	} else {
+		entries = append(entries, manifest)
+	}
+
+	f, err := os.Create(imagePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	gw = gzip.NewWriter(f)
+	tw = tar.NewWriter(gw)
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: entry[0], Mode: 0644, Size: int64(len(entry[1]))}); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry[1])); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestExtractImageSingleLayer(t *testing.T) {
+	files := map[string]string{"etc/greeting": "hello\n", "data/file": "contents\n"}
+	for _, manifestFirst := range []bool{true, false} {
+		t.Run(fmt.Sprintf("manifestFirst=%v", manifestFirst), func(t *testing.T) {
+			dir := t.TempDir()
+			image := filepath.Join(dir, "image.tar.gz")
+			writeTestImage(t, image, files, manifestFirst)
+			workingDir := filepath.Join(dir, "work")
+			rootFS := filepath.Join(workingDir, "rootfs")
+			if err := os.MkdirAll(rootFS, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := extractImage(image, workingDir, rootFS, extractImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			for name, expected := range files {
+				actual, err := os.ReadFile(filepath.Join(rootFS, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(actual) != expected {
+					t.Errorf("%s: expected %q, got %q", name, expected, actual)
+				}
+			}
+			// the layer is only written out when the manifest comes after it
+			_, err := os.Stat(filepath.Join(workingDir, "layer.tar.gz"))
+			if spooled := err == nil; spooled == manifestFirst {
+				t.Errorf("expected the layer to be spooled: %v, got: %v", !manifestFirst, spooled)
+			}
+			for _, name := range []string{"manifest.json", "config.json"} {
+				if _, err := os.Stat(filepath.Join(workingDir, name)); err != nil {
+					t.Errorf("expected %s to be written to the working directory: %s", name, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExtractImage measures extracting an image of few, incompressible files,
+// so that the time taken is dominated by how often the image is decompressed.
+func BenchmarkExtractImage(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	files := map[string]string{}
+	for i := 0; i < 64; i++ {
+		data := make([]byte, 256<<10)
+		rng.Read(data)
+		files[fmt.Sprintf("dir%d/file%d", i%8, i)] = string(data)
+	}
+	for _, manifestFirst := range []bool{true, false} {
+		b.Run(fmt.Sprintf("manifestFirst=%v", manifestFirst), func(b *testing.B) {
+			dir := b.TempDir()
+			image := filepath.Join(dir, "image.tar.gz")
+			writeTestImage(b, image, files, manifestFirst)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				workingDir := filepath.Join(dir, fmt.Sprintf("work%d", i))
+				rootFS := filepath.Join(workingDir, "rootfs")
+				if err := os.MkdirAll(rootFS, 0755); err != nil {
+					b.Fatal(err)
+				}
+				if err := extractImage(image, workingDir, rootFS, extractImageOptions{}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(workingDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
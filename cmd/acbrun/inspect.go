@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexcb/acbrun/v2"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// imageInspection is what the inspect command reports about an image.
+type imageInspection struct {
+	RepoTags     []string      `json:"repo_tags"`
+	LayerCount   int           `json:"layer_count"`
+	TotalSize    int64         `json:"total_size"` // size of the layer archives, as stored in the image
+	ConfigDigest digest.Digest `json:"config_digest,omitempty"`
+}
+
+// inspectImage reads an image's manifest and config without extracting its layers.
+func inspectImage(image string) (imageInspection, error) {
+	manifest, err := readImageManifest(image)
+	if err != nil {
+		return imageInspection{}, err
+	}
+	inspection := imageInspection{
+		RepoTags:   manifest.RepoTags,
+		LayerCount: len(manifest.Layers),
+	}
+	if inspection.RepoTags == nil {
+		inspection.RepoTags = []string{}
+	}
+	layers := map[string]bool{}
+	for _, layer := range manifest.Layers {
+		layers[path.Clean(layer)] = true
+	}
+
+	r, err := os.Open(image)
+	if err != nil {
+		return imageInspection{}, err
+	}
+	defer r.Close()
+	err = acbrun.WalkTarGz(r, func(header *tar.Header, tr io.Reader) error {
+		name := path.Clean(header.Name)
+		if layers[name] {
+			inspection.TotalSize += header.Size
+		}
+		if manifest.Config != "" && name == path.Clean(manifest.Config) {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return err
+			}
+			inspection.ConfigDigest = digest.NewDigest(digest.SHA256, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return imageInspection{}, err
+	}
+	return inspection, nil
+}
+
+func printImageInspection(w io.Writer, inspection imageInspection, format string) error {
+	if format == "json" {
+		data, err := json.Marshal(inspection)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "REPO TAGS\t%s\n", strings.Join(inspection.RepoTags, ", "))
+	fmt.Fprintf(tw, "LAYERS\t%d\n", inspection.LayerCount)
+	fmt.Fprintf(tw, "TOTAL SIZE\t%d\n", inspection.TotalSize)
+	fmt.Fprintf(tw, "CONFIG DIGEST\t%s\n", inspection.ConfigDigest)
+	return tw.Flush()
+}
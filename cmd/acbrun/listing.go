@@ -0,0 +1,199 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// layerEntry is an entry of a layer, as listed by --list-layer-entries.
+type layerEntry struct {
+	Layer    string `json:"layer"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Mode     string `json:"mode"`
+	Size     int64  `json:"size"`
+	Linkname string `json:"linkname,omitempty"`
+}
+
+// layerEntryTypes names the tar entry types for listings.
+var layerEntryTypes = map[byte]string{
+	tar.TypeReg:     "file",
+	tar.TypeLink:    "hardlink",
+	tar.TypeSymlink: "symlink",
+	tar.TypeChar:    "char",
+	tar.TypeBlock:   "block",
+	tar.TypeDir:     "dir",
+	tar.TypeFifo:    "fifo",
+}
+
+// listLayerEntries returns the entries of each of the image's layers, in the
+// order they are applied, and within each layer in the order they are stored,
+// without extracting anything.
+func listLayerEntries(image string) ([]layerEntry, error) {
+	manifest, err := readImageManifest(image)
+	if err != nil {
+		return nil, err
+	}
+	entriesByLayer := map[string][]layerEntry{}
+	for _, layer := range manifest.Layers {
+		entriesByLayer[path.Clean(layer)] = nil
+	}
+
+	r, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	err = acbrun.WalkTarGz(r, func(header *tar.Header, tr io.Reader) error {
+		name := path.Clean(header.Name)
+		if _, ok := entriesByLayer[name]; !ok {
+			return nil
+		}
+		entries, err := readLayerEntries(name, tr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		entriesByLayer[name] = entries
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []layerEntry
+	for _, layer := range manifest.Layers {
+		entries = append(entries, entriesByLayer[path.Clean(layer)]...)
+	}
+	return entries, nil
+}
+
+func readLayerEntries(layer string, r io.Reader) ([]layerEntry, error) {
+	uncompressedStream, err := acbrun.NewDecompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer uncompressedStream.Close()
+	var entries []layerEntry
+	tr := tar.NewReader(uncompressedStream)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entryType, ok := layerEntryTypes[header.Typeflag]
+		if !ok {
+			entryType = fmt.Sprintf("other (%q)", header.Typeflag)
+		}
+		entries = append(entries, layerEntry{
+			Layer:    layer,
+			Name:     header.Name,
+			Type:     entryType,
+			Mode:     header.FileInfo().Mode().String(),
+			Size:     header.Size,
+			Linkname: header.Linkname,
+		})
+	}
+}
+
+func printLayerEntries(w io.Writer, entries []layerEntry, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "LAYER\tTYPE\tMODE\tSIZE\tNAME\n")
+	for _, entry := range entries {
+		name := entry.Name
+		if entry.Linkname != "" {
+			name += " -> " + entry.Linkname
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", entry.Layer, entry.Type, entry.Mode, entry.Size, name)
+	}
+	return tw.Flush()
+}
+
+// layerSize is the uncompressed size of a layer, as estimated by --estimate-size.
+type layerSize struct {
+	Layer string `json:"layer"`
+	Size  int64  `json:"size"`
+}
+
+// estimateLayerSizes returns the uncompressed size of each of the image's layers,
+// in the order they are applied (see acbrun.LayerUncompressedSize).
+func estimateLayerSizes(image string) ([]layerSize, error) {
+	manifest, err := readImageManifest(image)
+	if err != nil {
+		return nil, err
+	}
+	sizeByLayer := map[string]int64{}
+	for _, layer := range manifest.Layers {
+		sizeByLayer[path.Clean(layer)] = -1
+	}
+
+	r, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	err = acbrun.WalkTarGz(r, func(header *tar.Header, tr io.Reader) error {
+		name := path.Clean(header.Name)
+		if _, ok := sizeByLayer[name]; !ok {
+			return nil
+		}
+		size, err := acbrun.LayerUncompressedSize(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		sizeByLayer[name] = size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]layerSize, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		size := sizeByLayer[path.Clean(layer)]
+		if size < 0 {
+			return nil, fmt.Errorf("layer %s is missing", layer)
+		}
+		sizes = append(sizes, layerSize{Layer: layer, Size: size})
+	}
+	return sizes, nil
+}
+
+func printLayerSizes(w io.Writer, sizes []layerSize, format string) error {
+	var total int64
+	for _, size := range sizes {
+		total += size.Size
+	}
+	if format == "json" {
+		return json.NewEncoder(w).Encode(struct {
+			Layers []layerSize `json:"layers"`
+			Total  int64       `json:"total"`
+		}{sizes, total})
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "LAYER\tSIZE\n")
+	for _, size := range sizes {
+		fmt.Fprintf(tw, "%s\t%d\n", size.Layer, size.Size)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\n", total)
+	return tw.Flush()
+}
@@ -2,19 +2,29 @@ package main
 
 import (
 	"crypto/sha256"
+	"debug/elf"
 	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alexcb/acbrun/v2"
 	"github.com/jessevdk/go-flags"
 	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
@@ -24,42 +34,697 @@ var configJSONTemplate string
 var opts struct {
 	// Slice of bool will append 'true' each time the option
 	// is encountered (can be set multiple times, like -vvv)
-	Verbose      []bool `short:"v" long:"verbose" description:"Show verbose debug information"`
-	Keep         bool   `long:"keep" description:"Keep temporary working directory"`
-	HostNetwork  bool   `long:"host-network" description:"Allow host network access"`
-	BindLocalDir bool   `long:"bind-local-dir" description:"Bind current working directory to /local-dir"`
-	Reentrant    bool   `long:"reentrant" description:"Keep container filesystem intact and allow multiple or concurrent runs"`
-	Interactive  bool   `long:"interactive" description:"pass through stdin"`
-	Output       string `long:"output" description:"Output image after execution"`
-	Name         string `long:"name" description:"Container name"`
+	Verbose               []bool        `short:"v" long:"verbose" description:"Show verbose debug information"`
+	VerboseRunc           bool          `long:"verbose-runc" description:"Run runc with --debug, logging to the working directory, and show its log when the container fails (implied by -vvv)"`
+	Keep                  bool          `long:"keep" description:"Keep temporary working directory"`
+	CNI                   string        `long:"cni" description:"Configure the container's network namespace with the CNI plugin of the given network config file, run by prestart and poststop hooks (plugins are found in CNI_PATH, or /opt/cni/bin)"`
+	HostNetwork           bool          `long:"host-network" description:"Allow host network access (deprecated; use --network=host)"`
+	Network               string        `long:"network" description:"Network mode: none (isolated, the default), host (share the host network), bridge, or container:<name> (share the network of a running container)"`
+	PidNamespace          string        `long:"pid-namespace" choice:"private" choice:"host" default:"private" description:"PID namespace of the container: private (its process is PID 1, and it sees only its own processes) or host (share the host's PID namespace)"`
+	DevFull               bool          `long:"dev-full" description:"Bind mount the host's /dev into the container, with access to all of its devices, rather than the minimal default set (this is insecure)"`
+	BindLocalDir          bool          `long:"bind-local-dir" description:"Bind current working directory to /local-dir"`
+	RoBind                []string      `long:"ro-bind" description:"Bind mount a host path read-only into the container, as <host path>:<container path> (may be repeated)"`
+	Reentrant             bool          `long:"reentrant" description:"Keep container filesystem intact and allow multiple or concurrent runs"`
+	Restart               bool          `long:"restart" description:"After running the command, keep the reentrant container running, restarting it with a backoff whenever it stops"`
+	MaxRestarts           int           `long:"max-restarts" default:"10" description:"Number of times --restart restarts the container before giving up (0 restarts it without limit)"`
+	Interactive           bool          `long:"interactive" description:"pass through stdin"`
+	Output                string        `long:"output" description:"Output image after execution"`
+	OutputDir             string        `long:"output-dir" description:"Output the image after execution as an OCI image layout (oci-layout, index.json, and blobs) in the given directory, which must be empty or not exist"`
+	Tag                   []string      `long:"tag" description:"Name the output image, e.g. myimage:1.0, so that docker load tags it (can be repeated; the tag defaults to latest)"`
+	Name                  string        `long:"name" description:"Container name"`
+	OOMScoreAdj           *int          `long:"oom-score-adj" description:"Adjust the container process OOM killer score (-1000 to 1000)"`
+	Squash                bool          `long:"squash" description:"Flatten the output image into a single layer with a reproducible digest"`
+	PreserveHeaders       bool          `long:"preserve-headers" description:"Record the image's tar headers when extracting it, and reuse them in the output for files the command did not change, keeping user and group names, modification times, and extended attributes"`
+	OutputGzipMetadata    bool          `long:"output-gzip-metadata" description:"Record the output file name and creation time in the output image's gzip header"`
+	OutputCompression     string        `long:"output-compression" choice:"gzip" choice:"zstd" default:"gzip" description:"Compression of the output image's layer (zstd requires the zstd command)"`
+	ZstdDict              string        `long:"zstd-dict" description:"Dictionary (see zstd --train) to compress the output layer with when --output-compression=zstd, and to decompress the image's zstd layers with"`
+	OutputExclude         []string      `long:"output-exclude" description:"Exclude paths matching a gitignore-style pattern from the output image (may be repeated)"`
+	MountSecret           []string      `long:"mount-secret" description:"Bind mount a host file read-only for the run only, leaving it out of the output image, e.g. id=token,src=./token,target=/run/secrets/token (may be repeated)"`
+	MountsFile            string        `long:"mounts-file" description:"Append the OCI mount objects of a JSON array in the given file to the container's mounts"`
+	MountCache            []string      `long:"mount-cache" description:"Mount a persistent cache directory shared across runs, kept in /var/lib/acbrun/cache, e.g. id=gomod,target=/root/go/pkg/mod (may be repeated)"`
+	PoststopHook          []string      `long:"poststop-hook" description:"Run a host command after the container stops, e.g. \"/usr/local/bin/flush-metrics --all\" (may be repeated)"`
+	PoststartHook         []string      `long:"poststart-hook" description:"Run a host command once the container's process has started, e.g. to notify a supervisor that it is ready (may be repeated)"`
+	MaskPath              []string      `long:"mask-path" description:"Mask an additional path inside the container (may be repeated)"`
+	ReadonlyPath          []string      `long:"readonly-path" description:"Make an additional path inside the container read-only (may be repeated)"`
+	GroupAdd              []string      `long:"group-add" description:"Add a supplementary group id to the container process (may be repeated)"`
+	Cwd                   string        `long:"cwd" description:"Working directory of the container process"`
+	CwdCreate             bool          `long:"cwd-create" description:"Create the working directory inside the container when it does not exist"`
+	Init                  bool          `long:"init" description:"Run an init process as PID 1 which reaps zombies and forwards signals"`
+	InitPath              string        `long:"init-path" default:"tini" description:"Statically linked init binary used by --init"`
+	PlatformVariant       string        `long:"platform-variant" description:"CPU variant of the image platform, e.g. v7 for arm/v7"`
+	DumpBundle            string        `long:"dump-bundle" description:"When the container fails to start, copy its config.json and a listing of its rootfs to this directory (combine with --keep to re-run it by hand)"`
+	ResolvConf            string        `long:"resolv-conf" description:"Bind mount a host file read-only over /etc/resolv.conf"`
+	HostnameFile          string        `long:"hostname-file" description:"Bind mount a host file read-only over /etc/hostname"`
+	KeepLayers            bool          `long:"keep-layers" description:"Keep the image's layer archives in the working directory after extraction"`
+	StopSignal            string        `long:"stop-signal" description:"Signal used to stop the container when acbrun is interrupted or terminated (default: the image's StopSignal or SIGTERM)"`
+	Detach                bool          `long:"detach" description:"Start the container in the background, print its name, and exit without waiting for it (the working directory is left in place)"`
+	Overlay               bool          `long:"overlay" description:"Extract each layer to its own directory and mount them as an overlay on the rootfs, rather than merging them"`
+	Upperdir              string        `long:"upperdir" description:"With --overlay, use the given host directory as the overlay's writable layer, so changes made by the container are kept after it exits"`
+	Dedup                 bool          `long:"dedup" description:"Hard-link identical files when extracting the image to save disk space"`
+	CacheSnapshots        bool          `long:"cache-snapshots" description:"Keep a snapshot of each extracted image, keyed by its sha256, and clone it on later runs of the same image instead of extracting it again"`
+	SummaryJSON           string        `long:"summary-json" description:"Write a JSON summary of the run, including how long each phase took, to the given path"`
+	ExitCodeFile          string        `long:"exit-code-file" description:"Write the exit code of the container's command to the given path once it has run"`
+	MergeStderr           bool          `long:"merge-stderr" description:"Send the container's stderr to stdout, so both are written to a single stream in the order they were written"`
+	BaseConfig            string        `long:"base-config" description:"Start from the runtime spec in the given config.json rather than the built-in one; all other flags are still applied on top of it"`
+	ConfigOverride        []string      `long:"config-override" description:"Set a field of the generated config.json, after all other flags are applied, as <sjson path>=<json value> (can be repeated)"`
+	Format                string        `long:"format" choice:"table" choice:"json" default:"table" description:"Output format of the inspect command, --list-layer-entries, and --estimate-size"`
+	EntrypointShellEscape bool          `long:"entrypoint-shell-escape" description:"Accept the command as multiple arguments, which are quoted for the shell and joined with spaces"`
+	Exec                  bool          `long:"exec" description:"Accept the command as multiple arguments, which are run directly as the process argv rather than via sh -c"`
+	Argv0                 string        `long:"argv0" description:"Run the command with the given argv[0] rather than its path (with --exec, --args-json, or --entrypoint-from-image; requires acbrun to be statically linked, as it is mounted into the container to run the command)"`
+	EntrypointWrapper     string        `long:"entrypoint-wrapper" description:"Command prepended to the command's process args, split on whitespace, e.g. 'tini --' to run it under a supervisor"`
+	ArgsJSON              string        `long:"args-json" description:"Run the process argv given as a JSON array of strings, e.g. '[\"echo\",\"hello world\"]', in place of the command argument"`
+	Healthcheck           string        `long:"healthcheck" description:"Command run inside a reentrant container to check that it is healthy before running the given command"`
+	HealthcheckInterval   time.Duration `long:"healthcheck-interval" default:"1s" description:"How often --wait-healthy runs the healthcheck"`
+	WaitHealthy           time.Duration `long:"wait-healthy" description:"Keep running the healthcheck until it passes, failing if it has not passed within the given duration"`
+	RootfsTmpfs           string        `long:"rootfs-tmpfs" optional:"yes" optional-value:"50%" description:"Extract the image onto a tmpfs of the given size (e.g. 2g, default 50% of RAM); requires privileges"`
+	Env                   []string      `long:"env" description:"Set an environment variable in the container as KEY=VALUE, overriding the image (can be repeated)"`
+	EnvDefault            []string      `long:"env-default" description:"Set an environment variable as KEY=VALUE only if neither the image nor --env defines it (can be repeated)"`
+	CgroupVersion         string        `long:"cgroup-version" choice:"auto" choice:"1" choice:"2" default:"auto" description:"cgroup version of the host, which determines how /sys/fs/cgroup is mounted in the container"`
+	Rootfs                string        `long:"rootfs" description:"Use an already extracted directory as the container root instead of an image; the image and sha256sum arguments are then omitted"`
+	CommitMessage         string        `long:"commit-message" description:"Comment recorded in the output image's history entry"`
+	SourceDateEpoch       string        `long:"source-date-epoch" env:"SOURCE_DATE_EPOCH" description:"Unix timestamp used for the output image's created time, and to which file modification times are clamped, for reproducible outputs"`
+	Copy                  []string      `long:"copy" description:"Copy a host file or directory into the rootfs before running, as <src>:<dest>; a dest ending in / copies into that directory (can be repeated)"`
+	Compose               string        `long:"compose" description:"Start the reentrant containers described by a JSON spec file, in order, sharing a network namespace"`
+	ExpectRootfsDigest    string        `long:"expect-rootfs-digest" description:"Fail unless the digest of the rootfs, archived deterministically after extraction, matches the given value (e.g. sha256:...)"`
+	GzipReadahead         bool          `long:"gzip-readahead" description:"Decompress gzip layers on a separate thread, ahead of extracting them"`
+	BestEffort            bool          `long:"best-effort" description:"Skip image entries which fail to extract, with a warning, rather than aborting"`
+	EntrypointFromImage   bool          `long:"entrypoint-from-image" description:"Run the image's Entrypoint with the command arguments (or the image's Cmd when none are given) as its arguments, like docker run"`
+	ListLayerEntries      bool          `long:"list-layer-entries" description:"List the entries of each of the image's layers, in order, without extracting or running anything (see --format)"`
+	EstimateSize          bool          `long:"estimate-size" description:"Show the total size of the files in each of the image's layers, i.e. roughly the disk space extracting it takes, without extracting anything (see --format)"`
+	ExtractOnly           []string      `long:"extract-only" description:"Extract only the given path, and everything beneath it, from the image into --extract-dir without running a container (can be repeated)"`
+	ExtractDir            string        `long:"extract-dir" description:"Directory which --extract-only extracts to; it is created if needed"`
+	ExtractConcurrency    int           `long:"extract-concurrency" description:"Maximum number of files written at once, and so held open, while extracting the image (default: based on the CPU count and open file limit)"`
+	SelinuxLabel          string        `long:"selinux-label" description:"SELinux context of the container process and its mounts, e.g. system_u:system_r:container_t:s0:c1,c2"`
+	Selinux               string        `long:"selinux" choice:"enabled" choice:"disabled" default:"enabled" description:"Whether SELinux labels are set; disabled omits them from config.json, even if set by the template or --config-override"`
+	Nice                  *int          `long:"nice" description:"Run the container process at the given niceness (-20 to 19; lower values require privileges)"`
+	CpusetCpus            string        `long:"cpuset-cpus" description:"CPUs the container may run on, as a list of CPU numbers and ranges, e.g. 0-3 or 0,2"`
+	BlkioWeight           *int          `long:"blkio-weight" description:"Relative block IO weight of the container (10 to 1000)"`
+	Umask                 string        `long:"umask" description:"Octal umask of the container's process, e.g. 0022 (requires runc 1.1 or later)"`
+	SocketGroup           string        `long:"socket-group" description:"Group (name or gid) whose members may connect to the serve socket, which is otherwise only accessible to its owner; connecting is root-equivalent, as requests may bind mount any host path"`
 }
 
-type Manifest struct {
-	Config   string   `json:"Config,omitempty"`
-	RepoTags []string `json:"RepoTags,omitempty"`
-	Layers   []string `json:"Layers,omitempty"`
+var platformVariantRegexp = regexp.MustCompile(`^v[0-9]+$`)
+
+// containerNameRegexp matches the names which are safe to pass to runc and to use
+// in the working directory path of a reentrant container.
+var containerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func validateContainerName(name string) error {
+	if !containerNameRegexp.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid container name %q; only letters, digits, _, ., and - may be used", name)
+	}
+	return nil
+}
+
+// imageReferenceRegexp matches a docker image reference without a digest, such as
+// alpine, registry.example.com:5000/team/app:1.0; the tag is captured.
+var imageReferenceRegexp = regexp.MustCompile(`^` +
+	`(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` + // registry
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` + // repository
+	`(:[\w][\w.-]{0,127})?$`) // tag
+
+// normalizeImageTag validates a --tag value, adding the latest tag if it has none.
+func normalizeImageTag(ref string) (string, error) {
+	m := imageReferenceRegexp.FindStringSubmatch(ref)
+	if m == nil || len(ref) > 255 {
+		return "", fmt.Errorf("invalid image reference %q; expected e.g. myimage:1.0", ref)
+	}
+	if m[1] == "" {
+		ref += ":latest"
+	}
+	return ref, nil
+}
+
+// selinuxLabelRegexp matches an SELinux context of the form user:role:type, with an
+// optional MLS/MCS level (which may itself contain colons).
+var selinuxLabelRegexp = regexp.MustCompile(`^[^:\s]+:[^:\s]+:[^:\s]+(:\S+)?$`)
+
+// validateCpuset checks that s is a cpuset list as used by the cpuset cgroup
+// controller: comma separated CPU numbers and inclusive ranges, e.g. "0-3,6".
+func validateCpuset(s string) error {
+	for _, field := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(field, "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid cpu %q", first)
+		}
+		end, err := strconv.ParseUint(last, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid cpu %q", last)
+		}
+		if end < start {
+			return fmt.Errorf("invalid range %q", field)
+		}
+	}
+	return nil
+}
+
+// stateDir holds state which outlives a single acbrun invocation, such as
+// reentrant containers and caches.
+const stateDir = "/tmp"
+
+// cacheRoot holds the --mount-cache directories, keyed by their ids. It is kept out
+// of stateDir, where any user can create files: a cache planted there by another
+// user would be mounted into the container.
+const cacheRoot = "/var/lib/acbrun/cache"
+
+// execFallbackDir is used for working directories when the usual location is
+// mounted noexec.
+const execFallbackDir = "/var/tmp"
+
+// execDir returns dir, unless it is mounted noexec, in which case the container's
+// binaries could not be run from it and execFallbackDir is returned instead (if it
+// is itself usable).
+func execDir(dir string) string {
+	noexec, err := acbrun.IsNoexec(dir)
+	if err != nil || !noexec {
+		return dir
+	}
+	if fallbackNoexec, err := acbrun.IsNoexec(execFallbackDir); err == nil && !fallbackNoexec {
+		fmt.Fprintf(os.Stderr, "WARNING: %s is mounted noexec; using %s for the working directory instead\n", dir, execFallbackDir)
+		return execFallbackDir
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s is mounted noexec; binaries in the container will likely fail to run (set TMPDIR to an exec mount)\n", dir)
+	return dir
+}
+
+type cacheMount struct {
+	id     string
+	target string
+}
+
+// parseKeyValueOptions parses comma separated key=value pairs, e.g. "id=foo,target=/bar".
+func parseKeyValueOptions(s string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value but got %q", field)
+		}
+		if _, exists := values[k]; exists {
+			return nil, fmt.Errorf("duplicate key %q", k)
+		}
+		values[k] = v
+	}
+	return values, nil
 }
 
-func getLayers(manifestPath string) ([]string, error) {
-	manifestFile, err := os.Open(manifestPath)
+func parseCacheMount(s string) (cacheMount, error) {
+	values, err := parseKeyValueOptions(s)
 	if err != nil {
-		return nil, err
+		return cacheMount{}, err
+	}
+	m := cacheMount{
+		id:     values["id"],
+		target: values["target"],
+	}
+	delete(values, "id")
+	delete(values, "target")
+	for k := range values {
+		return cacheMount{}, fmt.Errorf("unknown key %q", k)
+	}
+	if m.id == "" || strings.ContainsAny(m.id, "/\\") || m.id == "." || m.id == ".." {
+		return cacheMount{}, fmt.Errorf("invalid id %q", m.id)
+	}
+	if !filepath.IsAbs(m.target) {
+		return cacheMount{}, fmt.Errorf("target must be an absolute path; got %q", m.target)
+	}
+	return m, nil
+}
+
+// ensureCacheDir returns the directory of the cache id under root, creating root
+// and the cache with mode 0700. It refuses to use either unless it is a directory
+// owned by the current user, and root unless no other user can access it, since
+// whoever controls a cache controls what the container sees.
+func ensureCacheDir(root, id string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, id)
+	for _, d := range []string{root, dir} {
+		if err := os.Mkdir(d, 0700); err != nil && !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		info, err := os.Lstat(d)
+		if err != nil {
+			return "", err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() {
+			return "", fmt.Errorf("%s is not a directory owned by uid %d", d, os.Geteuid())
+		}
+		if d == root && info.Mode().Perm()&0077 != 0 {
+			return "", fmt.Errorf("%s is accessible to other users (mode %s)", d, info.Mode().Perm())
+		}
+	}
+	return dir, nil
+}
+
+// secretMount is a host file mounted read-only into the container which is
+// never archived into the output image.
+type secretMount struct {
+	id     string
+	source string
+	target string
+}
+
+func parseSecretMount(s string) (secretMount, error) {
+	values, err := parseKeyValueOptions(s)
+	if err != nil {
+		return secretMount{}, err
+	}
+	m := secretMount{
+		id:     values["id"],
+		source: values["src"],
+		target: values["target"],
+	}
+	delete(values, "id")
+	delete(values, "src")
+	delete(values, "target")
+	for k := range values {
+		return secretMount{}, fmt.Errorf("unknown key %q", k)
+	}
+	if m.id == "" {
+		return secretMount{}, fmt.Errorf("id is required")
+	}
+	if m.source == "" {
+		return secretMount{}, fmt.Errorf("src is required")
+	}
+	if !filepath.IsAbs(m.target) || filepath.Clean(m.target) == "/" {
+		return secretMount{}, fmt.Errorf("target must be an absolute path; got %q", m.target)
+	}
+	m.source, err = filepath.Abs(m.source)
+	if err != nil {
+		return secretMount{}, err
+	}
+	m.target = filepath.Clean(m.target)
+	return m, nil
+}
+
+// excludePattern returns an --output-exclude pattern which matches exactly the
+// absolute container path p.
+func excludePattern(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type bindSpec struct {
+	source      string
+	destination string
+}
+
+// parseBindSpec parses a --ro-bind value of the form <host path>:<container path>,
+// where the container path must be absolute.
+func parseBindSpec(s string) (bindSpec, error) {
+	source, destination, ok := strings.Cut(s, ":")
+	if !ok || source == "" {
+		return bindSpec{}, fmt.Errorf("expected <host path>:<container path>")
+	}
+	if !filepath.IsAbs(destination) {
+		return bindSpec{}, fmt.Errorf("container path must be absolute; got %q", destination)
+	}
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return bindSpec{}, err
+	}
+	return bindSpec{source: source, destination: filepath.Clean(destination)}, nil
+}
+
+type copySpec struct {
+	source      string
+	destination string
+}
+
+// parseCopySpec parses a --copy value of the form <src>:<dest>, where src is a host
+// path and dest an absolute path in the container.
+func parseCopySpec(s string) (copySpec, error) {
+	source, destination, ok := strings.Cut(s, ":")
+	if !ok || source == "" {
+		return copySpec{}, fmt.Errorf("expected <src>:<dest>")
+	}
+	if !filepath.IsAbs(destination) {
+		return copySpec{}, fmt.Errorf("dest must be an absolute path; got %q", destination)
+	}
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return copySpec{}, err
+	}
+	if _, err := os.Lstat(source); err != nil {
+		return copySpec{}, err
+	}
+	if strings.HasSuffix(destination, "/") {
+		destination = filepath.Join(destination, filepath.Base(source))
+	}
+	return copySpec{source: source, destination: filepath.Clean(destination)}, nil
+}
+
+// copyIntoRootFS copies c.source to c.destination within rootFS, creating any
+// missing parent directories. Symlinks in the destination's parents are followed
+// as they would be inside the container (see resolveInRootFS); a symlink at the
+// destination itself is replaced.
+func copyIntoRootFS(rootFS string, c copySpec) error {
+	parent, err := resolveInRootFS(rootFS, filepath.Dir(c.destination))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	return acbrun.CopyPath(c.source, filepath.Join(parent, filepath.Base(c.destination)))
+}
+
+// parseArgsJSON parses an --args-json value, which must be a non-empty JSON array
+// of strings.
+func parseArgsJSON(s string) ([]string, error) {
+	var args []string
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of strings: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected at least one argument")
+	}
+	return args, nil
+}
+
+type configOverride struct {
+	path  string
+	value string
+}
+
+// parseConfigOverride parses a --config-override value of the form
+// <sjson path>=<json value>; the path may not itself contain an "=".
+func parseConfigOverride(s string) (configOverride, error) {
+	path, value, ok := strings.Cut(s, "=")
+	if !ok || path == "" {
+		return configOverride{}, fmt.Errorf("expected <path>=<json value>")
+	}
+	if !json.Valid([]byte(value)) {
+		return configOverride{}, fmt.Errorf("value %q is not valid JSON", value)
 	}
-	defer manifestFile.Close()
-	manifestData, err := ioutil.ReadAll(manifestFile)
+	return configOverride{path: path, value: value}, nil
+}
+
+// parseHookCommand splits a hook command into its argv, validating that it
+// refers to an executable on the host; runc requires hook paths to be absolute.
+func parseHookCommand(command string) ([]string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	if !filepath.IsAbs(args[0]) {
+		return nil, fmt.Errorf("%s must be an absolute path", args[0])
+	}
+	info, err := os.Stat(args[0])
 	if err != nil {
 		return nil, err
 	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("%s is not executable", args[0])
+	}
+	return args, nil
+}
+
+// addHook appends a hook to the given lifecycle stage (e.g. "poststop") of configJSON.
+func addHook(configJSON, stage string, args []string) (string, error) {
+	return sjson.Set(configJSON, "hooks."+stage+".-1", map[string]interface{}{
+		"path": args[0],
+		"args": args,
+	})
+}
+
+// bindMountSourceError reports a bind mount source which cannot be used; runc's own
+// error for these cases does not name the path.
+type bindMountSourceError struct {
+	source string
+	err    error
+}
+
+func (e *bindMountSourceError) Error() string {
+	return fmt.Sprintf("bind mount source %s: %s", e.source, e.err)
+}
+
+// validateBindSource checks that source exists on the host, and when the mount is
+// read-only (i.e. the container can only consume it), that it is readable.
+func validateBindSource(source string, readOnly bool) error {
+	if _, err := os.Stat(source); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &bindMountSourceError{source, errors.New("does not exist")}
+		}
+		return &bindMountSourceError{source, err}
+	}
+	if readOnly {
+		f, err := os.Open(source)
+		if err != nil {
+			return &bindMountSourceError{source, errors.New("is not readable")}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// readBaseConfig reads the runtime spec given by --base-config.
+func readBaseConfig(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !json.Valid(data) || !gjson.ParseBytes(data).IsObject() {
+		return "", fmt.Errorf("expected a JSON object")
+	}
+	// the image is always extracted to the bundle's rootfs directory
+	return sjson.Set(string(data), "root.path", "rootfs")
+}
 
-	var result []Manifest
-	err = json.Unmarshal([]byte(manifestData), &result)
+// readMountsFile reads a --mounts-file, returning each of the OCI mount objects of
+// its top-level array as raw JSON.
+func readMountsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	if len(result) != 1 {
-		panic("expected 1 result")
+	var mounts []json.RawMessage
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of mounts: %w", err)
+	}
+	var result []string
+	for i, m := range mounts {
+		mount := gjson.ParseBytes(m)
+		if !mount.IsObject() {
+			return nil, fmt.Errorf("mount %d is not an object", i)
+		}
+		destination := mount.Get("destination")
+		if destination.Type != gjson.String || !filepath.IsAbs(destination.String()) {
+			return nil, fmt.Errorf("mount %d requires an absolute destination", i)
+		}
+		if t := mount.Get("type"); t.Type != gjson.String || t.String() == "" {
+			return nil, fmt.Errorf("mount %d requires a type", i)
+		}
+		result = append(result, string(m))
+	}
+	return result, nil
+}
+
+// addBindMount appends a recursive bind mount of the host path source to
+// destination inside the container, after validating the source with
+// validateBindSource.
+func addBindMount(configJSON, source, destination string, readOnly bool) (string, error) {
+	if err := validateBindSource(source, readOnly); err != nil {
+		return "", err
+	}
+	options := []string{
+		"rbind",
+		"rprivate",
+	}
+	if readOnly {
+		options = append(options, "ro")
+	}
+	return sjson.Set(configJSON, "mounts.-1", map[string]interface{}{
+		"destination": destination,
+		"type":        "bind",
+		"source":      source,
+		"options":     options,
+	})
+}
+
+// removeNamespace removes the namespace of type nsType from the template's
+// namespaces, so that the container shares the host's.
+func removeNamespace(configJSON, nsType string) (string, error) {
+	for i, ns := range gjson.Get(configJSON, "linux.namespaces").Array() {
+		if ns.Get("type").String() == nsType {
+			return sjson.Delete(configJSON, fmt.Sprintf("linux.namespaces.%d", i))
+		}
+	}
+	return configJSON, nil
+}
+
+// useHostDev replaces the template's minimal /dev, a tmpfs populated by runc, with a
+// recursive bind mount of the host's /dev, and allows access to all devices. The
+// container's own devpts, shm, and mqueue are still mounted over it.
+func useHostDev(configJSON string) (string, error) {
+	for i, m := range gjson.Get(configJSON, "mounts").Array() {
+		if m.Get("destination").String() != "/dev" {
+			continue
+		}
+		var err error
+		configJSON, err = sjson.Set(configJSON, fmt.Sprintf("mounts.%d", i), map[string]interface{}{
+			"destination": "/dev",
+			"type":        "bind",
+			"source":      "/dev",
+			"options": []string{
+				"rbind",
+				"rprivate",
+				"nosuid",
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return sjson.Set(configJSON, "linux.resources.devices", []map[string]interface{}{
+		{"allow": true, "access": "rwm"},
+	})
+}
+
+// useCgroup2Mount rewrites the template's cgroup v1 mount of /sys/fs/cgroup to
+// mount the unified cgroup v2 hierarchy instead.
+func useCgroup2Mount(configJSON string) (string, error) {
+	for i, m := range gjson.Get(configJSON, "mounts").Array() {
+		if m.Get("destination").String() != "/sys/fs/cgroup" {
+			continue
+		}
+		var err error
+		configJSON, err = sjson.Set(configJSON, fmt.Sprintf("mounts.%d.type", i), "cgroup2")
+		if err != nil {
+			return "", err
+		}
+		configJSON, err = sjson.Set(configJSON, fmt.Sprintf("mounts.%d.source", i), "cgroup2")
+		if err != nil {
+			return "", err
+		}
+	}
+	return configJSON, nil
+}
+
+// exitOnBindMountError reports an invalid bind mount source as a user error, and
+// panics on anything else.
+func exitOnBindMountError(err error) {
+	var sourceErr *bindMountSourceError
+	if errors.As(err, &sourceErr) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		exitAfterCleanup(1)
+	}
+	panic(err)
+}
+
+// initMountPath is where the --init binary is mounted; /dev is a tmpfs so the
+// mount point never leaks into the rootfs (or an output image).
+const initMountPath = "/dev/init"
+
+// findInitBinary resolves the init binary on the host and ensures it can run
+// inside an arbitrary rootfs, which rules out dynamically linked executables.
+func findInitBinary(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return path, checkStaticBinary(path)
+}
+
+// checkStaticBinary returns an error unless the executable at path is statically
+// linked, and so can run inside any rootfs.
+func checkStaticBinary(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return fmt.Errorf("%s is not statically linked", path)
+		}
+	}
+	return nil
+}
+
+// verifyRootFSDigest checks that the deterministic tar digest of rootFS (see
+// acbrun.TarDigest) is expected.
+func verifyRootFSDigest(rootFS string, expected digest.Digest) error {
+	if expected.Algorithm() != digest.SHA256 {
+		return fmt.Errorf("unsupported rootfs digest algorithm %s", expected.Algorithm())
+	}
+	err := acbrun.VerifyTarDigest(rootFS, expected)
+	if errors.Is(err, acbrun.ErrDigestMismatch) {
+		return fmt.Errorf("rootfs %w", err)
+	}
+	return err
+}
+
+// exitIfRuntimeNotFound reports a missing runc as a user error rather than a panic.
+func exitIfRuntimeNotFound(err error) {
+	if errors.Is(err, acbrun.ErrRuntimeNotFound) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		exitAfterCleanup(1)
+	}
+}
+
+// exitIfInvalidImage reports an image which is not a gzip compressed tar as a user
+// error rather than a panic.
+func exitIfInvalidImage(err error) {
+	if errors.Is(err, acbrun.ErrInvalidArchive) {
+		fmt.Fprintf(os.Stderr, "error: input is not a valid gzip/tar image: %s\n", err)
+		exitAfterCleanup(1)
+	}
+}
+
+// readSha256File reads a hex sha256 digest from a file, accepting an optional
+// "sha256:" prefix as well as the "<digest>  <filename>" format of sha256sum.
+func readSha256File(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	sum := strings.TrimPrefix(fields[0], "sha256:")
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("%s does not contain a sha256 sum", path)
+	}
+	return strings.ToLower(sum), nil
+}
+
+func dumpBundleOnFailure(workingDir, rootFS string) {
+	if opts.DumpBundle == "" {
+		return
+	}
+	err := dumpBundle(opts.DumpBundle, workingDir, rootFS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to dump bundle to %s: %s\n", opts.DumpBundle, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "dumped bundle to %s\n", opts.DumpBundle)
+}
+
+// printRuncLog shows the contents of the log runc was told to write with --log,
+// if it wrote anything.
+func printRuncLog(path string) {
+	if runcLog, err := os.ReadFile(path); err == nil && len(runcLog) > 0 {
+		fmt.Fprintf(os.Stderr, "runc log:\n%s", runcLog)
 	}
-	return result[0].Layers, nil
 }
 
 func isVerbose(verbose []bool) bool {
@@ -67,167 +732,1357 @@ func isVerbose(verbose []bool) bool {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == argv0ExecCommand {
+		// run inside the container for --argv0; its args are the command's own, so
+		// they must not be parsed as flags
+		execWithArgv0(os.Args[2:])
+	}
+
+	args, err := flags.ParseArgs(&opts, os.Args)
+	if err != nil {
+		panic(err)
+	}
+	verbose := isVerbose(opts.Verbose)
+	// -vvv implies --verbose-runc
+	runcDebug := opts.VerboseRunc || len(opts.Verbose) >= 3
+	progName := "acbrun"
+	if len(args) > 0 {
+		progName = args[0]
+	}
+	if len(args) > 1 && args[1] == "inspect" {
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: %s [--format=table|json] inspect <image.tar.gz>\n", progName)
+			os.Exit(1)
+		}
+		inspection, err := inspectImage(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to inspect %s: %s\n", args[2], err)
+			os.Exit(1)
+		}
+		if err := printImageInspection(os.Stdout, inspection, opts.Format); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if len(args) > 1 && args[1] == "serve" {
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: %s [--socket-group <group>] serve <socket>\n", progName)
+			fmt.Fprintf(os.Stderr, "anyone who can connect to the socket can run containers as root with any host path mounted\n")
+			os.Exit(1)
+		}
+		socketGID := -1
+		if opts.SocketGroup != "" {
+			gid, err := lookupGroup(opts.SocketGroup)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --socket-group: %s\n", err)
+				os.Exit(1)
+			}
+			socketGID = gid
+		}
+		cleanupOnSignal(verbose)
+		if err := serve(args[2], socketGID, verbose); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			exitAfterCleanup(1)
+		}
+		return
+	}
+	if len(args) > 1 && args[1] == cniHookCommand {
+		// run by the hooks added by --cni
+		if len(args) != 6 {
+			fmt.Fprintf(os.Stderr, "usage: %s %s ADD|DEL <config> <plugin> <cni path>\n", progName, cniHookCommand)
+			os.Exit(1)
+		}
+		if err := runCNIHook(args[2], args[3], args[4], args[5]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.ListLayerEntries {
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: %s [--format=table|json] --list-layer-entries <image.tar.gz>\n", progName)
+			os.Exit(1)
+		}
+		entries, err := listLayerEntries(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to list the layers of %s: %s\n", args[1], err)
+			os.Exit(1)
+		}
+		if err := printLayerEntries(os.Stdout, entries, opts.Format); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if opts.EstimateSize {
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: %s [--format=table|json] --estimate-size <image.tar.gz>\n", progName)
+			os.Exit(1)
+		}
+		sizes, err := estimateLayerSizes(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to estimate the size of %s: %s\n", args[1], err)
+			os.Exit(1)
+		}
+		if err := printLayerSizes(os.Stdout, sizes, opts.Format); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if opts.Compose != "" {
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: %s --compose <spec.json>\n", progName)
+			os.Exit(1)
+		}
+		if err := runCompose(opts.Compose, verbose); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	multipleCommandArgs := opts.EntrypointShellEscape || opts.Exec || opts.EntrypointFromImage
+	// --rootfs replaces the image and sha256sum arguments
+	commandStart := 3
+	if opts.Rootfs != "" {
+		commandStart = 1
+	}
+	// the image's Cmd is used when --entrypoint-from-image is given no arguments
+	minArgs := commandStart + 1
+	if opts.EntrypointFromImage || opts.ArgsJSON != "" {
+		minArgs = commandStart
+	}
+	extractOnly := len(opts.ExtractOnly) > 0
+	if extractOnly && (len(args) != 3 || opts.ExtractDir == "") {
+		fmt.Fprintf(os.Stderr, "usage: %s --extract-only <path> [--extract-only <path>...] --extract-dir <dir> <image.tar.gz> <sha256sum|@sha256-file>\n", progName)
+		os.Exit(1)
+	}
+	if opts.ExtractDir != "" && !extractOnly {
+		fmt.Fprintf(os.Stderr, "error: --extract-dir requires --extract-only\n")
+		os.Exit(1)
+	}
+	if extractOnly {
+		if opts.Reentrant || opts.Detach || opts.Rootfs != "" || opts.Overlay || opts.RootfsTmpfs != "" {
+			fmt.Fprintf(os.Stderr, "error: --extract-only cannot be used with --reentrant, --detach, --rootfs, --overlay, or --rootfs-tmpfs\n")
+			os.Exit(1)
+		}
+		opts.ExtractDir, err = filepath.Abs(opts.ExtractDir)
+		if err != nil {
+			panic(err)
+		}
+	} else if len(args) < minArgs || (len(args) > commandStart+1 && !multipleCommandArgs) || (opts.ArgsJSON != "" && len(args) != commandStart) {
+		fmt.Fprintf(os.Stderr, "usage: %s <image.tar.gz> <sha256sum|@sha256-file> <command>\n", progName)
+		fmt.Fprintf(os.Stderr, "       %s --entrypoint-shell-escape|--exec <image.tar.gz> <sha256sum|@sha256-file> <command> [<arg>...]\n", progName)
+		fmt.Fprintf(os.Stderr, "       %s --entrypoint-from-image <image.tar.gz> <sha256sum|@sha256-file> [<arg>...]\n", progName)
+		fmt.Fprintf(os.Stderr, "       %s --args-json <json array> <image.tar.gz> <sha256sum|@sha256-file>\n", progName)
+		fmt.Fprintf(os.Stderr, "       %s --rootfs <dir> <command>\n", progName)
+		os.Exit(1)
+	}
+	var image, expectedImageSha256Sum string
+	if opts.Rootfs == "" {
+		image = args[1]
+		expectedImageSha256Sum = args[2]
+	}
+	if strings.HasPrefix(expectedImageSha256Sum, "@") {
+		expectedImageSha256Sum, err = readSha256File(expectedImageSha256Sum[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to read expected sha256 sum: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if image == stdinImage && (opts.Interactive || opts.CacheSnapshots || extractOnly) {
+		fmt.Fprintf(os.Stderr, "error: reading the image from stdin cannot be used with --interactive, --cache-snapshots, or --extract-only\n")
+		os.Exit(1)
+	}
+	commandArgs := args[commandStart:]
+	if opts.ArgsJSON != "" {
+		if opts.EntrypointShellEscape || opts.Exec || opts.EntrypointFromImage {
+			fmt.Fprintf(os.Stderr, "error: --args-json cannot be used with --entrypoint-shell-escape, --exec, or --entrypoint-from-image\n")
+			os.Exit(1)
+		}
+		commandArgs, err = parseArgsJSON(opts.ArgsJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --args-json: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	var command string
+	if len(commandArgs) > 0 {
+		command = commandArgs[0]
+	}
+	if opts.EntrypointShellEscape {
+		command = shellJoin(commandArgs)
+	}
+
+	if opts.EntrypointShellEscape && opts.Exec {
+		fmt.Fprintf(os.Stderr, "error: --entrypoint-shell-escape cannot be used with --exec\n")
+		os.Exit(1)
+	}
+	if opts.EntrypointFromImage && (opts.EntrypointShellEscape || opts.Exec) {
+		fmt.Fprintf(os.Stderr, "error: --entrypoint-from-image cannot be used with --entrypoint-shell-escape or --exec\n")
+		os.Exit(1)
+	}
+	if opts.Argv0 != "" && !opts.Exec && opts.ArgsJSON == "" && !opts.EntrypointFromImage {
+		fmt.Fprintf(os.Stderr, "error: --argv0 requires --exec, --args-json, or --entrypoint-from-image\n")
+		os.Exit(1)
+	}
+	var entrypointWrapper []string
+	if opts.EntrypointWrapper != "" {
+		entrypointWrapper = strings.Fields(opts.EntrypointWrapper)
+		if len(entrypointWrapper) == 0 {
+			fmt.Fprintf(os.Stderr, "error: --entrypoint-wrapper must not be empty\n")
+			os.Exit(1)
+		}
+	}
+	if opts.EntrypointFromImage && opts.Rootfs != "" {
+		fmt.Fprintf(os.Stderr, "error: --entrypoint-from-image requires an image, and cannot be used with --rootfs\n")
+		os.Exit(1)
+	}
+
+	if strings.TrimSpace(command) == "" && !opts.Reentrant && !opts.EntrypointFromImage && !extractOnly {
+		// reentrant mode may be used to start a container without running anything in it
+		fmt.Fprintf(os.Stderr, "error: command must not be empty\n")
+		os.Exit(1)
+	}
+
+	if opts.ExtractConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "error: --extract-concurrency must be at least 1; got %d\n", opts.ExtractConcurrency)
+		os.Exit(1)
+	}
+	if opts.ExtractConcurrency == 0 {
+		opts.ExtractConcurrency = defaultExtractConcurrency()
+	}
+	if opts.SelinuxLabel != "" {
+		if opts.Selinux == "disabled" {
+			fmt.Fprintf(os.Stderr, "error: --selinux-label cannot be used with --selinux=disabled\n")
+			os.Exit(1)
+		}
+		if !selinuxLabelRegexp.MatchString(opts.SelinuxLabel) {
+			fmt.Fprintf(os.Stderr, "error: invalid --selinux-label %q; expected user:role:type[:level]\n", opts.SelinuxLabel)
+			os.Exit(1)
+		}
+	}
+	if opts.Nice != nil && (*opts.Nice < -20 || *opts.Nice > 19) {
+		fmt.Fprintf(os.Stderr, "error: --nice must be between -20 and 19; got %d\n", *opts.Nice)
+		os.Exit(1)
+	}
+	if opts.OOMScoreAdj != nil && (*opts.OOMScoreAdj < -1000 || *opts.OOMScoreAdj > 1000) {
+		fmt.Fprintf(os.Stderr, "error: --oom-score-adj must be between -1000 and 1000; got %d\n", *opts.OOMScoreAdj)
+		os.Exit(1)
+	}
+	if opts.BlkioWeight != nil && (*opts.BlkioWeight < 10 || *opts.BlkioWeight > 1000) {
+		fmt.Fprintf(os.Stderr, "error: --blkio-weight must be between 10 and 1000; got %d\n", *opts.BlkioWeight)
+		os.Exit(1)
+	}
+	var umask uint64
+	if opts.Umask != "" {
+		umask, err = strconv.ParseUint(opts.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			fmt.Fprintf(os.Stderr, "error: --umask must be an octal mode between 0000 and 0777; got %q\n", opts.Umask)
+			os.Exit(1)
+		}
+	}
+	if opts.CpusetCpus != "" {
+		if err := validateCpuset(opts.CpusetCpus); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --cpuset-cpus %q: %s\n", opts.CpusetCpus, err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.Output != "" {
+		opts.Output, err = filepath.Abs(opts.Output)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.OutputDir != "" {
+		entries, err := os.ReadDir(opts.OutputDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "error: invalid --output-dir: %s\n", err)
+			os.Exit(1)
+		}
+		if len(entries) > 0 {
+			fmt.Fprintf(os.Stderr, "error: --output-dir %s is not empty\n", opts.OutputDir)
+			os.Exit(1)
+		}
+	}
+	writesOutput := opts.Output != "" || opts.OutputDir != ""
+	var repoTags []string
+	for _, tag := range opts.Tag {
+		ref, err := normalizeImageTag(tag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --tag: %s\n", err)
+			os.Exit(1)
+		}
+		repoTags = append(repoTags, ref)
+	}
+	if len(repoTags) > 0 && !writesOutput {
+		fmt.Fprintf(os.Stderr, "error: --tag requires --output or --output-dir\n")
+		os.Exit(1)
+	}
+	if opts.Squash && !writesOutput {
+		fmt.Fprintf(os.Stderr, "error: --squash requires --output or --output-dir\n")
+		os.Exit(1)
+	}
+	// tarHeaders holds the headers of the image's entries for --preserve-headers
+	var tarHeaders *acbrun.TarHeaders
+	if opts.PreserveHeaders {
+		if !writesOutput || opts.CacheSnapshots {
+			fmt.Fprintf(os.Stderr, "error: --preserve-headers requires --output or --output-dir, and cannot be used with --cache-snapshots\n")
+			os.Exit(1)
+		}
+		tarHeaders = acbrun.NewTarHeaders()
+	}
+	if opts.Squash && opts.OutputGzipMetadata {
+		fmt.Fprintf(os.Stderr, "error: --output-gzip-metadata cannot be combined with --squash\n")
+		os.Exit(1)
+	}
+	if opts.OutputCompression == "zstd" {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --output-compression=zstd requires the zstd command: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.ZstdDict != "" {
+		if info, err := os.Stat(opts.ZstdDict); err != nil || info.IsDir() {
+			fmt.Fprintf(os.Stderr, "error: --zstd-dict %s is not a file\n", opts.ZstdDict)
+			os.Exit(1)
+		}
+	}
+
+	// argv0Path is the acbrun executable, which --argv0 mounts into the container
+	var argv0Path string
+	if opts.Argv0 != "" {
+		argv0Path, err = os.Executable()
+		if err == nil {
+			err = checkStaticBinary(argv0Path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to use --argv0: %s (build acbrun with CGO_ENABLED=0)\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var initPath string
+	if opts.Init {
+		initPath, err = findInitBinary(opts.InitPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to use init binary for --init: %s\n", err)
+			os.Exit(1)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "using init binary %s\n", initPath)
+		}
+	}
+
+	var cacheMounts []cacheMount
+	for _, s := range opts.MountCache {
+		m, err := parseCacheMount(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --mount-cache value %q: %s\n", s, err)
+			os.Exit(1)
+		}
+		cacheMounts = append(cacheMounts, m)
+	}
+
+	var roBinds []bindSpec
+	for _, s := range opts.RoBind {
+		b, err := parseBindSpec(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --ro-bind value %q: %s\n", s, err)
+			os.Exit(1)
+		}
+		roBinds = append(roBinds, b)
+	}
+
+	var secretMounts []secretMount
+	for _, s := range opts.MountSecret {
+		m, err := parseSecretMount(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --mount-secret value %q: %s\n", s, err)
+			os.Exit(1)
+		}
+		secretMounts = append(secretMounts, m)
+	}
+
+	var poststopHooks [][]string
+	for _, command := range opts.PoststopHook {
+		hookArgs, err := parseHookCommand(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --poststop-hook %q: %s\n", command, err)
+			os.Exit(1)
+		}
+		poststopHooks = append(poststopHooks, hookArgs)
+	}
+	var poststartHooks [][]string
+	for _, command := range opts.PoststartHook {
+		hookArgs, err := parseHookCommand(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --poststart-hook %q: %s\n", command, err)
+			os.Exit(1)
+		}
+		poststartHooks = append(poststartHooks, hookArgs)
+	}
+
+	for _, p := range append(append([]string{}, opts.MaskPath...), opts.ReadonlyPath...) {
+		if !filepath.IsAbs(p) {
+			fmt.Fprintf(os.Stderr, "error: --mask-path and --readonly-path values must be absolute paths; got %q\n", p)
+			os.Exit(1)
+		}
+	}
+
+	var additionalGids []uint32
+	for _, s := range opts.GroupAdd {
+		gid, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --group-add requires a numeric gid; got %q\n", s)
+			os.Exit(1)
+		}
+		additionalGids = append(additionalGids, uint32(gid))
+	}
+
+	if opts.HostNetwork {
+		if opts.Network != "" && opts.Network != "host" {
+			fmt.Fprintf(os.Stderr, "error: --host-network conflicts with --network=%s\n", opts.Network)
+			os.Exit(1)
+		}
+		opts.Network = "host"
+	}
+	if opts.Network == "" {
+		opts.Network = "none"
+	}
+	networkContainer, isNetworkContainer := strings.CutPrefix(opts.Network, "container:")
+	switch {
+	case opts.Network == "none" || opts.Network == "host":
+	case opts.Network == "bridge":
+		fmt.Fprintf(os.Stderr, "error: --network=bridge is not yet supported\n")
+		os.Exit(1)
+	case isNetworkContainer && networkContainer != "":
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid --network %q; expected none, host, bridge, or container:<name>\n", opts.Network)
+		os.Exit(1)
+	}
+
+	var cni *cniPlugin
+	if opts.CNI != "" {
+		if opts.Network != "none" {
+			fmt.Fprintf(os.Stderr, "error: --cni configures the container's own network namespace, and cannot be used with --network=%s\n", opts.Network)
+			os.Exit(1)
+		}
+		plugin, err := resolveCNIPlugin(opts.CNI)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --cni %s: %s\n", opts.CNI, err)
+			os.Exit(1)
+		}
+		cni = &plugin
+	}
+
+	if opts.Cwd != "" && !filepath.IsAbs(opts.Cwd) {
+		fmt.Fprintf(os.Stderr, "error: --cwd must be an absolute path; got %q\n", opts.Cwd)
+		os.Exit(1)
+	}
+
+	if opts.PlatformVariant != "" && !platformVariantRegexp.MatchString(opts.PlatformVariant) {
+		fmt.Fprintf(os.Stderr, "error: invalid --platform-variant %q; expected a value such as v7\n", opts.PlatformVariant)
+		os.Exit(1)
+	}
+
+	fileMounts := map[string]string{}
+	for flag, m := range map[string]struct{ source, destination string }{
+		"--resolv-conf":   {opts.ResolvConf, "/etc/resolv.conf"},
+		"--hostname-file": {opts.HostnameFile, "/etc/hostname"},
+	} {
+		if m.source == "" {
+			continue
+		}
+		source, err := filepath.Abs(m.source)
+		if err != nil {
+			panic(err)
+		}
+		if err := validateBindSource(source, true); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid %s: %s\n", flag, err)
+			os.Exit(1)
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			panic(err)
+		}
+		if !info.Mode().IsRegular() {
+			fmt.Fprintf(os.Stderr, "error: invalid %s: %s is not a regular file\n", flag, source)
+			os.Exit(1)
+		}
+		fileMounts[m.destination] = source
+	}
+
+	if opts.StopSignal != "" {
+		if _, err := acbrun.ParseSignal(opts.StopSignal); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --stop-signal: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	for flag, values := range map[string][]string{"--env": opts.Env, "--env-default": opts.EnvDefault} {
+		for _, value := range values {
+			if _, err := parseEnv(value); err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid %s %q: %s\n", flag, value, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	var sourceDateEpoch *time.Time
+	if opts.SourceDateEpoch != "" {
+		seconds, err := strconv.ParseInt(opts.SourceDateEpoch, 10, 64)
+		if err != nil || seconds < 0 {
+			fmt.Fprintf(os.Stderr, "error: invalid --source-date-epoch (or SOURCE_DATE_EPOCH) %q; expected a unix timestamp\n", opts.SourceDateEpoch)
+			os.Exit(1)
+		}
+		epoch := time.Unix(seconds, 0).UTC()
+		sourceDateEpoch = &epoch
+	}
+
+	var copies []copySpec
+	for _, s := range opts.Copy {
+		c, err := parseCopySpec(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --copy %q: %s\n", s, err)
+			os.Exit(1)
+		}
+		copies = append(copies, c)
+	}
+
+	var expectedRootFSDigest digest.Digest
+	if opts.ExpectRootfsDigest != "" {
+		expectedRootFSDigest, err = digest.Parse(opts.ExpectRootfsDigest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --expect-rootfs-digest %q: %s\n", opts.ExpectRootfsDigest, err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.CacheSnapshots && (extractOnly || opts.Overlay || opts.Rootfs != "") {
+		fmt.Fprintf(os.Stderr, "error: --cache-snapshots cannot be used with --extract-only, --overlay, or --rootfs\n")
+		os.Exit(1)
+	}
+
+	configTemplate := configJSONTemplate
+	if opts.BaseConfig != "" {
+		configTemplate, err = readBaseConfig(opts.BaseConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --base-config %s: %s\n", opts.BaseConfig, err)
+			os.Exit(1)
+		}
+	}
+
+	var configOverrides []configOverride
+	for _, s := range opts.ConfigOverride {
+		override, err := parseConfigOverride(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --config-override %q: %s\n", s, err)
+			os.Exit(1)
+		}
+		configOverrides = append(configOverrides, override)
+	}
+
+	var mountsFromFile []string
+	if opts.MountsFile != "" {
+		mountsFromFile, err = readMountsFile(opts.MountsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --mounts-file %s: %s\n", opts.MountsFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.Detach {
+		if opts.Reentrant {
+			fmt.Fprintf(os.Stderr, "error: --detach cannot be used with --reentrant, which always detaches\n")
+			os.Exit(1)
+		}
+		if writesOutput {
+			fmt.Fprintf(os.Stderr, "error: --detach cannot be used with --output or --output-dir\n")
+			os.Exit(1)
+		}
+		if opts.Interactive {
+			fmt.Fprintf(os.Stderr, "error: --detach cannot be used with --interactive\n")
+			os.Exit(1)
+		}
+		if opts.ExitCodeFile != "" {
+			fmt.Fprintf(os.Stderr, "error: --detach cannot be used with --exit-code-file\n")
+			os.Exit(1)
+		}
+		if opts.MergeStderr {
+			fmt.Fprintf(os.Stderr, "error: --detach cannot be used with --merge-stderr, as the container's output is not shown\n")
+			os.Exit(1)
+		}
+	}
+
+	if opts.Restart && (!opts.Reentrant || writesOutput) {
+		fmt.Fprintf(os.Stderr, "error: --restart requires --reentrant, and cannot be used with --output or --output-dir\n")
+		os.Exit(1)
+	}
+	if opts.MaxRestarts < 0 {
+		fmt.Fprintf(os.Stderr, "error: --max-restarts must not be negative; got %d\n", opts.MaxRestarts)
+		os.Exit(1)
+	}
+	if opts.Healthcheck != "" && !opts.Reentrant {
+		fmt.Fprintf(os.Stderr, "error: --healthcheck requires --reentrant\n")
+		os.Exit(1)
+	}
+	if opts.WaitHealthy != 0 && opts.Healthcheck == "" {
+		fmt.Fprintf(os.Stderr, "error: --wait-healthy requires --healthcheck\n")
+		os.Exit(1)
+	}
+	if opts.HealthcheckInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "error: --healthcheck-interval must be positive\n")
+		os.Exit(1)
+	}
+
+	if opts.Rootfs != "" {
+		if opts.Reentrant || opts.Overlay || opts.RootfsTmpfs != "" {
+			fmt.Fprintf(os.Stderr, "error: --rootfs cannot be used with --reentrant, --overlay, or --rootfs-tmpfs\n")
+			os.Exit(1)
+		}
+		opts.Rootfs, err = filepath.Abs(opts.Rootfs)
+		if err != nil {
+			panic(err)
+		}
+		if info, err := os.Stat(opts.Rootfs); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "error: --rootfs %s is not a directory\n", opts.Rootfs)
+			os.Exit(1)
+		}
+	}
+
+	if opts.Overlay && (opts.Reentrant || opts.Detach) {
+		fmt.Fprintf(os.Stderr, "error: --overlay cannot be used with --reentrant or --detach\n")
+		os.Exit(1)
+	}
+	if opts.Upperdir != "" {
+		if !opts.Overlay {
+			fmt.Fprintf(os.Stderr, "error: --upperdir requires --overlay\n")
+			os.Exit(1)
+		}
+		opts.Upperdir, err = filepath.Abs(opts.Upperdir)
+		if err != nil {
+			panic(err)
+		}
+		if info, err := os.Stat(opts.Upperdir); err == nil && !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "error: --upperdir %s is not a directory\n", opts.Upperdir)
+			os.Exit(1)
+		}
+	}
+
+	if opts.RootfsTmpfs != "" {
+		if !tmpfsSizeRegexp.MatchString(opts.RootfsTmpfs) {
+			fmt.Fprintf(os.Stderr, "error: invalid --rootfs-tmpfs size %q; expected a value such as 512m or 50%%\n", opts.RootfsTmpfs)
+			os.Exit(1)
+		}
+		if opts.Reentrant || opts.Detach || opts.Overlay {
+			fmt.Fprintf(os.Stderr, "error: --rootfs-tmpfs cannot be used with --reentrant, --detach, or --overlay\n")
+			os.Exit(1)
+		}
+	}
+
+	containerName := opts.Name
+	if containerName != "" {
+		if err := validateContainerName(containerName); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if containerName == "" {
+		if opts.Reentrant {
+			fmt.Fprintf(os.Stderr, "error: the --reentrant mode requires a --name value\n")
+			os.Exit(1)
+		}
+		containerName = acbrun.RandStringBytesMask(12)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "using random container name %s\n", containerName)
+		}
+	}
+
+	cleanupOnSignal(verbose)
+
+	timer := &phaseTimer{verbose: verbose}
+	writeSummary := func() {
+		if opts.SummaryJSON == "" {
+			return
+		}
+		err := writeSummaryJSON(opts.SummaryJSON, runSummary{
+			Container: containerName,
+			Phases:    timer.phases,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: failed to write summary to %s: %s\n", opts.SummaryJSON, err)
+		}
+	}
+	defer writeSummary()
+
+	var workingDir string
+	var needsCreation bool
+	var createdWorkingDir bool // a reentrant working directory, which is marked as created once it is
+	unlockWorkingDir := func() error { return nil }
+	if opts.Reentrant {
+		workingDir = filepath.Join(execDir(stateDir), "acbrun-"+containerName)
+		// concurrent invocations using the same name must not race to create and
+		// extract the working directory
+		unlockWorkingDir, err = acbrun.LockFile(workingDir + ".lock")
+		if err != nil {
+			panic(err)
+		}
+		exists, complete, err := reentrantDirState(workingDir, containerName)
+		if err != nil {
+			exitIfRuntimeNotFound(err)
+			panic(err)
+		}
+		if exists && !complete {
+			fmt.Fprintf(os.Stderr, "WARNING: removing %s, which was not fully created; it will be created again\n", workingDir)
+			if err := removeIncompleteDir(workingDir); err != nil {
+				panic(err)
+			}
+		}
+		needsCreation = !complete
+		if verbose {
+			if needsCreation {
+				fmt.Fprintf(os.Stderr, "reentrant mode did not find existing directory %s; it will create it\n", workingDir)
+			} else {
+				fmt.Fprintf(os.Stderr, "reentrant mode found existing directory %s; skipping creation step\n", workingDir)
+			}
+		}
+		if needsCreation {
+			err = os.Mkdir(workingDir, 0755)
+			if err != nil {
+				panic(err)
+			}
+			createdWorkingDir = true
+		}
+
+	} else {
+		needsCreation = true
+		var err error
+		workingDir, err = os.MkdirTemp(execDir(os.TempDir()), fmt.Sprintf("acbrun-%s", containerName))
+		if err != nil {
+			panic(err)
+		}
+		if opts.Keep {
+			fmt.Fprintf(os.Stderr, "keeping temporary working directory: %s\n", workingDir)
+		} else if opts.Detach {
+			// the bundle must outlive acbrun; it is removed along with the container
+			if verbose {
+				fmt.Fprintf(os.Stderr, "detached container %s uses working directory %s\n", containerName, workingDir)
+			}
+		} else {
+			defer addCleanup(func() { removeWorkingDir(workingDir, opts.KeepLayers) })()
+		}
+	}
+
+	rootFS := filepath.Join(workingDir, "rootfs")
+	if opts.Rootfs != "" {
+		rootFS = opts.Rootfs
+		needsCreation = false
+	}
+	if extractOnly {
+		rootFS = opts.ExtractDir
+	}
+	if needsCreation {
+		endExtract := timer.start("extract")
+		validImageSha256 := func(actualSha256HashHexString string) bool {
+			if actualSha256HashHexString != expectedImageSha256Sum {
+				if expectedImageSha256Sum == "skip-sha256-validation" {
+					fmt.Fprintf(os.Stderr, "WARNING: continuing due to skip-sha256-validation option (actual value is %s)\n", actualSha256HashHexString)
+				} else {
+					fmt.Fprintf(os.Stderr, "expected sha256 sum %s does not match actual sum of %s: %s\n", expectedImageSha256Sum, image, actualSha256HashHexString)
+					return false
+				}
+			}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "%s sha256sum of %s validation complete\n", image, actualSha256HashHexString)
+			}
+			return true
+		}
+		// an image read from stdin is checked as it is extracted, below
+		var actualSha256HashHexString string
+		if image != stdinImage {
+			actualSha256HashHexString, err = acbrun.GetTarSha256String(image)
+			if err != nil {
+				exitIfInvalidImage(err)
+				panic(err)
+			}
+			if !validImageSha256(actualSha256HashHexString) {
+				exitAfterCleanup(1)
+			}
+		}
+		if extractOnly {
+			err = os.MkdirAll(rootFS, 0755)
+		} else {
+			err = os.Mkdir(rootFS, 0755)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if opts.RootfsTmpfs != "" {
+			err := mountTmpfs(rootFS, opts.RootfsTmpfs)
+			if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+				fmt.Fprintf(os.Stderr, "WARNING: insufficient privileges to mount a tmpfs on %s; extracting to disk instead\n", rootFS)
+			} else if err != nil {
+				panic(err)
+			} else {
+				if verbose {
+					fmt.Fprintf(os.Stderr, "mounted tmpfs (size=%s) on %s\n", opts.RootfsTmpfs, rootFS)
+				}
+				defer addCleanup(func() {
+					if err := unmountTmpfs(rootFS); err != nil {
+						fmt.Fprintf(os.Stderr, "WARNING: failed to unmount tmpfs on %s: %s\n", rootFS, err)
+					}
+				})()
+			}
+		}
+		restored := false
+		if opts.CacheSnapshots {
+			restored, err = restoreSnapshot(actualSha256HashHexString, workingDir, rootFS)
+			if err != nil {
+				panic(err)
+			}
+			if restored && verbose {
+				fmt.Fprintf(os.Stderr, "restored rootfs from snapshot %s\n", snapshotDir(actualSha256HashHexString))
+			}
+		}
+		if !restored {
+			extractOpts := extractImageOptions{
+				KeepLayers: opts.KeepLayers,
+				Overlay:    opts.Overlay,
+				Verbose:    verbose,
+				Extract: acbrun.ExtractOptions{
+					Dedup:         opts.Dedup,
+					GzipReadahead: opts.GzipReadahead,
+					BestEffort:    opts.BestEffort,
+					IncludePaths:  opts.ExtractOnly,
+					Concurrency:   opts.ExtractConcurrency,
+					ZstdDict:      opts.ZstdDict,
+					RecordHeaders: tarHeaders,
+				},
+			}
+			if image == stdinImage {
+				actualSha256HashHexString, err = spoolImageStream(os.Stdin, workingDir)
+				if err != nil {
+					exitIfInvalidImage(err)
+					panic(err)
+				}
+				if !validImageSha256(actualSha256HashHexString) {
+					// what was spooled must never be extracted, or reused by later invocations
+					if opts.Reentrant {
+						os.RemoveAll(workingDir)
+					}
+					exitAfterCleanup(1)
+				}
+				manifest, err := getManifest(filepath.Join(workingDir, "manifest.json"))
+				if err != nil {
+					panic(err)
+				}
+				err = applyLayers(workingDir, rootFS, manifest, extractOpts)
+			} else {
+				err = extractImage(image, workingDir, rootFS, extractOpts)
+			}
+			if err != nil {
+				exitIfInvalidImage(err)
+				panic(err)
+			}
+			if opts.CacheSnapshots {
+				if err := saveSnapshot(actualSha256HashHexString, workingDir, rootFS); err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: failed to save a snapshot of %s: %s\n", image, err)
+				} else if verbose {
+					fmt.Fprintf(os.Stderr, "saved snapshot %s\n", snapshotDir(actualSha256HashHexString))
+				}
+			}
+		}
+		if opts.Overlay {
+			manifest, err := getManifest(filepath.Join(workingDir, "manifest.json"))
+			if err != nil {
+				panic(err)
+			}
+			upperDir, workDir, err := overlayDirs(workingDir, opts.Upperdir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: failed to create the overlay directories for --upperdir %s: %s\n", opts.Upperdir, err)
+				exitAfterCleanup(1)
+			}
+			if opts.Upperdir != "" {
+				defer addCleanup(func() {
+					if err := os.RemoveAll(workDir); err != nil {
+						fmt.Fprintf(os.Stderr, "WARNING: failed to remove overlay work directory %s: %s\n", workDir, err)
+					}
+				})()
+			}
+			if err := mountOverlay(workingDir, rootFS, len(manifest.Layers), upperDir, workDir); err != nil {
+				fmt.Fprintf(os.Stderr, "error: failed to mount overlay on %s: %s\n", rootFS, err)
+				exitAfterCleanup(1)
+			}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "mounted overlay on %s: %s\n", rootFS, overlayMountOptions(workingDir, len(manifest.Layers), upperDir, workDir))
+			}
+			defer addCleanup(func() {
+				if err := unmountOverlay(rootFS); err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: failed to unmount overlay on %s: %s\n", rootFS, err)
+				}
+			})()
+		}
+		endExtract()
+	}
+	if expectedRootFSDigest != "" && (needsCreation || opts.Rootfs != "") {
+		err := verifyRootFSDigest(rootFS, expectedRootFSDigest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			if opts.Reentrant {
+				// the rootfs must not be reused by later invocations
+				os.RemoveAll(workingDir)
+			}
+			exitAfterCleanup(1)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "rootfs digest %s verified\n", expectedRootFSDigest)
+		}
+	}
+	if createdWorkingDir {
+		if err := markReentrantDirCreated(workingDir); err != nil {
+			panic(err)
+		}
+	}
+	if err := unlockWorkingDir(); err != nil {
+		panic(err)
+	}
+	if extractOnly {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "extracted %s to %s\n", strings.Join(opts.ExtractOnly, ", "), rootFS)
+		}
+		return
+	}
+
+	for _, c := range copies {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "copying %s to %s\n", c.source, c.destination)
+		}
+		if err := copyIntoRootFS(rootFS, c); err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to copy %s to %s: %s\n", c.source, c.destination, err)
+			exitAfterCleanup(1)
+		}
+	}
+
+	endConfig := timer.start("config")
+	var inputImageConfig imagespec.Image
+	if opts.Rootfs == "" {
+		inputImageConfig, err = readImageConfig(workingDir)
+		if err != nil {
+			panic(err)
+		}
+		// runc fails obscurely when asked to run, e.g., a windows image
+		if inputImageConfig.OS != "" && inputImageConfig.OS != runtime.GOOS {
+			fmt.Fprintf(os.Stderr, "error: the image is built for the %s OS, but this host runs %s\n", inputImageConfig.OS, runtime.GOOS)
+			exitAfterCleanup(1)
+		}
+	}
+
+	stopSignal := syscall.SIGTERM
+	if opts.StopSignal != "" {
+		stopSignal, _ = acbrun.ParseSignal(opts.StopSignal)
+	} else if inputImageConfig.Config.StopSignal != "" {
+		stopSignal, err = acbrun.ParseSignal(inputImageConfig.Config.StopSignal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: ignoring the image's StopSignal: %s\n", err)
+			stopSignal = syscall.SIGTERM
+		}
+	}
+
+	platformVariant := inputImageConfig.Variant
+	if opts.PlatformVariant != "" {
+		if platformVariant != "" && platformVariant != opts.PlatformVariant {
+			fmt.Fprintf(os.Stderr, "error: --platform-variant=%s does not match the image's %s/%s platform\n", opts.PlatformVariant, inputImageConfig.Architecture, platformVariant)
+			exitAfterCleanup(1)
+		}
+		platformVariant = opts.PlatformVariant
+	}
+
+	configJSON := configTemplate
+
+	if inputImageConfig.Config.User != "" {
+		uid, gid, err := acbrun.ResolveUser(rootFS, inputImageConfig.Config.User)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to resolve image user %q: %s\n", inputImageConfig.Config.User, err)
+			exitAfterCleanup(1)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "running as image user %s (uid=%d gid=%d)\n", inputImageConfig.Config.User, uid, gid)
+		}
+		configJSON, err = sjson.Set(configJSON, "process.user.uid", uid)
+		if err != nil {
+			panic(err)
+		}
+		configJSON, err = sjson.Set(configJSON, "process.user.gid", gid)
+		if err != nil {
+			panic(err)
+		}
+		imageGids, err := acbrun.AdditionalGids(rootFS, inputImageConfig.Config.User, gid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to resolve the groups of image user %q: %s\n", inputImageConfig.Config.User, err)
+			exitAfterCleanup(1)
+		}
+		for _, id := range imageGids {
+			if !slices.Contains(additionalGids, id) {
+				additionalGids = append(additionalGids, id)
+			}
+		}
+		if verbose && len(imageGids) > 0 {
+			fmt.Fprintf(os.Stderr, "image user %s is a member of groups %v\n", inputImageConfig.Config.User, imageGids)
+		}
+	}
+
+	// commandArgv is run directly, rather than passing command to sh -c, when set
+	var commandArgv []string
+	if opts.Exec || opts.ArgsJSON != "" {
+		commandArgv = commandArgs
+	}
+	if opts.EntrypointFromImage {
+		if len(inputImageConfig.Config.Entrypoint) == 0 {
+			fmt.Fprintf(os.Stderr, "error: --entrypoint-from-image was given, but the image has no Entrypoint\n")
+			exitAfterCleanup(1)
+		}
+		imageArgs := commandArgs
+		if len(imageArgs) == 0 {
+			imageArgs = inputImageConfig.Config.Cmd
+		}
+		commandArgv = append(append([]string{}, inputImageConfig.Config.Entrypoint...), imageArgs...)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "running the image's entrypoint: %s\n", shellJoin(commandArgv))
+		}
+	}
+
+	var processArgs []string
+	if opts.Reentrant {
+		processArgs = []string{"sh", "-c", "while true; do sleep 1; done"}
+	} else {
+		processArgs = []string{"sh", "-c", command}
+		if commandArgv != nil {
+			processArgs = withArgv0(commandArgv, opts.Argv0)
+		}
+		processArgs = append(append([]string{}, entrypointWrapper...), processArgs...)
+	}
+	if opts.Init {
+		initArgs := []string{initMountPath}
+		if opts.PidNamespace == "host" {
+			// the init is not PID 1 in the host's namespace, so it must register as
+			// a subreaper (tini's -s) to be given the orphans it reaps
+			initArgs = append(initArgs, "-s")
+		}
+		processArgs = append(append(initArgs, "--"), processArgs...)
+		configJSON, err = sjson.Set(configJSON, "mounts.-1", map[string]interface{}{
+			"destination": initMountPath,
+			"type":        "bind",
+			"source":      initPath,
+			"options": []string{
+				"bind",
+				"ro",
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	if argv0Path != "" {
+		configJSON, err = sjson.Set(configJSON, "mounts.-1", map[string]interface{}{
+			"destination": argv0MountPath,
+			"type":        "bind",
+			"source":      argv0Path,
+			"options": []string{
+				"bind",
+				"ro",
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	configJSON, err = sjson.Set(configJSON, "process.args", processArgs)
+	if err != nil {
+		panic(err)
+	}
+	if opts.PidNamespace == "host" {
+		configJSON, err = removeNamespace(configJSON, "pid")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.DevFull {
+		fmt.Fprintf(os.Stderr, "WARNING: --dev-full gives the container access to all of the host's devices, including its disks and memory\n")
+		configJSON, err = useHostDev(configJSON)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.Network == "none" {
+		configJSON, err = sjson.Set(configJSON, "linux.namespaces.-1", map[string]string{"type": "network"})
+		if err != nil {
+			panic(err)
+		}
+	}
+	if isNetworkContainer {
+		pid, err := acbrun.GetContainerPid(networkContainer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to share the network of container %s: %s\n", networkContainer, err)
+			exitAfterCleanup(1)
+		}
+		configJSON, err = sjson.Set(configJSON, "linux.namespaces.-1", map[string]string{
+			"type": "network",
+			"path": fmt.Sprintf("/proc/%d/ns/net", pid),
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	args, err := flags.ParseArgs(&opts, os.Args)
-	if err != nil {
-		panic(err)
+	if opts.BindLocalDir {
+		actualWorkingDir, err := os.Getwd()
+		if err != nil {
+			panic(err)
+		}
+		configJSON, err = addBindMount(configJSON, actualWorkingDir, "/local-dir", false)
+		if err != nil {
+			exitOnBindMountError(err)
+		}
 	}
-	verbose := isVerbose(opts.Verbose)
-	progName := "acbrun"
-	if len(args) > 0 {
-		progName = args[0]
+
+	for _, b := range roBinds {
+		configJSON, err = addBindMount(configJSON, b.source, b.destination, true)
+		if err != nil {
+			exitOnBindMountError(err)
+		}
 	}
-	if len(args) != 4 {
-		fmt.Fprintf(os.Stderr, "usage: %s <image.tar.gz> <sha256sum> <container name> <command>\n", progName)
-		os.Exit(1)
+	configJSON, err = addComposeMounts(configJSON)
+	if err != nil {
+		exitOnBindMountError(err)
 	}
-	image := args[1]
-	expectedImageSha256Sum := args[2]
-	command := args[3]
 
-	containerName := opts.Name
-	if containerName == "" {
-		if opts.Reentrant {
-			fmt.Fprintf(os.Stderr, "error: the --reentrant mode requires a --name value\n")
-			os.Exit(1)
+	for _, m := range cacheMounts {
+		cacheDir, err := ensureCacheDir(cacheRoot, m.id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to use cache %s: %s\n", m.id, err)
+			exitAfterCleanup(1)
 		}
-		containerName = acbrun.RandStringBytesMask(12)
 		if verbose {
-			fmt.Fprintf(os.Stderr, "using random container name %s\n", containerName)
+			fmt.Fprintf(os.Stderr, "mounting cache %s at %s\n", cacheDir, m.target)
+		}
+		configJSON, err = addBindMount(configJSON, cacheDir, m.target, false)
+		if err != nil {
+			exitOnBindMountError(err)
 		}
 	}
 
-	var workingDir string
-	var needsCreation bool
-	if opts.Reentrant {
-		workingDir = filepath.Join("/tmp", "acbrun-"+containerName)
-		_, err := os.Stat(workingDir)
+	for _, m := range secretMounts {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "mounting secret %s at %s\n", m.id, m.target)
+		}
+		configJSON, err = addBindMount(configJSON, m.source, m.target, true)
 		if err != nil {
-			if os.IsNotExist(err) {
-				needsCreation = true
-			} else {
-				panic(err)
-			}
+			exitOnBindMountError(err)
 		}
-		if verbose {
-			if needsCreation {
-				fmt.Fprintf(os.Stderr, "reentrant mode did not find existing directory %s; it will create it\n", workingDir)
-			} else {
-				fmt.Fprintf(os.Stderr, "reentrant mode found existing directory %s; skipping creation step\n", workingDir)
-			}
+	}
+
+	for _, hookArgs := range poststartHooks {
+		configJSON, err = addHook(configJSON, "poststart", hookArgs)
+		if err != nil {
+			panic(err)
 		}
-		if needsCreation {
-			err = os.Mkdir(workingDir, 0755)
+	}
+	for _, hookArgs := range poststopHooks {
+		configJSON, err = addHook(configJSON, "poststop", hookArgs)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if cni != nil {
+		// the network is set up before the container's process starts, and torn
+		// down once everything else is done with it
+		for stage, command := range map[string]string{"prestart": "ADD", "poststop": "DEL"} {
+			hookArgs, err := cni.hookArgs(command)
+			if err != nil {
+				panic(err)
+			}
+			configJSON, err = addHook(configJSON, stage, hookArgs)
 			if err != nil {
 				panic(err)
 			}
 		}
+	}
 
-	} else {
-		needsCreation = true
-		var err error
-		workingDir, err = os.MkdirTemp("", fmt.Sprintf("acbrun-%s", containerName))
+	for _, p := range opts.MaskPath {
+		configJSON, err = sjson.Set(configJSON, "linux.maskedPaths.-1", p)
 		if err != nil {
 			panic(err)
 		}
-		if opts.Keep {
-			fmt.Fprintf(os.Stderr, "keeping temporary working directory: %s\n", workingDir)
-		} else {
-			defer os.RemoveAll(workingDir)
+	}
+	for _, p := range opts.ReadonlyPath {
+		configJSON, err = sjson.Set(configJSON, "linux.readonlyPaths.-1", p)
+		if err != nil {
+			panic(err)
 		}
 	}
 
-	rootFS := filepath.Join(workingDir, "rootfs")
-	if needsCreation {
-		actualSha256HashHexString, err := acbrun.GetTarSha256String(image)
+	for _, gid := range additionalGids {
+		configJSON, err = sjson.Set(configJSON, "process.user.additionalGids.-1", gid)
 		if err != nil {
 			panic(err)
 		}
+	}
 
-		if actualSha256HashHexString != expectedImageSha256Sum {
-			if expectedImageSha256Sum == "skip-sha256-validation" {
-				fmt.Fprintf(os.Stderr, "WARNING: continuing due to skip-sha256-validation option (actual value is %s)\n", actualSha256HashHexString)
-			} else {
-				fmt.Fprintf(os.Stderr, "expected sha256 sum %s does not match actual sum of %s: %s\n", expectedImageSha256Sum, image, actualSha256HashHexString)
-				os.Exit(1)
+	if opts.Cwd != "" {
+		configJSON, err = sjson.Set(configJSON, "process.cwd", opts.Cwd)
+		if err != nil {
+			panic(err)
+		}
+		// the image's symlinks are resolved as they would be in the container, so
+		// that the directory is never created outside of the rootfs
+		cwdPath, err := resolveInRootFS(rootFS, opts.Cwd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to resolve working directory %s: %s\n", opts.Cwd, err)
+			exitAfterCleanup(1)
+		}
+		if _, err := os.Stat(cwdPath); os.IsNotExist(err) {
+			if !opts.CwdCreate {
+				fmt.Fprintf(os.Stderr, "error: working directory %s does not exist in the container; use --cwd-create to create it\n", opts.Cwd)
+				exitAfterCleanup(1)
+			}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "creating working directory %s\n", opts.Cwd)
+			}
+			if err := os.MkdirAll(cwdPath, 0755); err != nil {
+				panic(err)
 			}
+		} else if err != nil {
+			panic(err)
 		}
-		if verbose {
-			fmt.Fprintf(os.Stderr, "%s sha256sum of %s validation complete\n", image, actualSha256HashHexString)
+	}
+
+	for _, destination := range []string{"/etc/resolv.conf", "/etc/hostname"} {
+		if source, ok := fileMounts[destination]; ok {
+			configJSON, err = addBindMount(configJSON, source, destination, true)
+			if err != nil {
+				exitOnBindMountError(err)
+			}
 		}
-		r, err := os.Open(image)
+	}
+
+	for _, mount := range mountsFromFile {
+		configJSON, err = sjson.SetRaw(configJSON, "mounts.-1", mount)
 		if err != nil {
 			panic(err)
 		}
-		defer r.Close()
-		acbrun.ExtractTarGz(r, workingDir)
-		layers, err := getLayers(filepath.Join(workingDir, "manifest.json"))
+	}
+
+	cgroupV2 := opts.CgroupVersion == "2" || (opts.CgroupVersion == "auto" && acbrun.IsCgroupV2Unified())
+	if cgroupV2 {
+		configJSON, err = useCgroup2Mount(configJSON)
 		if err != nil {
 			panic(err)
 		}
-		if len(layers) == 0 {
-			panic("no layer data")
+		if verbose {
+			fmt.Fprintf(os.Stderr, "mounting /sys/fs/cgroup as cgroup2\n")
 		}
-		if err := os.Mkdir(rootFS, 0755); err != nil {
+	}
+
+	if opts.Rootfs != "" {
+		configJSON, err = sjson.Set(configJSON, "root.path", rootFS)
+		if err != nil {
 			panic(err)
 		}
-		for _, layer := range layers {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "extracting %s\n", layer)
-			}
-			r, err := os.Open(filepath.Join(workingDir, layer))
-			if err != nil {
-				panic(err)
-			}
-			defer r.Close()
-			acbrun.ExtractTarGz(r, rootFS)
-		}
 	}
 
-	configJSON := configJSONTemplate
+	// the image's environment takes precedence over the template's, explicit --env
+	// values over both, and --env-default values only fill in what is missing
+	var templateEnv []string
+	for _, e := range gjson.Get(configJSON, "process.env").Array() {
+		templateEnv = append(templateEnv, e.String())
+	}
+	env := mergeEnv(templateEnv, inputImageConfig.Config.Env)
+	env = mergeEnv(env, opts.Env)
+	env = mergeEnvDefaults(env, opts.EnvDefault)
+	// the output image keeps the environment the command ran with, except for what
+	// only the runtime template defines; it always has a PATH
+	outputEnv := mergeEnv(inputImageConfig.Config.Env, opts.Env)
+	outputEnv = mergeEnvDefaults(outputEnv, opts.EnvDefault)
+	outputEnv = mergeEnvDefaults(outputEnv, []string{"PATH=/bin:/usr/bin"})
+	configJSON, err = sjson.Set(configJSON, "process.env", env)
+	if err != nil {
+		panic(err)
+	}
 
-	if opts.Reentrant {
-		configJSON, err = sjson.Set(configJSON, "process.args", []string{"sh", "-c", "while true; do sleep 1; done"})
+	if opts.Interactive && !opts.Reentrant {
+		configJSON, err = sjson.Set(configJSON, "process.terminal", true)
 		if err != nil {
 			panic(err)
 		}
-	} else {
-		configJSON, err = sjson.Set(configJSON, "process.args", []string{"sh", "-c", command})
+	}
+
+	if opts.OOMScoreAdj != nil {
+		configJSON, err = sjson.Set(configJSON, "process.oomScoreAdj", *opts.OOMScoreAdj)
 		if err != nil {
 			panic(err)
 		}
 	}
-	if !opts.HostNetwork {
-		configJSON, err = sjson.Set(configJSON, "linux.namespaces.-1", map[string]string{"type": "network"})
+
+	if opts.Nice != nil {
+		// SCHED_OTHER is the default policy, and the only one which niceness affects
+		configJSON, err = sjson.Set(configJSON, "process.scheduler", map[string]interface{}{
+			"policy": "SCHED_OTHER",
+			"nice":   *opts.Nice,
+		})
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	if opts.BindLocalDir {
-		actualWorkingDir, err := os.Getwd()
+	if opts.CpusetCpus != "" {
+		configJSON, err = sjson.Set(configJSON, "linux.resources.cpu.cpus", opts.CpusetCpus)
 		if err != nil {
 			panic(err)
 		}
-		configJSON, err = sjson.Set(configJSON, "mounts.-1", map[string]interface{}{
-			"destination": "/local-dir",
-			"type":        "bind",
-			"source":      actualWorkingDir,
-			"options": []string{
-				"rbind",
-				"rprivate",
-			},
-		})
+	}
+
+	if opts.BlkioWeight != nil {
+		configJSON, err = sjson.Set(configJSON, "linux.resources.blockIO.weight", *opts.BlkioWeight)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	if opts.Interactive && !opts.Reentrant {
-		configJSON, err = sjson.Set(configJSON, "process.terminal", true)
+	if opts.Umask != "" {
+		// runc applies process.user.umask before running the process (since 1.1)
+		configJSON, err = sjson.Set(configJSON, "process.user.umask", umask)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if opts.SelinuxLabel != "" {
+		configJSON, err = sjson.Set(configJSON, "process.selinuxLabel", opts.SelinuxLabel)
 		if err != nil {
 			panic(err)
 		}
+		configJSON, err = sjson.Set(configJSON, "linux.mountLabel", opts.SelinuxLabel)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// overrides are applied last, so that they take precedence over every other flag
+	for _, override := range configOverrides {
+		configJSON, err = sjson.SetRaw(configJSON, override.path, override.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to apply --config-override for %s: %s\n", override.path, err)
+			exitAfterCleanup(1)
+		}
+	}
+
+	if opts.Selinux == "disabled" {
+		for _, path := range []string{"process.selinuxLabel", "linux.mountLabel"} {
+			configJSON, err = sjson.Delete(configJSON, path)
+			if err != nil {
+				panic(err)
+			}
+		}
 	}
 
 	newConfigFile, err := os.Create(filepath.Join(workingDir, "config.json"))
@@ -239,119 +2094,269 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	endConfig()
 
 	if verbose {
 		fmt.Fprintf(os.Stderr, "running runc\n")
 	}
+	endRun := timer.start("run")
 	needsRun := true
 	if opts.Reentrant {
 		isRunning, err := acbrun.IsContainerRunning(containerName)
 		if err != nil {
+			exitIfRuntimeNotFound(err)
 			panic(err)
 		}
 		needsRun = !isRunning
 	}
+	var stdin io.Reader
+	if opts.Interactive {
+		stdin = os.Stdin
+	}
+	// with --merge-stderr, both streams share stdout's file, so what the container
+	// writes to them stays in order
+	var stderr io.Writer = os.Stderr
+	if opts.MergeStderr {
+		stderr = os.Stdout
+	}
+	runcLogPath := filepath.Join(workingDir, "runc.log")
+	// exitCode is that of the container's command, once it has run
+	var exitCode int
 	if needsRun {
-		commandArgs := []string{"runc", "run"}
-		if opts.Reentrant {
-			commandArgs = append(commandArgs, "--detach")
+		runOpts := acbrun.RunOptions{
+			BundleDir: workingDir,
+			Stdin:     stdin,
+			Debug:     runcDebug,
+		}
+		if runcDebug {
+			runOpts.LogPath = runcLogPath
+		}
+		if opts.Reentrant || opts.Detach {
+			runOpts.Detach = true
+			runOpts.LogPath = runcLogPath
+		} else {
+			// stdout and stderr must not be connected when detaching (see RunContainer)
+			runOpts.Stdout = os.Stdout
+			runOpts.Stderr = stderr
+		}
+		stopForwarding := func() {}
+		if !runOpts.Detach {
+			stopForwarding = forwardStopSignals(containerName, stopSignal, verbose)
 		}
-		commandArgs = append(commandArgs, containerName)
-		cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
-		cmd.Dir = workingDir
-		if !opts.Reentrant {
-			// whenever runc -d is used, if stdout or stderr are specified, it causes
-			// commands like "./acbrun ... | cat" to hang
-			// this needs to be fixed somehow, since we need to surface errors if runc run -d fails
-			// note that is also fails when we give it a bytes buffer or even a custom buffer that doesnt even print
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+		err = acbrun.RunContainer(containerName, runOpts)
+		stopForwarding()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && !runOpts.Detach && opts.ExitCodeFile != "" {
+			// the command failed, or runc failed to start it; with --exit-code-file
+			// either is recorded and passed on
+			dumpBundleOnFailure(workingDir, rootFS)
+			if runcDebug {
+				printRuncLog(runcLogPath)
+			}
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			exitIfRuntimeNotFound(err)
+			dumpBundleOnFailure(workingDir, rootFS)
+			if runcDebug {
+				printRuncLog(runcLogPath)
+			}
+			panic(err)
 		}
 
-		if opts.Interactive {
-			cmd.Stdin = os.Stdin
+		if runOpts.Detach {
+			// runc run --detach returns as soon as the container process is started,
+			// which says nothing about whether it keeps running
+			err = acbrun.WaitForContainerRunning(containerName, 5*time.Second)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				dumpBundleOnFailure(workingDir, rootFS)
+				printRuncLog(runcLogPath)
+				exitAfterCleanup(1)
+			}
 		}
+	}
 
-		// TODO I think we need to create some sort of FILE-based stdout/stderr connection here
-		// where we can completely detach it from this current process
-		// or clean it up after the Run() comes back.
-		// the issue might be related to the "runc --detach" process continuing to persist AFTER
-		// this go process returns
-		// This seems related: https://github.com/opencontainers/runc/issues/1721
+	if opts.Detach {
+		endRun()
+		fmt.Println(containerName)
+		return
+	}
 
-		err = cmd.Run()
-		if err != nil {
-			panic(err)
+	if opts.Healthcheck != "" {
+		if opts.WaitHealthy != 0 {
+			err = waitHealthy(containerName, workingDir, opts.Healthcheck, opts.WaitHealthy, opts.HealthcheckInterval, verbose)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				exitAfterCleanup(1)
+			}
+		} else {
+			healthy, err := checkHealth(containerName, workingDir, opts.Healthcheck, verbose)
+			if err != nil {
+				panic(err)
+			}
+			if !healthy {
+				fmt.Fprintf(os.Stderr, "WARNING: container %s is unhealthy\n", containerName)
+			} else if verbose {
+				fmt.Fprintf(os.Stderr, "container %s is healthy\n", containerName)
+			}
 		}
 	}
 
 	if opts.Reentrant {
-		commandArgs := []string{"runc", "exec"}
-		if opts.Interactive {
-			commandArgs = append(commandArgs, "--tty")
+		execArgs := []string{"/bin/sh", "-c", command}
+		if commandArgv != nil {
+			execArgs = withArgv0(commandArgv, opts.Argv0)
 		}
-		commandArgs = append(commandArgs, containerName, "/bin/sh", "-c", command)
-		cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
-		cmd.Dir = workingDir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if opts.Interactive {
-			cmd.Stdin = os.Stdin
+		execArgs = append(append([]string{}, entrypointWrapper...), execArgs...)
+		execOpts := acbrun.RunOptions{
+			BundleDir: workingDir,
+			Tty:       opts.Interactive,
+			Stdin:     stdin,
+			Stdout:    os.Stdout,
+			Stderr:    stderr,
+			Debug:     runcDebug,
 		}
-		err = cmd.Run()
-		if err != nil {
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				os.Exit(exiterr.ExitCode())
+		if runcDebug {
+			execOpts.LogPath = runcLogPath
+		}
+		err = acbrun.ExecContainer(containerName, execArgs, execOpts)
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if runcDebug {
+				printRuncLog(runcLogPath)
 			}
+			exitCode = exiterr.ExitCode()
+		} else if err != nil {
 			panic(err)
 		}
 	}
 
-	if opts.Output == "" {
-		return
+	if opts.ExitCodeFile != "" {
+		err := os.WriteFile(opts.ExitCodeFile, []byte(fmt.Sprintf("%d\n", exitCode)), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: failed to write exit code to %s: %s\n", opts.ExitCodeFile, err)
+		}
 	}
-
-	if verbose {
-		fmt.Fprintf(os.Stderr, "outputing image to %s\n", opts.Output)
+	if exitCode != 0 {
+		endRun()
+		writeSummary()
+		exitAfterCleanup(exitCode)
 	}
 
-	outputDir, err := os.MkdirTemp("", "")
-	if err != nil {
-		panic(err)
+	if opts.Restart {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "keeping container %s running\n", containerName)
+		}
+		// this only returns once the container can no longer be kept running
+		err := superviseContainer(containerName, acbrun.RunOptions{
+			BundleDir: workingDir,
+			LogPath:   runcLogPath,
+			Debug:     runcDebug,
+		}, opts.MaxRestarts, verbose)
+		exitIfRuntimeNotFound(err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		endRun()
+		writeSummary()
+		exitAfterCleanup(1)
 	}
-	defer os.RemoveAll(outputDir)
 
-	rootFSPath := filepath.Join(outputDir, "rootfs.tar.gz")
-	out, err := os.Create(rootFSPath)
-	if err != nil {
-		panic(err)
+	endRun()
+
+	if !writesOutput {
+		return
 	}
-	defer out.Close()
+	endOutput := timer.start("output")
+	defer endOutput()
 
-	err = acbrun.CreateTarGz(rootFS, out)
+	outputDir, err := os.MkdirTemp("", "")
 	if err != nil {
 		panic(err)
 	}
+	defer addCleanup(func() { os.RemoveAll(outputDir) })()
 
-	outputRootFSTarGzSha256, err := acbrun.GetTarSha256String(rootFSPath)
-	if err != nil {
-		panic(err)
+	layerExtension, layerMediaType := ".tar.gz", imagespec.MediaTypeImageLayerGzip
+	if opts.OutputCompression == "zstd" {
+		layerExtension, layerMediaType = ".tar.zst", imagespec.MediaTypeImageLayerZstd
 	}
-	rootFSName := fmt.Sprintf("%s.tar.gz", outputRootFSTarGzSha256)
-	err = os.Rename(rootFSPath, filepath.Join(outputDir, rootFSName))
-	if err != nil {
-		panic(err)
+	tarOpts := acbrun.CreateTarGzOptions{
+		Deterministic:   opts.Squash,
+		SourceDateEpoch: sourceDateEpoch,
+	}
+	rootFSTarOpts := tarOpts
+	rootFSTarOpts.Exclude = opts.OutputExclude
+	// runc creates the mount points of secrets in the rootfs; neither they, nor
+	// whatever the image had at those paths, belong in the output
+	for _, m := range secretMounts {
+		rootFSTarOpts.Exclude = append(rootFSTarOpts.Exclude, excludePattern(m.target))
+	}
+	rootFSTarOpts.Compression = acbrun.Compression(opts.OutputCompression)
+	rootFSTarOpts.ZstdDict = opts.ZstdDict
+	rootFSTarOpts.ReplayHeaders = tarHeaders
+	var rootFSDiffID digest.Digest
+	// the headers replayed by --preserve-headers are not part of the fingerprint
+	// of the rootfs, so the layer is only kept for resuming without them
+	resumable := opts.Output != "" && tarHeaders == nil
+	if resumable {
+		var layerPath string
+		layerPath, rootFSDiffID, err = createResumableLayer(resumeDir(opts.Output), rootFS, layerExtension, rootFSTarOpts, verbose)
+		if err != nil {
+			panic(err)
+		}
+		err = linkOrCopy(layerPath, filepath.Join(outputDir, rootFSDiffID.Encoded()+layerExtension))
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		rootFSPath := filepath.Join(outputDir, "rootfs"+layerExtension)
+		out, err := os.Create(rootFSPath)
+		if err != nil {
+			panic(err)
+		}
+		defer out.Close()
+		var w io.Writer = out
+		if verbose {
+			w = newProgressWriter(out, "output layer")
+		}
+		rootFSDiffID, err = acbrun.CreateLayer(rootFS, w, rootFSTarOpts)
+		if err != nil {
+			panic(err)
+		}
+		if err := out.Close(); err != nil {
+			panic(err)
+		}
+		err = os.Rename(rootFSPath, filepath.Join(outputDir, rootFSDiffID.Encoded()+layerExtension))
+		if err != nil {
+			panic(err)
+		}
 	}
 
+	outputRootFSTarGzSha256 := rootFSDiffID.Encoded()
+	rootFSName := outputRootFSTarGzSha256 + layerExtension
+
+	outputArchitecture := inputImageConfig.Architecture
+	if outputArchitecture == "" {
+		outputArchitecture = runtime.GOARCH
+	}
+	// --squash output must be reproducible, so it records no timestamps unless
+	// given one by --source-date-epoch
+	created := sourceDateEpoch
+	if created == nil && !opts.Squash {
+		now := time.Now().UTC()
+		created = &now
+	}
+	createdBy := command
+	if commandArgv != nil {
+		createdBy = shellJoin(commandArgv)
+	}
 	imageConfig := imagespec.Image{
+		Created: created,
 		Platform: imagespec.Platform{
-			Architecture: "amd64", // TODO
+			Architecture: outputArchitecture,
 			OS:           "linux",
+			Variant:      platformVariant,
 		},
 		Config: imagespec.ImageConfig{
-			Env: []string{
-				"PATH=/bin:/usr/bin", // TODO
-			},
+			Env:    outputEnv,
+			Labels: inputImageConfig.Config.Labels,
 		},
 		RootFS: imagespec.RootFS{
 			Type: "layers",
@@ -359,8 +2364,15 @@ func main() {
 				digest.Digest(fmt.Sprintf("sha256:%s", outputRootFSTarGzSha256)),
 			},
 		},
+		History: []imagespec.History{
+			{
+				Created:   created,
+				CreatedBy: createdBy,
+				Comment:   opts.CommitMessage,
+			},
+		},
 	}
-	imageConfigJSON, err := json.Marshal(imageConfig)
+	imageConfigJSON, err := canonicalJSON(imageConfig)
 	if err != nil {
 		panic(err)
 	}
@@ -381,8 +2393,9 @@ func main() {
 	}
 
 	imageManifest := Manifest{
-		Config: imageConfigName,
-		Layers: []string{rootFSName},
+		Config:   imageConfigName,
+		RepoTags: repoTags,
+		Layers:   []string{rootFSName},
 	}
 	imageManifestJson, err := json.Marshal([]Manifest{imageManifest})
 	if err != nil {
@@ -399,15 +2412,67 @@ func main() {
 		panic(err)
 	}
 
-	outputImage, err := os.Create(opts.Output)
+	if opts.OutputDir != "" {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "outputing image layout to %s\n", opts.OutputDir)
+		}
+		err = writeOCILayout(opts.OutputDir, filepath.Join(outputDir, rootFSName), layerMediaType, imageConfigJSON, repoTags)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.Output == "" {
+		return
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "outputing image to %s\n", opts.Output)
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		panic(err)
+	}
+	// the image is only renamed to the output path once it is complete and verified
+	partialPath := partialOutput(opts.Output)
+	outputImage, err := os.Create(partialPath)
 	if err != nil {
 		panic(err)
 	}
+	defer addCleanup(func() { os.Remove(partialPath) })()
 	defer outputImage.Close()
 
-	err = acbrun.CreateTarGz(outputDir, outputImage)
+	outputTarOpts := tarOpts
+	if opts.OutputGzipMetadata {
+		outputTarOpts.GzipName = filepath.Base(opts.Output)
+		outputTarOpts.GzipModTime = time.Now()
+	}
+	var w io.Writer = outputImage
+	if verbose {
+		w = newProgressWriter(outputImage, "output image")
+	}
+	err = acbrun.CreateTarGzWithOptions(outputDir, w, outputTarOpts)
+	if err != nil {
+		panic(err)
+	}
+	err = outputImage.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	err = verifyOutputImage(partialPath, opts.ZstdDict)
+	if err != nil {
+		panic(fmt.Errorf("verification of output image %s failed: %w", opts.Output, err))
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "verified output image %s\n", opts.Output)
+	}
+	err = os.Rename(partialPath, opts.Output)
 	if err != nil {
 		panic(err)
 	}
+	if resumable {
+		if err := os.RemoveAll(resumeDir(opts.Output)); err != nil {
+			panic(err)
+		}
+	}
 
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSha256File(t *testing.T) {
+	const sum = "c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{"bare", sum, ""},
+		{"trailing newline", sum + "\n", ""},
+		{"surrounding whitespace", "\n  \t" + sum + " \r\n\n", ""},
+		{"prefixed", "sha256:" + sum + "\n", ""},
+		{"uppercase", strings.ToUpper(sum), ""},
+		{"sha256sum output", sum + "  alpine-3.20.3.tar.gz\n", ""},
+		{"binary sha256sum output", sum + " *alpine-3.20.3.tar.gz\n", ""},
+		{"empty", "", "is empty"},
+		{"whitespace", " \n\t\n", "is empty"},
+		{"short", sum[:63], "does not contain a sha256 sum"},
+		{"not hex", strings.Repeat("g", 64), "does not contain a sha256 sum"},
+		{"other algorithm", "sha512:" + sum, "does not contain a sha256 sum"},
+		{"file name first", "alpine-3.20.3.tar.gz  " + sum, "does not contain a sha256 sum"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-"))
+			if err := os.WriteFile(path, []byte(tc.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := readSha256File(path)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %q (%v)", tc.err, actual, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != sum {
+				t.Errorf("expected %s, got %s", sum, actual)
+			}
+		})
+	}
+
+	if _, err := readSha256File(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected a missing file to be an error")
+	}
+}
+
+func TestEnsureCacheDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "state", "cache")
+	dir, err := ensureCacheDir(root, "gomod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != filepath.Join(root, "gomod") {
+		t.Fatalf("expected the cache in %s, got %s", root, dir)
+	}
+	for _, d := range []string{root, dir} {
+		info, err := os.Stat(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0700 {
+			t.Errorf("expected %s to be created with mode 0700, got %s", d, info.Mode().Perm())
+		}
+	}
+
+	// the cache's contents persist
+	if err := os.WriteFile(filepath.Join(dir, "data"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureCacheDir(root, "gomod"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureCacheDir(root, "gomod"); err == nil || !strings.Contains(err.Error(), "accessible to other users") {
+		t.Errorf("expected a root accessible to other users to be refused, got %v", err)
+	}
+	if err := os.Chmod(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cache planted as a symlink is not followed
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "planted")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureCacheDir(root, "planted"); err == nil || !strings.Contains(err.Error(), "is not a directory owned by") {
+		t.Errorf("expected a symlinked cache to be refused, got %v", err)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of the cache requires root")
+	}
+	if err := os.Chown(dir, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureCacheDir(root, "gomod"); err == nil || !strings.Contains(err.Error(), "is not a directory owned by") {
+		t.Errorf("expected a cache owned by another user to be refused, got %v", err)
+	}
+	if err := os.Chown(root, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureCacheDir(root, "other"); err == nil || !strings.Contains(err.Error(), "is not a directory owned by") {
+		t.Errorf("expected a root owned by another user to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeOCILayout writes the single layer image made of the layer at layerPath,
+// which has the given media type, and configJSON to dir as an OCI image layout, i.e. oci-layout,
+// index.json, and the blobs they refer to in blobs/sha256/. The image is listed in
+// index.json once for each of repoTags, or once without a name when there are none.
+func writeOCILayout(dir, layerPath, layerMediaType string, configJSON []byte, repoTags []string) error {
+	if err := os.MkdirAll(filepath.Join(dir, imagespec.ImageBlobsDir, "sha256"), 0755); err != nil {
+		return err
+	}
+	layer, err := writeBlobFromFile(dir, layerPath, layerMediaType)
+	if err != nil {
+		return err
+	}
+	config, err := writeBlob(dir, configJSON, imagespec.MediaTypeImageConfig)
+	if err != nil {
+		return err
+	}
+	manifestJSON, err := canonicalJSON(imagespec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imagespec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []imagespec.Descriptor{layer},
+	})
+	if err != nil {
+		return err
+	}
+	manifest, err := writeBlob(dir, manifestJSON, imagespec.MediaTypeImageManifest)
+	if err != nil {
+		return err
+	}
+
+	index := imagespec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imagespec.MediaTypeImageIndex,
+		Manifests: []imagespec.Descriptor{manifest},
+	}
+	if len(repoTags) > 0 {
+		index.Manifests = nil
+		for _, tag := range repoTags {
+			named := manifest
+			named.Annotations = map[string]string{imagespec.AnnotationRefName: tag}
+			index.Manifests = append(index.Manifests, named)
+		}
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, imagespec.ImageIndexFile), indexJSON, 0644); err != nil {
+		return err
+	}
+	layoutJSON, err := json.Marshal(imagespec.ImageLayout{Version: imagespec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, imagespec.ImageLayoutFile), layoutJSON, 0644)
+}
+
+// blobPath returns the path of the blob with the given digest in the layout at dir.
+func blobPath(dir string, d digest.Digest) string {
+	return filepath.Join(dir, imagespec.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+func writeBlob(dir string, data []byte, mediaType string) (imagespec.Descriptor, error) {
+	desc := imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	return desc, os.WriteFile(blobPath(dir, desc.Digest), data, 0644)
+}
+
+// writeBlobFromFile copies the file at src into the layout at dir, naming it after
+// the digest of its contents, which is only known once it has been copied.
+func writeBlobFromFile(dir, src, mediaType string) (imagespec.Descriptor, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Join(dir, imagespec.ImageBlobsDir, "sha256"), ".tmp-")
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), in)
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	desc := imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digester.Digest(),
+		Size:      size,
+	}
+	return desc, os.Rename(tmp.Name(), blobPath(dir, desc.Digest))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// overlayLayerDir is the directory which the n-th layer of the image (counting from
+// the bottom) is extracted to in --overlay mode.
+func overlayLayerDir(workingDir string, n int) string {
+	return filepath.Join(workingDir, "layers", strconv.Itoa(n))
+}
+
+// convertWhiteouts rewrites the OCI whiteout files of an extracted layer into the
+// form overlayfs understands: ".wh.<name>" becomes a 0/0 character device named
+// <name>, and ".wh..wh..opq" marks its parent directory as opaque.
+func convertWhiteouts(layerDir string) error {
+	return filepath.WalkDir(layerDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if !strings.HasPrefix(name, acbrun.WhiteoutPrefix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		dir := filepath.Dir(path)
+		if name == acbrun.WhiteoutOpaque {
+			return syscall.Setxattr(dir, "trusted.overlay.opaque", []byte("y"), 0)
+		}
+		return syscall.Mknod(filepath.Join(dir, strings.TrimPrefix(name, acbrun.WhiteoutPrefix)), syscall.S_IFCHR, 0)
+	})
+}
+
+// overlayDirs returns the upper directory, which changes made by the container are
+// written to, and the work directory of the overlay. They are kept in workingDir
+// unless hostUpperDir is given; overlayfs requires the work directory to be on the
+// same filesystem as the upper one, so it is then created alongside hostUpperDir,
+// and must be removed once the overlay is unmounted.
+func overlayDirs(workingDir, hostUpperDir string) (upperDir, workDir string, err error) {
+	if hostUpperDir == "" {
+		return filepath.Join(workingDir, "upper"), filepath.Join(workingDir, "work"), nil
+	}
+	if err := os.MkdirAll(hostUpperDir, 0755); err != nil {
+		return "", "", err
+	}
+	workDir, err = os.MkdirTemp(filepath.Dir(hostUpperDir), "."+filepath.Base(hostUpperDir)+".work-")
+	if err != nil {
+		return "", "", err
+	}
+	return hostUpperDir, workDir, nil
+}
+
+// overlayMountOptions combines the extracted layers into overlayfs mount options; the
+// topmost layer comes first in lowerdir.
+func overlayMountOptions(workingDir string, numLayers int, upperDir, workDir string) string {
+	lowerDirs := make([]string, 0, numLayers)
+	for n := numLayers - 1; n >= 0; n-- {
+		lowerDirs = append(lowerDirs, overlayLayerDir(workingDir, n))
+	}
+	return "lowerdir=" + strings.Join(lowerDirs, ":") +
+		",upperdir=" + upperDir +
+		",workdir=" + workDir
+}
+
+// mountOverlay mounts the image's layers as an overlay on rootFS.
+func mountOverlay(workingDir, rootFS string, numLayers int, upperDir, workDir string) error {
+	for _, dir := range []string{upperDir, workDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return syscall.Mount("overlay", rootFS, "overlay", 0, overlayMountOptions(workingDir, numLayers, upperDir, workDir))
+}
+
+func unmountOverlay(rootFS string) error {
+	return syscall.Unmount(rootFS, syscall.MNT_DETACH)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// createdMarker is written to a reentrant container's working directory once it
+// has been created in full, while its lock is still held. A working directory
+// without it was left behind by an invocation which died part way through
+// creating it, and must not be reused.
+const createdMarker = ".acbrun-created"
+
+// reentrantDirState reports whether the working directory of the named reentrant
+// container exists, and if so, whether it was created in full. Working directories
+// created before the marker was introduced are complete if their container exists.
+func reentrantDirState(workingDir, containerName string) (exists, complete bool, err error) {
+	if _, err := os.Stat(workingDir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	_, err = os.Stat(filepath.Join(workingDir, createdMarker))
+	if err == nil {
+		return true, true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return true, false, err
+	}
+	status, err := acbrun.GetContainerState(containerName)
+	if err != nil {
+		return true, false, err
+	}
+	return true, status != "", nil
+}
+
+// markReentrantDirCreated records that workingDir has been created in full.
+func markReentrantDirCreated(workingDir string) error {
+	return os.WriteFile(filepath.Join(workingDir, createdMarker), nil, 0644)
+}
+
+// removeIncompleteDir removes a working directory which was not fully created,
+// first detaching any overlay its creator left mounted on the rootfs, so that
+// nothing is removed through it from the overlay's upper directory.
+func removeIncompleteDir(workingDir string) error {
+	err := unmountOverlay(filepath.Join(workingDir, "rootfs"))
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(workingDir)
+}
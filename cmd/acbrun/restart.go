@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+const (
+	// restartPollInterval is how often --restart checks whether the container is
+	// still running.
+	restartPollInterval = 500 * time.Millisecond
+
+	// the delay before restarting the container doubles from restartBackoffMin with
+	// each restart, up to restartBackoffMax, and is reset once the container has
+	// kept running for restartBackoffReset
+	restartBackoffMin   = 100 * time.Millisecond
+	restartBackoffMax   = 30 * time.Second
+	restartBackoffReset = 10 * time.Second
+)
+
+// superviseContainer keeps the named reentrant container running: whenever it
+// stops, it is deleted and started again with runOpts, detached. It
+// returns an error once the container stops after being restarted maxRestarts
+// times, unless maxRestarts is 0, which restarts it without limit.
+func superviseContainer(name string, runOpts acbrun.RunOptions, maxRestarts int, verbose bool) error {
+	backoff := restartBackoffMin
+	restarts := 0
+	lastStart := time.Now()
+	for {
+		time.Sleep(restartPollInterval)
+		status, err := acbrun.GetContainerState(name)
+		if err != nil {
+			return err
+		}
+		if status == "running" {
+			if time.Since(lastStart) >= restartBackoffReset {
+				backoff = restartBackoffMin
+			}
+			continue
+		}
+		if maxRestarts > 0 && restarts >= maxRestarts {
+			return fmt.Errorf("container %s stopped after being restarted %d times", name, restarts)
+		}
+		if status == "" {
+			status = "gone"
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: container %s is %s; restarting it in %s\n", name, status, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, restartBackoffMax)
+
+		if status != "gone" {
+			if err := acbrun.DeleteContainer(name); err != nil {
+				return err
+			}
+		}
+		runOpts.Detach = true
+		err = acbrun.RunContainer(name, runOpts)
+		if err != nil {
+			return err
+		}
+		restarts++
+		lastStart = time.Now()
+		if verbose {
+			fmt.Fprintf(os.Stderr, "restarted container %s (%d restart(s))\n", name, restarts)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/alexcb/acbrun/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+// progressInterval is how often progressWriter reports how much has been written.
+const progressInterval = 5 * time.Second
+
+// partialOutput is where the output image is written before it has been verified
+// and renamed into place, so that an interrupted run never leaves a truncated
+// image at the output path.
+func partialOutput(output string) string {
+	return output + ".partial"
+}
+
+// resumeDir is where the output layer is kept, along with the marker describing
+// it (see layerResume), until the output image has been written, and is removed
+// once it has. It is only left behind when writing the image fails (or acbrun is
+// killed), so that running the same command again can resume from the layer
+// instead of compressing the rootfs a second time; it is not a cache of layers
+// across successful runs.
+func resumeDir(output string) string {
+	return output + ".resume"
+}
+
+// layerResume is the marker stored in resumeDir once its layer is complete.
+type layerResume struct {
+	// Fingerprint is that of the rootfs and options which the layer was created
+	// from (see rootFSFingerprint)
+	Fingerprint string        `json:"fingerprint"`
+	DiffID      digest.Digest `json:"diffID"`
+	Layer       string        `json:"layer"`
+}
+
+const layerResumeMarker = "layer.json"
+
+// rootFSFingerprint identifies the layer which CreateLayer would produce from
+// rootFS with opts: it covers the path, type, mode, ownership, and contents of
+// every file in the rootfs, and their modification times unless opts is
+// Deterministic, in which case the layer does not record them.
+func rootFSFingerprint(rootFS string, opts acbrun.CreateTarGzOptions) (string, error) {
+	h := sha256.New()
+	optsJSON, err := json.Marshal(struct {
+		Compression     acbrun.Compression
+		ZstdDict        string
+		Deterministic   bool
+		SourceDateEpoch *time.Time
+		Exclude         []string
+	}{opts.Compression, opts.ZstdDict, opts.Deterministic, opts.SourceDateEpoch, opts.Exclude})
+	if err != nil {
+		return "", err
+	}
+	h.Write(optsJSON)
+	err = filepath.WalkDir(rootFS, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(rootFS, path)
+		if err != nil {
+			return err
+		}
+		var uid, gid uint32
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = st.Uid, st.Gid
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		var modTime int64
+		if !opts.Deterministic {
+			modTime = info.ModTime().UnixNano()
+		}
+		fmt.Fprintf(h, "%q %o %d %d %d %d %q\n", relPath, uint32(info.Mode()), uid, gid, info.Size(), modTime, link)
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadLayerResume returns the layer kept in dir when its marker matches
+// fingerprint, reporting false when there is no such layer.
+func loadLayerResume(dir, fingerprint string) (layerResume, bool, error) {
+	var resume layerResume
+	data, err := os.ReadFile(filepath.Join(dir, layerResumeMarker))
+	if errors.Is(err, os.ErrNotExist) {
+		return resume, false, nil
+	} else if err != nil {
+		return resume, false, err
+	}
+	if err := json.Unmarshal(data, &resume); err != nil {
+		// an unreadable marker is treated as no marker; the layer is created again
+		return resume, false, nil
+	}
+	if resume.Fingerprint != fingerprint || resume.Layer != filepath.Base(resume.Layer) {
+		return resume, false, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, resume.Layer)); err != nil {
+		return resume, false, nil
+	}
+	return resume, true, nil
+}
+
+// createResumableLayer creates the layer of rootFS in dir, named after its DiffID
+// with the given extension, and returns its path and DiffID; a layer left in dir by
+// an earlier, interrupted run is reused when the rootfs and opts are unchanged since.
+func createResumableLayer(dir, rootFS, extension string, opts acbrun.CreateTarGzOptions, verbose bool) (string, digest.Digest, error) {
+	fingerprint, err := rootFSFingerprint(rootFS, opts)
+	if err != nil {
+		return "", "", err
+	}
+	resume, ok, err := loadLayerResume(dir, fingerprint)
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "reusing the output layer of an earlier run from %s\n", dir)
+		}
+		return filepath.Join(dir, resume.Layer), resume.DiffID, nil
+	}
+
+	// whatever is left in dir is from a different rootfs
+	if err := os.RemoveAll(dir); err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	tmpPath := filepath.Join(dir, "layer"+extension+".partial")
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer out.Close()
+	var w io.Writer = out
+	if verbose {
+		w = newProgressWriter(out, "output layer")
+	}
+	diffID, err := acbrun.CreateLayer(rootFS, w, opts)
+	if err != nil {
+		return "", "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", "", err
+	}
+	resume = layerResume{
+		Fingerprint: fingerprint,
+		DiffID:      diffID,
+		Layer:       diffID.Encoded() + extension,
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, resume.Layer)); err != nil {
+		return "", "", err
+	}
+	marker, err := json.Marshal(resume)
+	if err != nil {
+		return "", "", err
+	}
+	// the marker is renamed into place, so that it only ever describes a complete layer
+	if err := os.WriteFile(filepath.Join(dir, layerResumeMarker+".tmp"), marker, 0644); err != nil {
+		return "", "", err
+	}
+	err = os.Rename(filepath.Join(dir, layerResumeMarker+".tmp"), filepath.Join(dir, layerResumeMarker))
+	return filepath.Join(dir, resume.Layer), diffID, err
+}
+
+// linkOrCopy hard links src to dst, or copies it when they are on different
+// filesystems.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return acbrun.CopyPath(src, dst)
+}
+
+// progressWriter passes writes through to w, reporting on stderr every
+// progressInterval how many bytes have been written.
+type progressWriter struct {
+	w       io.Writer
+	name    string
+	written int64
+	start   time.Time
+	last    time.Time
+}
+
+func newProgressWriter(w io.Writer, name string) *progressWriter {
+	now := time.Now()
+	return &progressWriter{w: w, name: name, start: now, last: now}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		fmt.Fprintf(os.Stderr, "writing %s: %d bytes in %s\n", p.name, p.written, now.Sub(p.start).Round(time.Second))
+	}
+	return n, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks is how many symlinks resolveInRootFS follows before giving up, as
+// the kernel does.
+const maxSymlinks = 40
+
+// resolveInRootFS returns the host path of p, a path in the container, having
+// resolved the symlinks of its components as they would be resolved inside the
+// container: absolute targets are relative to rootFS, and ".." never leaves it.
+// Components which do not exist yet are kept as they are, so the result may be
+// created with os.MkdirAll without following anything.
+func resolveInRootFS(rootFS, p string) (string, error) {
+	resolved := "/"
+	remaining := strings.Split(p, "/")
+	links := 0
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(rootFS, next))
+		if errors.Is(err, fs.ErrNotExist) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		target, err := os.Readlink(filepath.Join(rootFS, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(rootFS, resolved), nil
+}
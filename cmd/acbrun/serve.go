@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/alexcb/acbrun/v2"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// serveRequest is sent by a client, as a single line of JSON, to run a command.
+type serveRequest struct {
+	Image   string         `json:"image"` // absolute path
+	Sha256  string         `json:"sha256"`
+	Command string         `json:"command"`
+	Mounts  []composeMount `json:"mounts,omitempty"`
+}
+
+// serveResponse is streamed back to the client as lines of JSON: the command's
+// output as it is written, followed by either its exit code or an error.
+type serveResponse struct {
+	Stream   string `json:"stream,omitempty"` // stdout or stderr
+	Data     []byte `json:"data,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// serveEncoder writes responses to a connection, which both of the command's
+// output streams share.
+type serveEncoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (e *serveEncoder) send(resp serveResponse) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(resp)
+}
+
+// serveStream forwards everything written to it as responses for the named stream.
+type serveStream struct {
+	name string
+	enc  *serveEncoder
+}
+
+func (s serveStream) Write(p []byte) (int, error) {
+	if err := s.enc.send(serveResponse{Stream: s.name, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func validateServeRequest(req serveRequest) error {
+	if !filepath.IsAbs(req.Image) || req.Sha256 == "" {
+		return fmt.Errorf("an absolute image path and sha256 are required")
+	}
+	if req.Command == "" {
+		return fmt.Errorf("command must not be empty")
+	}
+	for _, m := range req.Mounts {
+		if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Destination) {
+			return fmt.Errorf("mounts require an absolute source and destination")
+		}
+	}
+	return nil
+}
+
+// lookupGroup returns the gid of group, a group name or a numeric gid.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// listenServeSocket listens on the unix socket at socketPath, which only its owner,
+// and the members of the group socketGID when it is not -1, may connect to. A
+// request may bind mount any host path into a container run as root, so anyone
+// who can connect is as good as root.
+func listenServeSocket(socketPath string, socketGID int) (net.Listener, error) {
+	mode := os.FileMode(0600)
+	if socketGID != -1 {
+		mode = 0660
+	}
+	// the socket is created with the umask's mode (0600), so it is never looser
+	// than mode, even before it is shared with the group below
+	oldUmask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return nil, err
+	}
+	checkMode := func(expected os.FileMode) error {
+		info, err := os.Lstat(socketPath)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSocket == 0 || info.Mode().Perm()&^expected != 0 {
+			return fmt.Errorf("refusing to serve on %s, whose mode is %s rather than %s", socketPath, info.Mode(), expected|fs.ModeSocket)
+		}
+		return nil
+	}
+	err = checkMode(0600)
+	if err == nil && socketGID != -1 {
+		// checked above to still be the socket, as chmod follows symlinks
+		if err = os.Lchown(socketPath, -1, socketGID); err == nil {
+			if err = os.Chmod(socketPath, mode); err == nil {
+				err = checkMode(mode)
+			}
+		}
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve listens on the unix socket at socketPath (see listenServeSocket), and runs
+// each request it receives in a container of its own, one connection per request.
+func serve(socketPath string, socketGID int, verbose bool) error {
+	// a socket left behind by a previous server which did not exit cleanly
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := listenServeSocket(socketPath, socketGID)
+	if err != nil {
+		return err
+	}
+	defer addCleanup(func() { listener.Close() })()
+	if verbose {
+		fmt.Fprintf(os.Stderr, "serving on %s\n", socketPath)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveConn(conn, verbose); err != nil && verbose {
+				fmt.Fprintf(os.Stderr, "WARNING: request failed: %s\n", err)
+			}
+		}()
+	}
+}
+
+func serveConn(conn net.Conn, verbose bool) error {
+	enc := &serveEncoder{enc: json.NewEncoder(conn)}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return err
+	}
+	var req serveRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return enc.send(serveResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+	}
+	if err := validateServeRequest(req); err != nil {
+		return enc.send(serveResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "running %q in %s\n", req.Command, req.Image)
+	}
+	exitCode, err := runServeRequest(req, serveStream{name: "stdout", enc: enc}, serveStream{name: "stderr", enc: enc}, verbose)
+	if err != nil {
+		return enc.send(serveResponse{Error: err.Error()})
+	}
+	return enc.send(serveResponse{ExitCode: &exitCode})
+}
+
+// runServeRequest extracts the request's image to a bundle of its own, and runs
+// its command there with RunContainer, returning the command's exit code.
+func runServeRequest(req serveRequest, stdout, stderr io.Writer, verbose bool) (int, error) {
+	sum, err := acbrun.GetTarSha256String(req.Image)
+	if err != nil {
+		return 0, err
+	}
+	if sum != req.Sha256 {
+		return 0, fmt.Errorf("expected sha256 sum %s does not match actual sum of %s: %s", req.Sha256, req.Image, sum)
+	}
+	containerName := acbrun.RandStringBytesMask(12)
+	workingDir, err := os.MkdirTemp(execDir(os.TempDir()), fmt.Sprintf("acbrun-%s", containerName))
+	if err != nil {
+		return 0, err
+	}
+	defer addCleanup(func() { removeWorkingDir(workingDir, false) })()
+	rootFS := filepath.Join(workingDir, "rootfs")
+	if err := os.Mkdir(rootFS, 0755); err != nil {
+		return 0, err
+	}
+	if err := extractImage(req.Image, workingDir, rootFS, extractImageOptions{Verbose: verbose}); err != nil {
+		return 0, err
+	}
+	configJSON, err := serveConfig(req, workingDir, rootFS)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(workingDir, "config.json"), []byte(configJSON), 0644); err != nil {
+		return 0, err
+	}
+	err = acbrun.RunContainer(containerName, acbrun.RunOptions{
+		BundleDir: workingDir,
+		Stdout:    stdout,
+		Stderr:    stderr,
+	})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// serveConfig returns the runtime config which runs the request's command as the
+// image's user, with the image's environment and the request's mounts, which are
+// validated as those given on the command line are.
+func serveConfig(req serveRequest, workingDir, rootFS string) (string, error) {
+	imageConfig, err := readImageConfig(workingDir)
+	if err != nil {
+		return "", err
+	}
+	configJSON := configJSONTemplate
+	if user := imageConfig.Config.User; user != "" {
+		uid, gid, err := acbrun.ResolveUser(rootFS, user)
+		if err != nil {
+			return "", fmt.Errorf("unable to resolve image user %q: %w", user, err)
+		}
+		if configJSON, err = sjson.Set(configJSON, "process.user.uid", uid); err != nil {
+			return "", err
+		}
+		if configJSON, err = sjson.Set(configJSON, "process.user.gid", gid); err != nil {
+			return "", err
+		}
+	}
+	configJSON, err = sjson.Set(configJSON, "process.args", []string{"sh", "-c", req.Command})
+	if err != nil {
+		return "", err
+	}
+	var env []string
+	for _, e := range gjson.Get(configJSON, "process.env").Array() {
+		env = append(env, e.String())
+	}
+	configJSON, err = sjson.Set(configJSON, "process.env", mergeEnv(env, imageConfig.Config.Env))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range req.Mounts {
+		configJSON, err = addBindMount(configJSON, m.Source, m.Destination, m.ReadOnly)
+		if err != nil {
+			return "", err
+		}
+	}
+	return configJSON, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+var shellSafeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_./:=@%+,-]+$`)
+
+// shellQuote quotes s so that sh treats it as a single word, with no expansion.
+func shellQuote(s string) string {
+	if shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// shellJoin quotes each of args with shellQuote, joining them into a single
+// command line for sh -c.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// withArgv0 returns the process args which run argv with argv0 as its argv[0], or
+// argv itself when argv0 is empty. The runtime executes the program named by
+// argv[0], so the two cannot differ in the spec; instead acbrun, mounted at
+// argv0MountPath, execs the program under the other name (see execWithArgv0).
+func withArgv0(argv []string, argv0 string) []string {
+	if argv0 == "" {
+		return argv
+	}
+	return append([]string{argv0MountPath, argv0ExecCommand, argv0}, argv...)
+}
+
+const (
+	// argv0MountPath is where --argv0 mounts the acbrun executable; like
+	// initMountPath, it is on the container's /dev tmpfs
+	argv0MountPath = "/dev/argv0"
+
+	// argv0ExecCommand is the hidden command which --argv0 runs in the container
+	argv0ExecCommand = "argv0-exec"
+)
+
+// execWithArgv0 replaces acbrun, running inside the container, with the program
+// args[1] (looked up in PATH), passing it args[2:] and args[0] as its argv[0].
+func execWithArgv0(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s %s <argv0> <program> [args...]\n", argv0MountPath, argv0ExecCommand)
+		os.Exit(127)
+	}
+	path, err := exec.LookPath(args[1])
+	if err == nil {
+		err = syscall.Exec(path, append([]string{args[0]}, args[2:]...), os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "error: unable to run %s: %s\n", args[1], err)
+	os.Exit(127)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// forwardStopSignals stops the named container using stopSignal whenever acbrun
+// itself is interrupted or terminated, rather than leaving the container behind.
+// Forwarding continues until the returned function is called.
+func forwardStopSignals(containerName string, stopSignal syscall.Signal, verbose bool) (stop func()) {
+	forwarding.Store(true)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if verbose {
+					fmt.Fprintf(os.Stderr, "received %s; stopping container %s with signal %d\n", sig, containerName, stopSignal)
+				}
+				if err := acbrun.StopContainer(containerName, stopSignal); err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: failed to stop container %s: %s\n", containerName, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		forwarding.Store(false)
+	}
+}
+
+var (
+	// forwarding is set while forwardStopSignals is handling signals; acbrun then
+	// waits for the container to stop and cleans up on its normal return path.
+	forwarding atomic.Bool
+
+	cleanupsMu sync.Mutex
+	cleanups   []*cleanup
+)
+
+type cleanup struct {
+	once sync.Once
+	fn   func()
+}
+
+// addCleanup registers fn to be run if acbrun is interrupted or terminated, so that
+// temporary directories are not leaked. The returned function runs fn and removes it
+// from the registry; it is meant to be deferred for the normal return path. fn runs
+// at most once either way.
+func addCleanup(fn func()) (run func()) {
+	c := &cleanup{fn: fn}
+	cleanupsMu.Lock()
+	cleanups = append(cleanups, c)
+	cleanupsMu.Unlock()
+	return func() {
+		cleanupsMu.Lock()
+		for i, other := range cleanups {
+			if other == c {
+				cleanups = append(cleanups[:i], cleanups[i+1:]...)
+				break
+			}
+		}
+		cleanupsMu.Unlock()
+		c.once.Do(c.fn)
+	}
+}
+
+// cleanupOnSignal runs the registered cleanups, most recent first, and exits when
+// acbrun receives SIGINT or SIGTERM while no container is running in the foreground.
+func cleanupOnSignal(verbose bool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			if forwarding.Load() {
+				continue
+			}
+			if verbose {
+				fmt.Fprintf(os.Stderr, "received %s; removing temporary files\n", sig)
+			}
+			exitAfterCleanup(128 + int(sig.(syscall.Signal)))
+		}
+	}()
+}
+
+// exitAfterCleanup runs the registered cleanups, most recent first, and exits with
+// code; unlike a plain os.Exit, it does not leak temporary directories.
+func exitAfterCleanup(code int) {
+	cleanupsMu.Lock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i].once.Do(cleanups[i].fn)
+	}
+	cleanupsMu.Unlock()
+	os.Exit(code)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// snapshotDir is where --cache-snapshots keeps the fully extracted rootfs of the
+// image with the given sha256, along with its manifest and config, as:
+//
+//	<snapshotDir>/rootfs
+//	<snapshotDir>/metadata/manifest.json
+//	<snapshotDir>/metadata/<config>
+func snapshotDir(imageSha256 string) string {
+	return filepath.Join(stateDir, "acbrun-snapshots", imageSha256)
+}
+
+// cloneTree copies the contents of src into the existing directory dst, keeping
+// ownership, modes, timestamps, and hard links; on filesystems which support it,
+// the files' data is shared (reflinked) rather than copied.
+func cloneTree(src, dst string) error {
+	out, err := exec.Command("cp", "-a", "--reflink=auto", src+"/.", dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cp: %w: %s", err, out)
+	}
+	return nil
+}
+
+// restoreSnapshot clones the snapshot of the image with the given sha256 into
+// rootFS and workingDir, reporting false when there is no such snapshot.
+func restoreSnapshot(imageSha256, workingDir, rootFS string) (bool, error) {
+	dir := snapshotDir(imageSha256)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := cloneTree(filepath.Join(dir, "metadata"), workingDir); err != nil {
+		return false, err
+	}
+	if err := cloneTree(filepath.Join(dir, "rootfs"), rootFS); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// saveSnapshot stores the freshly extracted rootFS, and the image metadata in
+// workingDir, as the snapshot of the image with the given sha256. The snapshot is
+// assembled alongside its final location and renamed into place, so concurrent
+// runs only ever see complete snapshots.
+func saveSnapshot(imageSha256, workingDir, rootFS string) error {
+	dir := snapshotDir(imageSha256)
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), imageSha256+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest, err := getManifest(filepath.Join(workingDir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	metadataFiles := []string{"manifest.json"}
+	if manifest.Config != "" {
+		metadataFiles = append(metadataFiles, manifest.Config)
+	}
+	for _, name := range metadataFiles {
+		data, err := os.ReadFile(filepath.Join(workingDir, name))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(tmpDir, "metadata", name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "rootfs"), 0755); err != nil {
+		return err
+	}
+	if err := cloneTree(rootFS, filepath.Join(tmpDir, "rootfs")); err != nil {
+		return err
+	}
+	err = os.Rename(tmpDir, dir)
+	if errors.Is(err, os.ErrExist) || errors.Is(err, syscall.ENOTEMPTY) {
+		// another run saved the same snapshot first
+		return nil
+	}
+	return err
+}
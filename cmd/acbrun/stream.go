@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/alexcb/acbrun/v2"
+)
+
+// stdinImage is given in place of the image's path to read it from stdin.
+const stdinImage = "-"
+
+// imageSpooler writes the entries of an image's tar to workingDir in a single
+// pass, picking up the manifest as it goes. Images usually list manifest.json last,
+// so the layers which come before it are written out like any other file, to be
+// applied with applyLayers once the manifest has been read.
+type imageSpooler struct {
+	workingDir string
+
+	// streamLayer, when set, is passed the layer of a single layer image instead
+	// of it being written out, which is only possible when the manifest comes
+	// before the layer; streamed reports whether it was
+	streamLayer func(name string, r io.Reader) error
+	streamed    bool
+
+	manifest *Manifest
+
+	// symlinks holds the symlinks written so far, by image path; entries beneath
+	// them, or replacing them, are rejected so that nothing is written through them
+	symlinks map[string]bool
+}
+
+func newImageSpooler(workingDir string, streamLayer func(name string, r io.Reader) error) *imageSpooler {
+	return &imageSpooler{
+		workingDir:  workingDir,
+		streamLayer: streamLayer,
+		symlinks:    make(map[string]bool),
+	}
+}
+
+// spoolEntry handles a single entry of the image's tar; it is passed to the
+// acbrun.WalkTarGz functions.
+func (s *imageSpooler) spoolEntry(header *tar.Header, tr io.Reader) error {
+	name := path.Clean(header.Name)
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("%w: %s is outside of the image", acbrun.ErrInvalidArchive, header.Name)
+	}
+	for p := name; p != "."; p = path.Dir(p) {
+		if s.symlinks[p] {
+			return fmt.Errorf("%w: %s is beneath the symlink %s", acbrun.ErrInvalidArchive, header.Name, p)
+		}
+	}
+	dst := filepath.Join(s.workingDir, name)
+
+	if s.streamLayer != nil && s.manifest != nil && len(s.manifest.Layers) == 1 && name == path.Clean(s.manifest.Layers[0]) {
+		s.streamed = true
+		return s.streamLayer(s.manifest.Layers[0], tr)
+	}
+
+	var err error
+	switch header.Typeflag {
+	case tar.TypeDir:
+		err = os.MkdirAll(dst, 0755)
+	case tar.TypeReg:
+		err = writeFileFromReader(dst, tr)
+	case tar.TypeSymlink:
+		// older docker save images link the layers their images share
+		if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+			err = os.Symlink(header.Linkname, dst)
+		}
+		s.symlinks[name] = true
+	}
+	if err != nil || name != "manifest.json" {
+		return err
+	}
+	m, err := getManifest(dst)
+	if err != nil {
+		return err
+	}
+	s.manifest = &m
+	return nil
+}
+
+// finish returns the manifest once every entry has been spooled.
+func (s *imageSpooler) finish() (Manifest, error) {
+	if s.manifest == nil {
+		return Manifest{}, fmt.Errorf("%w: the image does not contain a manifest.json", acbrun.ErrInvalidArchive)
+	}
+	if len(s.manifest.Layers) == 0 {
+		return Manifest{}, errors.New("no layer data")
+	}
+	return *s.manifest, nil
+}
+
+// spoolImageStream writes the contents of an image which can only be read once,
+// such as stdin, to workingDir, and returns the sha256 sum of the uncompressed
+// image, which it computes as the stream is consumed. Nothing is extracted to the
+// rootfs: the caller must check the sum, and only then apply the layers with
+// applyLayers.
+func spoolImageStream(r io.Reader, workingDir string) (sha256Hex string, err error) {
+	s := newImageSpooler(workingDir, nil)
+	imageDigest, err := acbrun.WalkTarGzDigest(r, s.spoolEntry)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.finish(); err != nil {
+		return "", err
+	}
+	return imageDigest.Encoded(), nil
+}
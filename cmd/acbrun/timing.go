@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type phaseTiming struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// phaseTimer records how long each phase of a run takes, in the order they ran.
+type phaseTimer struct {
+	verbose bool
+	phases  []phaseTiming
+}
+
+// start begins timing the named phase; the returned function ends it, and prints
+// its duration in verbose mode.
+func (t *phaseTimer) start(name string) (end func()) {
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		t.phases = append(t.phases, phaseTiming{Name: name, Duration: d})
+		if t.verbose {
+			fmt.Fprintf(os.Stderr, "%s phase took %s\n", name, d)
+		}
+	}
+}
+
+// runSummary is written by --summary-json.
+type runSummary struct {
+	Container string        `json:"container"`
+	Phases    []phaseTiming `json:"phases"`
+}
+
+func writeSummaryJSON(path string, summary runSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"regexp"
+	"syscall"
+)
+
+// tmpfsSizeRegexp matches the size values accepted by tmpfs, e.g. 512m or 50%.
+var tmpfsSizeRegexp = regexp.MustCompile(`^[0-9]+[kmgKMG%]?$`)
+
+// mountTmpfs mounts a tmpfs of at most size bytes (or a percentage of RAM) on dir.
+func mountTmpfs(dir, size string) error {
+	return syscall.Mount("tmpfs", dir, "tmpfs", 0, "mode=755,size="+size)
+}
+
+func unmountTmpfs(dir string) error {
+	return syscall.Unmount(dir, syscall.MNT_DETACH)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alexcb/acbrun/v2"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// verifyOutputImage re-reads a written output image and confirms that the config
+// and layers match the digests they are named after, and that the config's
+// DiffIDs refer to the layers in the manifest. zstdDict is the dictionary which
+// zstd compressed layers were compressed with, if any.
+func verifyOutputImage(image, zstdDict string) error {
+	manifest, err := readImageManifest(image)
+	if err != nil {
+		return err
+	}
+	layerNames := map[string]bool{}
+	for _, layer := range manifest.Layers {
+		layerNames[path.Clean(layer)] = true
+	}
+
+	r, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var imageConfig *imagespec.Image
+	verifiedLayers := map[string]string{}
+	err = acbrun.WalkTarGz(r, func(header *tar.Header, tr io.Reader) error {
+		name := path.Clean(header.Name)
+		switch {
+		case name == path.Clean(manifest.Config):
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+			if actual != manifest.Config {
+				return fmt.Errorf("config %s has digest %s", manifest.Config, actual)
+			}
+			imageConfig = &imagespec.Image{}
+			return json.Unmarshal(data, imageConfig)
+		case layerNames[name]:
+			uncompressedStream, err := acbrun.NewDecompressReaderWithDict(tr, zstdDict)
+			if err != nil {
+				return err
+			}
+			defer uncompressedStream.Close()
+			h := sha256.New()
+			if _, err := io.Copy(h, uncompressedStream); err != nil {
+				return err
+			}
+			actual := hex.EncodeToString(h.Sum(nil))
+			expected := strings.TrimSuffix(strings.TrimSuffix(path.Base(name), ".tar.gz"), ".tar.zst")
+			if actual != expected {
+				return fmt.Errorf("layer %s has digest sha256:%s", name, actual)
+			}
+			verifiedLayers[name] = "sha256:" + actual
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if imageConfig == nil {
+		return fmt.Errorf("config %s is missing", manifest.Config)
+	}
+	if len(imageConfig.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("config lists %d layers but the manifest lists %d", len(imageConfig.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	for i, layer := range manifest.Layers {
+		diffID, ok := verifiedLayers[path.Clean(layer)]
+		if !ok {
+			return fmt.Errorf("layer %s is missing", layer)
+		}
+		if string(imageConfig.RootFS.DiffIDs[i]) != diffID {
+			return fmt.Errorf("layer %s does not match config diff id %s", layer, imageConfig.RootFS.DiffIDs[i])
+		}
+	}
+	return nil
+}
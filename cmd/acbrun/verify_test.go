@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeOutputImage writes an image laid out as an output image is, with its
+// config and layer named after their digests, to imagePath. corrupt may change
+// the layer and config after they have been named.
+func writeOutputImage(t *testing.T, imagePath string, corrupt func(layer, config []byte) ([]byte, []byte)) {
+	t.Helper()
+	var layerTar bytes.Buffer
+	tw := tar.NewWriter(&layerTar)
+	contents := strings.Repeat("output\n", 1000)
+	if err := tw.WriteHeader(&tar.Header{Name: "etc/output", Mode: 0644, Size: int64(len(contents))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(contents))
+	tw.Close()
+	diffID := fmt.Sprintf("%x", sha256.Sum256(layerTar.Bytes()))
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	gw.Write(layerTar.Bytes())
+	gw.Close()
+
+	layerName := diffID + ".tar.gz"
+	config := []byte(fmt.Sprintf(`{"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:%s"]}}`, diffID))
+	configName := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest := fmt.Sprintf(`[{"Config":%q,"Layers":[%q]}]`, configName, layerName)
+	layerData, configData := layer.Bytes(), config
+	if corrupt != nil {
+		layerData, configData = corrupt(bytes.Clone(layerData), bytes.Clone(configData))
+	}
+
+	f, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw = gzip.NewWriter(f)
+	tw = tar.NewWriter(gw)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{layerName, layerData}, {configName, configData}, {"manifest.json", []byte(manifest)}} {
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyOutputImage(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image.tar.gz")
+	writeOutputImage(t, image, nil)
+	if err := verifyOutputImage(image, ""); err != nil {
+		t.Fatalf("expected a correctly written image to verify: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		corrupt func(layer, config []byte) ([]byte, []byte)
+		err     string
+	}{
+		{"flipped layer byte", func(layer, config []byte) ([]byte, []byte) {
+			layer[len(layer)/2] ^= 0xff
+			return layer, config
+		}, ""},
+		{"truncated layer", func(layer, config []byte) ([]byte, []byte) {
+			return layer[:len(layer)/2], config
+		}, ""},
+		{"modified config", func(layer, config []byte) ([]byte, []byte) {
+			return layer, bytes.Replace(config, []byte("linux"), []byte("Linux"), 1)
+		}, "has digest"},
+		{"truncated config", func(layer, config []byte) ([]byte, []byte) {
+			return layer, config[:len(config)-1]
+		}, "has digest"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image := filepath.Join(t.TempDir(), "image.tar.gz")
+			writeOutputImage(t, image, tc.corrupt)
+			err := verifyOutputImage(image, "")
+			if err == nil {
+				t.Fatal("expected the corrupted image to fail verification")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected an error containing %q, got: %s", tc.err, err)
+			}
+		})
+	}
+}
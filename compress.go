@@ -0,0 +1,292 @@
+package acbrun
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Compression identifies the compression format of a layer.
+type Compression string
+
+const (
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	CompressionNone Compression = "none" // an uncompressed tar
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// tar headers hold "ustar" at tarMagicOffset, in both the POSIX and GNU formats
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// DetectCompression sniffs the compression format of r from its magic bytes. The
+// returned reader must be used in place of r, as it replays the sniffed bytes.
+func DetectCompression(r io.Reader) (Compression, io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip, br, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CompressionZstd, br, nil
+	case len(magic) > tarMagicOffset && bytes.HasPrefix(magic[tarMagicOffset:], tarMagic):
+		return CompressionNone, br, nil
+	case len(magic) > tarMagicOffset && isZeros(magic):
+		// the end of archive marker of an empty tar, e.g. the layers which older
+		// versions of docker save wrote for instructions which add no files
+		return CompressionNone, br, nil
+	}
+	return "", nil, fmt.Errorf("unsupported compression format (magic bytes %x)", magic[:min(len(magic), len(zstdMagic))])
+}
+
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NewDecompressReader returns the decompressed contents of r, detecting whether it
+// is gzip or zstd compressed, or not compressed at all. The caller must close the
+// returned reader.
+func NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	return newDecompressReader(r, false, "")
+}
+
+// NewDecompressReaderWithDict is NewDecompressReader for streams whose zstd
+// compression may have used the dictionary at the path zstdDict.
+func NewDecompressReaderWithDict(r io.Reader, zstdDict string) (io.ReadCloser, error) {
+	return newDecompressReader(r, false, zstdDict)
+}
+
+func newDecompressReader(r io.Reader, gzipReadahead bool, zstdDict string) (io.ReadCloser, error) {
+	compression, r, err := DetectCompression(r)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case CompressionZstd:
+		return newZstdReader(r, zstdDict)
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		rc, err := newGzipReader(r, gzipReadahead)
+		return rc, archiveError(err)
+	}
+}
+
+// newGzipReader decompresses r with compress/gzip, on a goroutine of its own which
+// reads ahead of the caller when readahead is set.
+func newGzipReader(r io.Reader, readahead bool) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil || !readahead {
+		return gz, err
+	}
+	return newReadAheadReader(gz), nil
+}
+
+const (
+	readAheadBlockSize = 1 << 20
+	readAheadBlocks    = 8
+)
+
+// readAheadReader reads from an underlying reader on its own goroutine, up to
+// readAheadBlocks blocks ahead of its caller. Decompressing a gzip stream is
+// inherently sequential, but this lets it overlap with the caller's work, such as
+// parsing the tar and writing out its files, which is where pgzip's speed up comes
+// from too.
+type readAheadReader struct {
+	rc     io.ReadCloser
+	blocks chan []byte
+	free   chan []byte
+	done   chan struct{}
+	cur    []byte
+	buf    []byte // the block which cur is a slice of, returned to free once read
+	err    error  // set before blocks is closed
+}
+
+func newReadAheadReader(rc io.ReadCloser) *readAheadReader {
+	r := &readAheadReader{
+		rc:     rc,
+		blocks: make(chan []byte, readAheadBlocks),
+		free:   make(chan []byte, readAheadBlocks+1),
+		done:   make(chan struct{}),
+	}
+	go r.readAhead()
+	return r
+}
+
+func (r *readAheadReader) readAhead() {
+	defer close(r.blocks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-r.free:
+		default:
+			buf = make([]byte, readAheadBlockSize)
+		}
+		n, err := io.ReadFull(r.rc, buf)
+		if n > 0 {
+			select {
+			case r.blocks <- buf[:n]:
+			case <-r.done:
+				return
+			}
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return
+		}
+	}
+}
+
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.buf != nil {
+			select {
+			case r.free <- r.buf[:cap(r.buf)]:
+			default:
+			}
+			r.buf = nil
+		}
+		block, ok := <-r.blocks
+		if !ok {
+			return 0, r.err
+		}
+		r.cur, r.buf = block, block
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close stops reading ahead. The underlying reader is closed once the goroutine
+// reading from it has stopped, as it may be mid read.
+func (r *readAheadReader) Close() error {
+	close(r.done)
+	go func() {
+		for range r.blocks {
+		}
+		r.rc.Close()
+	}()
+	return nil
+}
+
+// newZstdReader decompresses using the zstd command, which avoids pulling in a zstd
+// implementation for what is a comparatively rare layer format.
+func newZstdReader(r io.Reader, dict string) (*commandReader, error) {
+	return newCommandReader(r, "zstd", zstdArgs("--decompress", dict)...)
+}
+
+// newZstdWriter compresses what is written to it into w using the zstd command,
+// with the dictionary at the path dict, if set. It must be closed to flush the
+// compressed stream.
+func newZstdWriter(w io.Writer, dict string) (*commandWriter, error) {
+	return newCommandWriter(w, "zstd", zstdArgs("--compress", dict)...)
+}
+
+func zstdArgs(mode, dict string) []string {
+	args := []string{mode, "--stdout", "--quiet"}
+	if dict != "" {
+		args = append(args, "-D", dict)
+	}
+	return args
+}
+
+// commandReader reads the output of a decompression command which is fed r.
+type commandReader struct {
+	io.ReadCloser
+	name string
+	cmd  *exec.Cmd
+}
+
+func newCommandReader(r io.Reader, name string, args ...string) (*commandReader, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s compressed data requires the %s command: %w", name, name, err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: stdout, name: name, cmd: cmd}, nil
+}
+
+func (z *commandReader) Read(p []byte) (int, error) {
+	n, err := z.ReadCloser.Read(p)
+	if err == io.EOF && z.cmd != nil {
+		// surface decompression failures rather than a silently truncated stream
+		if waitErr := z.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("%s: %w", z.name, waitErr)
+		}
+		z.cmd = nil
+	}
+	return n, err
+}
+
+func (z *commandReader) Close() error {
+	err := z.ReadCloser.Close()
+	if z.cmd != nil {
+		// the command may still be writing if the stream was not fully consumed;
+		// closing the pipe above makes it exit
+		z.cmd.Wait()
+		z.cmd = nil
+	}
+	return err
+}
+
+// commandWriter feeds what is written to it to a compression command, whose output
+// goes to the underlying writer.
+type commandWriter struct {
+	io.WriteCloser
+	name string
+	cmd  *exec.Cmd
+}
+
+func newCommandWriter(w io.Writer, name string, args ...string) (*commandWriter, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s compression requires the %s command: %w", name, name, err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandWriter{WriteCloser: stdin, name: name, cmd: cmd}, nil
+}
+
+// Close ends the input of the command and waits for it to write the rest of its
+// output, reporting whether it succeeded.
+func (z *commandWriter) Close() error {
+	err := z.WriteCloser.Close()
+	if waitErr := z.cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("%s: %w", z.name, waitErr)
+	}
+	return err
+}
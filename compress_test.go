@@ -0,0 +1,92 @@
+package acbrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// largeLayer returns a gzip compressed layer of files of compressible data, of
+// about size bytes uncompressed.
+func largeLayer(tb testing.TB, size int) []byte {
+	tb.Helper()
+	rng := rand.New(rand.NewSource(1))
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	for i := 0; size > 0; i++ {
+		raw := make([]byte, 768<<10)
+		rng.Read(raw)
+		data := []byte(base64.StdEncoding.EncodeToString(raw))
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file%d", i), Mode: 0644, Size: int64(len(data))}); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			tb.Fatal(err)
+		}
+		size -= len(data)
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return layer.Bytes()
+}
+
+func TestExtractTarGzGzipReadahead(t *testing.T) {
+	layer := largeLayer(t, 8<<20)
+	var dirs [2]string
+	for i, readahead := range []bool{false, true} {
+		dirs[i] = t.TempDir()
+		if _, err := ExtractTarGzWithOptions(bytes.NewReader(layer), dirs[i], ExtractOptions{GzipReadahead: readahead}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		expected, _ := os.ReadFile(filepath.Join(dirs[0], entry.Name()))
+		actual, err := os.ReadFile(filepath.Join(dirs[1], entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("%s differs when decompressed ahead of extraction", entry.Name())
+		}
+	}
+
+	// corruption is reported rather than the stream ending early
+	corrupt := bytes.Clone(layer)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if _, err := ExtractTarGzWithOptions(bytes.NewReader(corrupt), t.TempDir(), ExtractOptions{GzipReadahead: true}); err == nil {
+		t.Error("expected a corrupt layer to fail to extract")
+	}
+}
+
+func BenchmarkExtractTarGz(b *testing.B) {
+	layer := largeLayer(b, 256<<20)
+	for _, readahead := range []bool{false, true} {
+		b.Run(fmt.Sprintf("gzipReadahead=%v", readahead), func(b *testing.B) {
+			b.SetBytes(int64(len(layer)))
+			for i := 0; i < b.N; i++ {
+				dst := b.TempDir()
+				if _, err := ExtractTarGzWithOptions(bytes.NewReader(layer), dst, ExtractOptions{GzipReadahead: readahead}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(dst)
+				b.StartTimer()
+			}
+		})
+	}
+}
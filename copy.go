@@ -0,0 +1,102 @@
+package acbrun
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CopyPath recursively copies the file, directory, or symlink at src to dst,
+// preserving permission bits (including setuid, setgid, and sticky bits, which
+// are not subject to the umask when set with chmod). Existing files at dst are
+// overwritten; existing directories are merged into. Existing symlinks at dst, or
+// beneath it, are replaced rather than followed.
+func CopyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				if !os.IsExist(err) {
+					return err
+				}
+				if err := replaceNonDir(target); err != nil {
+					return err
+				}
+			}
+		case mode.IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			// symlinks have no permissions of their own
+			return os.Symlink(link, target)
+		default:
+			return fmt.Errorf("unable to copy %s: unsupported file type %s", path, mode.Type())
+		}
+		return os.Chmod(target, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	})
+}
+
+// replaceNonDir replaces whatever exists at path with an empty directory, unless
+// it is a directory already.
+func replaceNonDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return os.Mkdir(path, 0700)
+}
+
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := out.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,62 @@
+package acbrun
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+var (
+	// ErrDigestMismatch is wrapped by errors reporting content whose digest is not
+	// the expected one.
+	ErrDigestMismatch = errors.New("digest mismatch")
+
+	// ErrRuntimeNotFound is wrapped by the errors of the container functions when
+	// runc is not installed.
+	ErrRuntimeNotFound = errors.New("runc was not found")
+
+	// ErrSkippedEntries is wrapped by the error returned when entries were skipped
+	// in ExtractOptions.BestEffort mode.
+	ErrSkippedEntries = errors.New("entries could not be extracted and were skipped")
+
+	// ErrInvalidArchive is wrapped by errors caused by data which is not a valid
+	// (compressed) tar archive, e.g. a truncated file or one of another format.
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+// ExtractError reports an archive entry which could not be extracted.
+type ExtractError struct {
+	Entry string // name of the entry in the archive
+	Err   error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("extracting %s: %s", e.Entry, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// runcError wraps err with ErrRuntimeNotFound when it was caused by runc not being
+// installed.
+func runcError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: %w", ErrRuntimeNotFound, err)
+	}
+	return err
+}
+
+// archiveError wraps err with ErrInvalidArchive when it was caused by malformed
+// gzip or tar data.
+func archiveError(err error) error {
+	for _, target := range []error{gzip.ErrHeader, gzip.ErrChecksum, tar.ErrHeader, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package acbrun
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ExtractFS is the filesystem which the Extract functions write to; OSFS is used
+// unless ExtractOptions.FS is set. Names are the destination directory joined
+// with the entry's name. Mkdir must return an error wrapping fs.ErrExist when the
+// name already exists, and Remove one wrapping fs.ErrNotExist when it does not.
+// It must be safe for concurrent use when ExtractOptions.Concurrency is set.
+type ExtractFS interface {
+	Mkdir(name string, perm fs.FileMode) error
+	// Create creates or truncates the named regular file for writing; it must fail
+	// rather than follow name if it is a symlink.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Chmod(name string, mode fs.FileMode) error
+	// Lchown changes the ownership of name, without following it if it is a symlink.
+	Lchown(name string, uid, gid int) error
+	// Lstat describes name, without following it if it is a symlink.
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// OSFS is the ExtractFS of the host filesystem.
+type OSFS struct{}
+
+func (OSFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (OSFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perm)
+}
+
+func (OSFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Lchown is a no-op unless running as root, as other users can not give files
+// away; their extracted files are owned by them instead.
+func (OSFS) Lchown(name string, uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(name, uid, gid)
+}
+
+func (OSFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
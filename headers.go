@@ -0,0 +1,94 @@
+package acbrun
+
+import (
+	"archive/tar"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// paxXattrPrefix marks the PAX records holding extended attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// TarHeaders records the headers of the entries which an extraction writes, so
+// that an archive of the extracted tree can reproduce the metadata which the
+// extraction does not restore: user and group names, modification times, extended
+// attributes, and ownership which could not be applied (e.g. when not extracting
+// as root).
+//
+// Pass the same TarHeaders as ExtractOptions.RecordHeaders when extracting, and as
+// CreateTarGzOptions.ReplayHeaders when archiving. Only files which are unchanged
+// since they were extracted, judged by their type, size, and modification time,
+// have their headers replayed; metadata-only changes, such as a chown, are not
+// detected.
+type TarHeaders struct {
+	mu      sync.Mutex
+	entries map[string]*recordedHeader
+}
+
+type recordedHeader struct {
+	header *tar.Header
+
+	// info is that of the extracted file, or nil until it has been written
+	info fs.FileInfo
+}
+
+func NewTarHeaders() *TarHeaders {
+	return &TarHeaders{entries: map[string]*recordedHeader{}}
+}
+
+// record stores the header of the entry at the archive path name; a later entry for
+// the same path replaces it.
+func (t *TarHeaders) record(name string, header *tar.Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := *header
+	t.entries[name] = &recordedHeader{header: &h}
+}
+
+// update records how every recorded entry now looks in dst, where they were
+// extracted to, forgetting those which no longer exist (e.g. because they were
+// not included, or a later layer removed them). It is called once each extraction
+// is complete, as extracting later entries changes the modification times of the
+// directories holding them.
+func (t *TarHeaders) update(dst string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, entry := range t.entries {
+		info, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			delete(t.entries, name)
+			continue
+		}
+		entry.info = info
+	}
+}
+
+// replay copies the recorded metadata of the file at the archive path name into
+// h, the header built from its current info, if the file is unchanged since it was
+// extracted.
+func (t *TarHeaders) replay(name string, info fs.FileInfo, h *tar.Header) {
+	t.mu.Lock()
+	entry, ok := t.entries[archivePath(name)]
+	t.mu.Unlock()
+	if !ok || entry.info == nil || entry.header.Typeflag != h.Typeflag {
+		return
+	}
+	if info.Mode().Type() != entry.info.Mode().Type() || info.Size() != entry.info.Size() || !info.ModTime().Equal(entry.info.ModTime()) {
+		return
+	}
+	recorded := entry.header
+	h.Uid, h.Gid = recorded.Uid, recorded.Gid
+	h.Uname, h.Gname = recorded.Uname, recorded.Gname
+	h.ModTime = recorded.ModTime
+	for key, value := range recorded.PAXRecords {
+		if strings.HasPrefix(key, paxXattrPrefix) {
+			if h.PAXRecords == nil {
+				h.PAXRecords = map[string]string{}
+			}
+			h.PAXRecords[key] = value
+		}
+	}
+}
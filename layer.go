@@ -0,0 +1,161 @@
+package acbrun
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// WhiteoutPrefix marks a layer entry which deletes the path named by the rest
+	// of its name from the layers below it.
+	WhiteoutPrefix = ".wh."
+
+	// WhiteoutOpaque marks its directory as opaque: everything the layers below put
+	// in the directory is hidden.
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// LayerOptions controls how StreamLayer extracts a layer.
+type LayerOptions struct {
+	ExtractOptions
+
+	// KeepWhiteouts extracts whiteout files as they are, rather than applying them
+	// to dst, e.g. to convert them for overlayfs once the layer is extracted.
+	KeepWhiteouts bool
+
+	// Stats is set to what was extracted when it is not nil.
+	Stats *ExtractStats
+}
+
+// StreamLayer extracts an image layer, which may be gzip or zstd compressed or an
+// uncompressed tar, on top of the layers already extracted to dst, and returns its
+// DiffID (the digest of the uncompressed tar). Unless opts.KeepWhiteouts is set,
+// whiteout files delete the paths they name from dst instead of being extracted.
+func StreamLayer(r io.Reader, dst string, opts LayerOptions) (digest.Digest, error) {
+	start := time.Now()
+	uncompressedStream, err := newDecompressReader(r, opts.GzipReadahead, opts.ZstdDict)
+	if err != nil {
+		return "", err
+	}
+	defer uncompressedStream.Close()
+
+	digester := digest.SHA256.Digester()
+	tr := io.TeeReader(uncompressedStream, digester.Hash())
+	extractOpts := opts.ExtractOptions
+	extractOpts.whiteouts = !opts.KeepWhiteouts
+	stats, err := extractTar(tr, dst, extractOpts, start)
+	if opts.Stats != nil {
+		*opts.Stats = stats
+	}
+	if err != nil && !errors.Is(err, ErrSkippedEntries) {
+		return "", err
+	}
+	// extractTar reads the stream to its end, so the padding after the end of
+	// archive marker is part of the digest too
+	return digester.Digest(), err
+}
+
+// applyWhiteout deletes target, or with opaque, the contents of the directory
+// target, from the layers below the one being extracted.
+func (e *tarExtractor) applyWhiteout(target string, opaque bool) error {
+	if included, _ := e.included(target); !included {
+		return nil
+	}
+	if opaque {
+		return e.removeLower(target)
+	}
+	// whiteouts never apply to the layer which contains them
+	if e.written[target] {
+		return nil
+	}
+	targetPath, err := e.securePath(target)
+	if err != nil {
+		return err
+	}
+	e.forgetDir(target)
+	return e.fs.RemoveAll(targetPath)
+}
+
+// removeLower removes everything in dir which was not written by the layer being
+// extracted.
+func (e *tarExtractor) removeLower(dir string) error {
+	dirPath, err := e.securePath(dir)
+	if err != nil {
+		return err
+	}
+	// ReadDir follows symlinks, and there is nothing to remove beneath one
+	info, err := e.fs.Lstat(dirPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := e.fs.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if !e.written[name] {
+			e.forgetDir(name)
+			if err := e.fs.RemoveAll(filepath.Join(e.dst, name)); err != nil {
+				return err
+			}
+		} else if entry.IsDir() {
+			if err := e.removeLower(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// whiteoutTarget returns the archive path which a whiteout entry deletes, and
+// whether the entry is a whiteout at all; an opaque whiteout targets its directory.
+func whiteoutTarget(name string) (target string, opaque, ok bool) {
+	name = archivePath(name)
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	switch {
+	case base == WhiteoutOpaque:
+		return dir, true, true
+	case strings.HasPrefix(base, WhiteoutPrefix):
+		return path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)), false, true
+	}
+	return "", false, false
+}
+
+// LayerUncompressedSize returns the total size of the regular files in an image
+// layer, which may be gzip or zstd compressed or an uncompressed tar, i.e. roughly
+// the disk space extracting it takes. Nothing is extracted, and the size of
+// directories, links, and filesystem metadata is not included.
+func LayerUncompressedSize(r io.Reader) (int64, error) {
+	uncompressedStream, err := NewDecompressReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer uncompressedStream.Close()
+	var size int64
+	tr := tar.NewReader(uncompressedStream)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return 0, archiveError(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			size += header.Size
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package acbrun
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockFile blocks until it holds an exclusive flock on path, creating the file
+// if needed. The returned function releases the lock.
+func LockFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		defer f.Close()
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
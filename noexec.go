@@ -0,0 +1,18 @@
+package acbrun
+
+import (
+	"syscall"
+)
+
+// stNoexec is the ST_NOEXEC statfs flag
+const stNoexec = 0x8
+
+// IsNoexec reports whether path is on a filesystem mounted with the noexec option,
+// from which binaries (such as those of an extracted rootfs) cannot be run.
+func IsNoexec(path string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, err
+	}
+	return st.Flags&stNoexec != 0, nil
+}
@@ -3,17 +3,49 @@ package acbrun
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type RuncState struct {
 	Status string `json:"status"`
+	Pid    int    `json:"pid"`
 }
 
-func IsContainerRunning(name string) (bool, error) {
+// GetContainerState returns the runc status of the named container (e.g. "created",
+// "running", or "stopped"), or an empty string if the container does not exist.
+func GetContainerState(name string) (string, error) {
+	state, err := getRuncState(name)
+	if err != nil || state == nil {
+		return "", err
+	}
+	return state.Status, nil
+}
+
+// GetContainerPid returns the host pid of the named container's init process.
+func GetContainerPid(name string) (int, error) {
+	state, err := getRuncState(name)
+	if err != nil {
+		return 0, err
+	}
+	if state == nil {
+		return 0, fmt.Errorf("container %s does not exist", name)
+	}
+	if state.Pid == 0 {
+		return 0, fmt.Errorf("container %s is %s", name, state.Status)
+	}
+	return state.Pid, nil
+}
+
+// getRuncState returns nil if the named container does not exist.
+func getRuncState(name string) (*RuncState, error) {
 	cmd := exec.Command("runc", "state", name)
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
@@ -22,20 +54,143 @@ func IsContainerRunning(name string) (bool, error) {
 	stdoutStr := outb.String()
 	stderrStr := errb.String()
 	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, runcError(err)
+		}
 		if strings.Contains(stderrStr, "\"container does not exist\"") {
-			return false, nil
+			return nil, nil
 		}
 		fmt.Fprintf(os.Stderr, "runc: %s\n", stderrStr)
+		return nil, err
+	}
+	var runcState RuncState
+	err = json.Unmarshal([]byte(stdoutStr), &runcState)
+	if err != nil {
+		return nil, err
+	}
+	return &runcState, nil
+}
+
+func IsContainerRunning(name string) (bool, error) {
+	status, err := GetContainerState(name)
+	if err != nil {
 		return false, err
-	} else {
-		var runcState RuncState
-		err = json.Unmarshal([]byte(stdoutStr), &runcState)
+	}
+	return status == "running", nil
+}
+
+// WaitForContainerRunning polls the container state until it is running. An error is
+// returned if the container stops, disappears, or is not running within the timeout.
+func WaitForContainerRunning(name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := GetContainerState(name)
 		if err != nil {
-			return false, err
+			return err
+		}
+		switch status {
+		case "running":
+			return nil
+		case "":
+			return fmt.Errorf("container %s no longer exists", name)
+		case "stopped":
+			return fmt.Errorf("container %s stopped immediately after starting", name)
 		}
-		if runcState.Status != "running" {
-			return false, nil
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s is %s; timed out waiting for it to run", name, status)
 		}
-		return true, nil
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// StopContainer sends sig to the named container's init process.
+func StopContainer(name string, sig syscall.Signal) error {
+	cmd := exec.Command("runc", "kill", name, strconv.Itoa(int(sig)))
+	cmd.Stderr = os.Stderr
+	return runcError(cmd.Run())
+}
+
+// DeleteContainer removes the named container, killing its processes first if
+// it is still running.
+func DeleteContainer(name string) error {
+	cmd := exec.Command("runc", "delete", "--force", name)
+	cmd.Stderr = os.Stderr
+	return runcError(cmd.Run())
+}
+
+// RunOptions controls how RunContainer and ExecContainer invoke runc.
+type RunOptions struct {
+	// BundleDir is the directory holding config.json and the rootfs.
+	BundleDir string
+
+	// Detach starts the container in the background (RunContainer only).
+	Detach bool
+
+	// Tty allocates a terminal for the process (ExecContainer only; RunContainer
+	// uses process.terminal from config.json instead).
+	Tty bool
+
+	// LogPath is passed to runc's --log option when set.
+	LogPath string
+
+	// Debug passes --debug to runc, which then logs what it does in detail (to
+	// LogPath, if set).
+	Debug bool
+
+	// Stdin, Stdout, and Stderr are connected to the container process; a nil
+	// reader or writer is connected to the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (opts RunOptions) command(args ...string) *exec.Cmd {
+	commandArgs := []string{}
+	if opts.Debug {
+		commandArgs = append(commandArgs, "--debug")
+	}
+	if opts.LogPath != "" {
+		commandArgs = append(commandArgs, "--log", opts.LogPath)
+	}
+	commandArgs = append(commandArgs, args...)
+	cmd := exec.Command("runc", commandArgs...)
+	cmd.Dir = opts.BundleDir
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	return cmd
+}
+
+// RunContainer creates and starts the named container from opts.BundleDir, and
+// unless opts.Detach is set, waits for it to exit.
+func RunContainer(name string, opts RunOptions) error {
+	args := []string{"run"}
+	if opts.Detach {
+		args = append(args, "--detach")
+	}
+	args = append(args, name)
+
+	// whenever runc -d is used, if stdout or stderr are specified, it causes
+	// commands like "./acbrun ... | cat" to hang
+	// this needs to be fixed somehow, since we need to surface errors if runc run -d fails
+	// note that is also fails when we give it a bytes buffer or even a custom buffer that doesnt even print
+	//
+	// TODO I think we need to create some sort of FILE-based stdout/stderr connection here
+	// where we can completely detach it from this current process
+	// or clean it up after the Run() comes back.
+	// the issue might be related to the "runc --detach" process continuing to persist AFTER
+	// this go process returns
+	// This seems related: https://github.com/opencontainers/runc/issues/1721
+	return runcError(opts.command(args...).Run())
+}
+
+// ExecContainer runs args as an additional process inside the running named container.
+func ExecContainer(name string, args []string, opts RunOptions) error {
+	execArgs := []string{"exec"}
+	if opts.Tty {
+		execArgs = append(execArgs, "--tty")
 	}
+	execArgs = append(execArgs, name)
+	execArgs = append(execArgs, args...)
+	return runcError(opts.command(execArgs...).Run())
 }
@@ -0,0 +1,67 @@
+package acbrun
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubRuntime puts a runc on PATH which runs a container's process.args, and the
+// args given to exec, directly on the host.
+func stubRuntime(t *testing.T) {
+	t.Helper()
+	binDir := t.TempDir()
+	stub := `#!/bin/sh
+case "$1" in
+run)
+    exec python3 -c 'import json, os; args = json.load(open("config.json"))["process"]["args"]; os.execvp(args[0], args)'
+    ;;
+exec)
+    shift 2
+    exec "$@"
+    ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(binDir, "runc"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunContainerStdin(t *testing.T) {
+	stubRuntime(t)
+	bundleDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bundleDir, "config.json"), []byte(`{"process":{"args":["cat"]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const input = "hello from stdin\nand a second line\n"
+
+	var stdout bytes.Buffer
+	err := RunContainer("stdin-test", RunOptions{BundleDir: bundleDir, Stdin: strings.NewReader(input), Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != input {
+		t.Errorf("expected run to echo %q, got %q", input, stdout.String())
+	}
+
+	stdout.Reset()
+	err = ExecContainer("stdin-test", []string{"cat"}, RunOptions{BundleDir: bundleDir, Stdin: strings.NewReader(input), Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != input {
+		t.Errorf("expected exec to echo %q, got %q", input, stdout.String())
+	}
+
+	// a nil Stdin is the null device, so cat reads nothing rather than blocking
+	stdout.Reset()
+	if err := RunContainer("stdin-test", RunOptions{BundleDir: bundleDir, Stdout: &stdout}); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output without stdin, got %q", stdout.String())
+	}
+}
@@ -2,91 +2,850 @@ package acbrun
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
 )
 
-func ExtractTarGz(gzipStream io.Reader, dst string) (err error) {
-	uncompressedStream, err := gzip.NewReader(gzipStream)
+// ExtractStats summarizes the work done by ExtractTarGzWithStats.
+type ExtractStats struct {
+	Files    int64 // regular files and hard links
+	Dirs     int64
+	Symlinks int64
+	Bytes    int64 // bytes written to regular files
+	Deduped  int64 // regular files replaced by a hard link (see ExtractOptions.Dedup)
+	Skipped  int64 // entries which failed to extract (see ExtractOptions.BestEffort)
+	Duration time.Duration
+}
+
+func (s ExtractStats) String() string {
+	str := fmt.Sprintf("%d files, %d dirs, %d symlinks, %d bytes in %s", s.Files, s.Dirs, s.Symlinks, s.Bytes, s.Duration)
+	if s.Deduped > 0 {
+		str += fmt.Sprintf(" (%d deduplicated)", s.Deduped)
+	}
+	if s.Skipped > 0 {
+		str += fmt.Sprintf(", %d entries skipped", s.Skipped)
+	}
+	return str
+}
+
+// ExtractOptions controls how the Extract functions write entries to disk.
+type ExtractOptions struct {
+	// Dedup hard-links regular files with identical contents, mode, and ownership
+	// to a single copy. Empty files are never linked, as they are commonly used as
+	// markers or lock files, and existing files are unlinked before being
+	// overwritten so that writing a path never modifies the files it shares an
+	// inode with.
+	Dedup bool
+
+	// GzipReadahead decompresses gzip streams on a goroutine of their own, ahead of
+	// extraction, so that decompressing and writing out files overlap. The
+	// decompression itself is still sequential.
+	GzipReadahead bool
+
+	// ZstdDict is the path of the dictionary which zstd compressed streams were
+	// compressed with, if any.
+	ZstdDict string
+
+	// BestEffort logs entries which fail to extract (e.g. because they are corrupt
+	// or of an unsupported type) as warnings and carries on with the rest, rather
+	// than stopping at the first failure. The failures, each an *ExtractError, are
+	// then returned together in an error wrapping ErrSkippedEntries. Errors reading
+	// the archive itself still stop the extraction.
+	BestEffort bool
+
+	// FS is written to instead of the host filesystem when set.
+	FS ExtractFS
+
+	// IncludePaths limits extraction to the entries at or beneath the given paths
+	// (e.g. "/usr/bin/tool" or "/etc/ssl"), which are relative to the root of the
+	// archive whether or not they start with "/". The directory entries leading to
+	// them are extracted too, and any missing parent directories are created.
+	IncludePaths []string
+
+	// Concurrency is the number of regular files which may be written at once,
+	// each holding an open file; files are written one at a time when it is 0 or
+	// 1. Only files of up to 1 MiB are written concurrently, as their contents are
+	// held in memory until they are written.
+	Concurrency int
+
+	// RecordHeaders, when set, records the headers of the extracted entries; this
+	// is only supported when extracting to the host filesystem. See TarHeaders.
+	RecordHeaders *TarHeaders
+
+	// whiteouts applies whiteout files rather than extracting them; see StreamLayer.
+	whiteouts bool
+}
+
+// chmodBits are the mode bits which Chmod sets.
+const chmodBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+type dedupKey struct {
+	sum      [sha256.Size]byte
+	mode     os.FileMode
+	uid, gid int
+}
+
+func ExtractTarGz(gzipStream io.Reader, dst string) error {
+	_, err := ExtractTarGzWithStats(gzipStream, dst)
+	return err
+}
+
+// ExtractTarGzWithStats behaves like ExtractTarGz, and additionally reports what was extracted.
+func ExtractTarGzWithStats(gzipStream io.Reader, dst string) (ExtractStats, error) {
+	return ExtractTarGzWithOptions(gzipStream, dst, ExtractOptions{})
+}
+
+// ExtractTarGzWithOptions behaves like ExtractTarGzWithStats, with extraction
+// controlled by opts.
+func ExtractTarGzWithOptions(gzipStream io.Reader, dst string, opts ExtractOptions) (ExtractStats, error) {
+	start := time.Now()
+	uncompressedStream, err := newGzipReader(gzipStream, opts.GzipReadahead)
 	if err != nil {
-		return err
+		return ExtractStats{Duration: time.Since(start)}, archiveError(err)
 	}
+	defer uncompressedStream.Close()
+	return extractTar(uncompressedStream, dst, opts, start)
+}
 
-	tarReader := tar.NewReader(uncompressedStream)
+// ExtractArchive extracts a tar stream which may be gzip or zstd compressed, or
+// uncompressed, detecting the compression format from the stream itself.
+func ExtractArchive(r io.Reader, dst string) (ExtractStats, error) {
+	return ExtractArchiveWithOptions(r, dst, ExtractOptions{})
+}
+
+// ExtractArchiveWithOptions behaves like ExtractArchive, with extraction
+// controlled by opts.
+func ExtractArchiveWithOptions(r io.Reader, dst string, opts ExtractOptions) (ExtractStats, error) {
+	start := time.Now()
+	uncompressedStream, err := newDecompressReader(r, opts.GzipReadahead, opts.ZstdDict)
+	if err != nil {
+		return ExtractStats{Duration: time.Since(start)}, err
+	}
+	defer uncompressedStream.Close()
+	return extractTar(uncompressedStream, dst, opts, start)
+}
 
-	hardLinks := make(map[string]string)
+// concurrentFileSize is the largest regular file which is handed to a worker when
+// ExtractOptions.Concurrency is set; its contents are buffered in memory until the
+// worker writes them, so larger files are written directly instead.
+const concurrentFileSize = 1 << 20
 
+func extractTar(uncompressedStream io.Reader, dst string, opts ExtractOptions, start time.Time) (stats ExtractStats, err error) {
+	e := &tarExtractor{
+		dst:        dst,
+		opts:       opts,
+		hardLinks:  make(map[string]string),
+		dedupPaths: make(map[dedupKey]string),
+		dedupKeys:  make(map[string]dedupKey),
+		dirModes:   make(map[string]os.FileMode),
+		written:    make(map[string]bool),
+		dirs:       make(map[string]bool),
+	}
+	for _, p := range opts.IncludePaths {
+		e.includePaths = append(e.includePaths, archivePath(p))
+	}
+	if e.fs = opts.FS; e.fs == nil {
+		e.fs = OSFS{}
+	}
+
+	// regular files are written by up to opts.Concurrency workers; an entry for a
+	// path which a worker is still writing waits for all of them to finish, so that
+	// the archive's later entries still win
+	var wg sync.WaitGroup
+	var workers chan struct{}
+	if opts.Concurrency > 1 {
+		workers = make(chan struct{}, opts.Concurrency)
+	}
+	pending := make(map[string]bool)
+	var workerErr error // guarded by e.mu
+
+	// the returned stats are always those of e, once every worker has finished
+	defer func() {
+		wg.Wait()
+		stats = e.stats
+		stats.Duration = time.Since(start)
+	}()
+
+	// in BestEffort mode, entries which fail to extract are collected here rather
+	// than aborting the extraction; errors reading the stream itself are still fatal
+	var skipped []error
+	entryFailed := func(err error) error {
+		if !opts.BestEffort {
+			return err
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "WARNING: skipping entry: %s\n", err)
+		skipped = append(skipped, err)
+		e.stats.Skipped++
+		return nil
+	}
+	waitForWorkers := func() error {
+		wg.Wait()
+		clear(pending)
+		return workerErr
+	}
+
+	tarReader := tar.NewReader(uncompressedStream)
 	for {
 		header, err := tarReader.Next()
 
 		if err == io.EOF {
+			// the tar reader stops at the end of archive marker; read the padding
+			// after it too, so that a decompressor reaches the end of its stream
+			// and verifies the checksum in its trailer
+			if _, err := io.Copy(io.Discard, uncompressedStream); err != nil {
+				return stats, archiveError(fmt.Errorf("reading past the end of the archive: %w", err))
+			}
 			break
 		}
 
 		if err != nil {
-			return err
+			return stats, archiveError(err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			// PAX global headers (e.g. the commit id written by git archive)
+			// describe the archive as a whole rather than a file
+			continue
 		}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.Mkdir(filepath.Join(dst, header.Name), header.FileInfo().Mode()); err != nil {
-				if !errors.Is(err, os.ErrExist) {
-					return err
+		// entries must stay within dst, as must the targets of hard links; symlinks
+		// may point anywhere, as they are never followed (see securePath)
+		var outside string
+		if !filepath.IsLocal(header.Name) {
+			outside = header.Name
+		} else if header.Typeflag == tar.TypeLink && !filepath.IsLocal(header.Linkname) {
+			outside = header.Linkname
+		}
+		if outside != "" {
+			err := fmt.Errorf("%w: %s is outside of the destination", ErrInvalidArchive, outside)
+			if err := entryFailed(&ExtractError{Entry: header.Name, Err: err}); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		name := archivePath(header.Name)
+		if opts.whiteouts {
+			if target, opaque, ok := whiteoutTarget(header.Name); ok {
+				// the paths being removed may be those the workers are writing
+				if err := waitForWorkers(); err != nil {
+					return stats, err
+				}
+				if err := e.applyWhiteout(target, opaque); err != nil {
+					if err := entryFailed(&ExtractError{Entry: header.Name, Err: err}); err != nil {
+						return stats, err
+					}
 				}
+				continue
 			}
-		case tar.TypeReg:
-			outFile, err := os.OpenFile(filepath.Join(dst, header.Name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode())
+			e.written[name] = true
+		}
+		if opts.RecordHeaders != nil {
+			opts.RecordHeaders.record(name, header)
+		}
+		if pending[name] {
+			if err := waitForWorkers(); err != nil {
+				return stats, err
+			}
+		}
+		if workers != nil && header.Typeflag == tar.TypeReg && header.Size <= concurrentFileSize {
+			data, err := io.ReadAll(tarReader)
 			if err != nil {
-				return err
+				return stats, err
 			}
-			defer func() {
-				err2 := outFile.Close()
-				if err == nil {
-					err = err2
+			workers <- struct{}{}
+			wg.Add(1)
+			pending[name] = true
+			go func(header *tar.Header) {
+				defer wg.Done()
+				defer func() { <-workers }()
+				if err := e.extractEntry(header, bytes.NewReader(data)); err != nil {
+					if err := entryFailed(&ExtractError{Entry: header.Name, Err: err}); err != nil {
+						e.mu.Lock()
+						if workerErr == nil {
+							workerErr = err
+						}
+						e.mu.Unlock()
+					}
 				}
-			}()
-			if _, err := io.Copy(outFile, tarReader); err != nil {
+			}(header)
+			e.mu.Lock()
+			err = workerErr
+			e.mu.Unlock()
+			if err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		if err := e.extractEntry(header, tarReader); err != nil {
+			if err := entryFailed(&ExtractError{Entry: header.Name, Err: err}); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := waitForWorkers(); err != nil {
+		return stats, err
+	}
+	for name, linkname := range e.hardLinks {
+		err := e.link(linkname, name)
+		if err != nil {
+			if err := entryFailed(&ExtractError{Entry: name, Err: err}); err != nil {
+				return stats, err
+			}
+		}
+	}
+	// mkdir applies the umask, and directories which already existed keep their old
+	// mode, so the mode of every directory is set explicitly once all entries have
+	// been written; this is what preserves the sticky bit of directories such as
+	// /tmp (1777)
+	for name, mode := range e.dirModes {
+		if err := e.chmodDir(name, mode); err != nil {
+			if err := entryFailed(&ExtractError{Entry: name, Err: err}); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if opts.RecordHeaders != nil {
+		opts.RecordHeaders.update(dst)
+	}
+	if len(skipped) > 0 {
+		return stats, fmt.Errorf("%w (%d): %w", ErrSkippedEntries, len(skipped), errors.Join(skipped...))
+	}
+	return stats, nil
+}
+
+// tarExtractor holds the state of an extraction which spans multiple entries.
+type tarExtractor struct {
+	dst   string
+	opts  ExtractOptions
+	fs    ExtractFS
+	stats ExtractStats
+
+	// hard links are created once all entries are extracted, as their target may
+	// appear later in the archive; they map archive paths to link targets
+	hardLinks  map[string]string
+	dedupPaths map[dedupKey]string
+	dedupKeys  map[string]dedupKey
+
+	// dirModes holds the mode (including setuid, setgid, and sticky bits) of each
+	// extracted directory by archive path, which are applied once all entries are
+	// extracted
+	dirModes map[string]os.FileMode
+
+	// includePaths are the ExtractOptions.IncludePaths, as archive paths
+	includePaths []string
+
+	// written holds the archive paths of the entries extracted so far, which
+	// whiteouts must not remove, when applying whiteouts
+	written map[string]bool
+
+	// dirs holds the archive paths which securePath has found to be directories
+	// rather than symlinks, so that it need not look at them again
+	dirs map[string]bool
+
+	// mu guards stats and the dedup maps, which are shared with the workers writing
+	// regular files when ExtractOptions.Concurrency is set
+	mu sync.Mutex
+}
+
+// archivePath cleans name, and makes it relative to the root of the archive.
+func archivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// included reports whether the named entry is one of the include paths or beneath
+// one, and whether it is a directory above one, which is extracted so that its
+// mode and ownership are preserved.
+func (e *tarExtractor) included(name string) (included, ancestor bool) {
+	if len(e.includePaths) == 0 {
+		return true, false
+	}
+	name = archivePath(name)
+	for _, p := range e.includePaths {
+		if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+			return true, false
+		}
+		if name == "" || strings.HasPrefix(p, name+"/") {
+			ancestor = true
+		}
+	}
+	return ancestor, ancestor
+}
+
+// securePath returns the path on disk of the named entry, having checked that
+// none of the directories leading to it is a symlink, so that no entry is ever
+// written through a symlink which an earlier entry (or layer) created.
+func (e *tarExtractor) securePath(name string) (string, error) {
+	name = archivePath(name)
+	dir := ""
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = path.Join(dir, part)
+		e.mu.Lock()
+		checked := e.dirs[dir]
+		e.mu.Unlock()
+		if checked {
+			continue
+		}
+		info, err := e.fs.Lstat(filepath.Join(e.dst, dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			// there is nothing beneath dir to follow
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s is beneath the symlink %s", ErrInvalidArchive, name, dir)
+		}
+		if info.IsDir() {
+			e.mu.Lock()
+			e.dirs[dir] = true
+			e.mu.Unlock()
+		}
+	}
+	return filepath.Join(e.dst, name), nil
+}
+
+// forgetDir drops the named directory, and those beneath it, from the ones which
+// securePath has checked, as it is being replaced.
+func (e *tarExtractor) forgetDir(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.dirs[name] {
+		// nothing beneath name can have been checked either
+		return
+	}
+	for dir := range e.dirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(e.dirs, dir)
+		}
+	}
+}
+
+// link creates the hard link name to the archive path linkname.
+func (e *tarExtractor) link(linkname, name string) error {
+	oldname, err := e.securePath(linkname)
+	if err != nil {
+		return err
+	}
+	newname, err := e.securePath(name)
+	if err != nil {
+		return err
+	}
+	return e.fs.Link(oldname, newname)
+}
+
+// chmodDir sets the mode of the named directory, unless a later entry replaced it.
+func (e *tarExtractor) chmodDir(name string, mode os.FileMode) error {
+	path, err := e.securePath(name)
+	if err != nil {
+		return err
+	}
+	info, err := e.fs.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	return e.fs.Chmod(path, mode)
+}
+
+// mkdirParents creates the missing parent directories of the named entry.
+func (e *tarExtractor) mkdirParents(name string) error {
+	dir := e.dst
+	parts := strings.Split(archivePath(name), "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if err := e.fs.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractEntry writes a single tar entry, whose contents are read from r, to disk.
+func (e *tarExtractor) extractEntry(header *tar.Header, r io.Reader) (err error) {
+	included, ancestor := e.included(header.Name)
+	if !included || (ancestor && header.Typeflag != tar.TypeDir) {
+		return nil
+	}
+	path, err := e.securePath(header.Name)
+	if err != nil {
+		return err
+	}
+	if len(e.includePaths) > 0 && !ancestor {
+		// the archive may not contain the directories leading to the entry
+		if err := e.mkdirParents(header.Name); err != nil {
+			return err
+		}
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		mode := header.FileInfo().Mode()
+		if err := e.fs.Mkdir(path, mode); err != nil {
+			if !errors.Is(err, fs.ErrExist) {
 				return err
 			}
-		case tar.TypeLink:
-			hardLinks[filepath.Join(dst, header.Name)] = filepath.Join(dst, header.Linkname)
-		case tar.TypeSymlink:
-			err := os.Symlink(header.Linkname, filepath.Join(dst, header.Name))
+			// an upper layer may replace a file or symlink of a lower one with a
+			// directory, whose mode must not be applied to what a symlink points to
+			info, err := e.fs.Lstat(path)
 			if err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf(
-				"ExtractTarGz: uknown type: %v in %s",
-				header.Typeflag,
-				header.Name)
+			if !info.IsDir() {
+				if err := e.fs.Remove(path); err != nil {
+					return err
+				}
+				if err := e.fs.Mkdir(path, mode); err != nil {
+					return err
+				}
+			}
 		}
-	}
-	for k, v := range hardLinks {
-		if err := os.Link(v, k); err != nil {
+		if err := e.fs.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		e.dirModes[archivePath(header.Name)] = mode & chmodBits
+		e.stats.Dirs++
+	case tar.TypeReg:
+		if !e.opts.Dedup {
+			// Create does not follow symlinks, so one which an upper layer replaces
+			// with a regular file is removed first
+			if info, err := e.fs.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				if err := e.fs.Remove(path); err != nil {
+					return err
+				}
+			}
+		}
+		if e.opts.Dedup {
+			// the lock is held so that no other file is linked to path while it
+			// is being replaced
+			e.mu.Lock()
+			err := e.fs.Remove(path)
+			if err == nil || errors.Is(err, fs.ErrNotExist) {
+				err = nil
+				// the stored copy is being replaced, so it can no longer be linked to
+				if key, ok := e.dedupKeys[path]; ok {
+					delete(e.dedupPaths, key)
+					delete(e.dedupKeys, path)
+				}
+			}
+			e.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		mode := header.FileInfo().Mode()
+		outFile, err := e.fs.Create(path, mode)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err2 := outFile.Close()
+			if err == nil {
+				err = err2
+			}
+		}()
+		if err := e.fs.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		// chown clears the setuid and setgid bits, and the umask applied on
+		// creation may have cleared others, so the mode is set once more
+		if err := e.fs.Chmod(path, mode&chmodBits); err != nil {
+			return err
+		}
+		h := sha256.New()
+		var w io.Writer = outFile
+		if e.opts.Dedup {
+			w = io.MultiWriter(outFile, h)
+		}
+		n, err := io.Copy(w, r)
+		if err != nil {
 			return err
 		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.stats.Files++
+		e.stats.Bytes += n
+		if e.opts.Dedup && n > 0 {
+			key := dedupKey{mode: mode, uid: header.Uid, gid: header.Gid}
+			copy(key.sum[:], h.Sum(nil))
+			if existing, ok := e.dedupPaths[key]; ok {
+				if err := e.fs.Remove(path); err != nil {
+					return err
+				}
+				if err := e.fs.Link(existing, path); err != nil {
+					return err
+				}
+				e.stats.Deduped++
+			} else {
+				e.dedupPaths[key] = path
+				e.dedupKeys[path] = key
+			}
+		}
+	case tar.TypeLink:
+		e.hardLinks[archivePath(header.Name)] = header.Linkname
+		e.mu.Lock()
+		e.stats.Files++
+		e.mu.Unlock()
+	case tar.TypeSymlink:
+		// targets longer than the 100 byte ustar field are stored in a PAX
+		// linkpath record, which archive/tar has already applied to Linkname.
+		// An upper layer may replace a file or symlink of a lower one, or an
+		// empty directory, which must then no longer be treated as one
+		if err := e.fs.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		e.forgetDir(archivePath(header.Name))
+		delete(e.dirModes, archivePath(header.Name))
+		if err := e.fs.Symlink(header.Linkname, path); err != nil {
+			return err
+		}
+		if err := e.fs.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		e.stats.Symlinks++
+	default:
+		return fmt.Errorf("unsupported entry type %q", header.Typeflag)
 	}
 	return nil
 }
 
+// WalkTarGz calls fn for each entry of the gzipped tar stream in archive order,
+// without writing anything to disk. The reader passed to fn yields the entry's
+// contents and is only valid until fn returns. If fn returns fs.SkipAll, the walk
+// stops early and WalkTarGz returns nil.
+func WalkTarGz(gzipStream io.Reader, fn func(header *tar.Header, r io.Reader) error) error {
+	uncompressedStream, err := gzip.NewReader(gzipStream)
+	if err != nil {
+		return archiveError(err)
+	}
+	tarReader := tar.NewReader(uncompressedStream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return archiveError(err)
+		}
+		err = fn(header, tarReader)
+		if err == fs.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// WalkTarGzDigest is WalkTarGz for streams which can only be read once: it also
+// returns the digest of the uncompressed tar, the same sum GetTarSha256String
+// computes, which it calculates as the entries are read. The stream is read to its
+// end, verifying the gzip checksum, even when fn returns fs.SkipAll.
+func WalkTarGzDigest(gzipStream io.Reader, fn func(header *tar.Header, r io.Reader) error) (digest.Digest, error) {
+	gzipReader, err := gzip.NewReader(gzipStream)
+	if err != nil {
+		return "", archiveError(err)
+	}
+	defer gzipReader.Close()
+	digester := digest.SHA256.Digester()
+	uncompressedStream := io.TeeReader(gzipReader, digester.Hash())
+	tarReader := tar.NewReader(uncompressedStream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", archiveError(err)
+		}
+		err = fn(header, tarReader)
+		if err == fs.SkipAll {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	// the digest covers the padding after the end of archive marker too
+	if _, err := io.Copy(io.Discard, uncompressedStream); err != nil {
+		return "", archiveError(err)
+	}
+	return digester.Digest(), nil
+}
+
+// CreateTarGzOptions controls how CreateTarGzWithOptions builds an archive.
+type CreateTarGzOptions struct {
+	// Deterministic strips timestamps and user/group names from the archived
+	// headers so that identical directory trees always produce identical bytes.
+	Deterministic bool
+
+	// Exclude lists gitignore-style patterns for paths which should be left out
+	// of the archive. Patterns starting with "/" are anchored to srcDir, those
+	// starting with "**/" match at any depth, and patterns without a "/" match
+	// a file or directory name anywhere in the tree. Excluding a directory
+	// excludes everything beneath it.
+	Exclude []string
+
+	// GzipName and GzipModTime are recorded in the gzip header when set; they are
+	// always left empty in Deterministic mode.
+	GzipName    string
+	GzipModTime time.Time
+
+	// SourceDateEpoch, when set, clamps the modification times of the archived
+	// files and of the gzip header to it, so that files created after it (e.g. by
+	// the build being archived) are recorded identically by every build. See
+	// https://reproducible-builds.org/specs/source-date-epoch/
+	SourceDateEpoch *time.Time
+
+	// Compression is the format the archive is compressed with: gzip (the default,
+	// when it is empty) or zstd, which uses the zstd command. CompressionNone
+	// writes an uncompressed tar.
+	Compression Compression
+
+	// ZstdDict is the path of a dictionary (see zstd --train) to compress with in
+	// zstd mode. Similar archives compress much better with a dictionary trained
+	// on them, but can then only be decompressed with the same dictionary.
+	ZstdDict string
+
+	// ReplayHeaders, when set, restores the metadata recorded when the files were
+	// extracted to those which have not changed since; see TarHeaders.
+	ReplayHeaders *TarHeaders
+}
+
+// clampTime returns t, or epoch if t is later than it.
+func clampTime(t time.Time, epoch *time.Time) time.Time {
+	if epoch != nil && t.After(*epoch) {
+		return *epoch
+	}
+	return t
+}
+
+func isExcluded(relPath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		var candidates []string
+		switch {
+		case strings.HasPrefix(pattern, "**/"):
+			pattern = strings.TrimPrefix(pattern, "**/")
+			parts := strings.Split(relPath, "/")
+			for i := range parts {
+				candidates = append(candidates, strings.Join(parts[i:], "/"))
+			}
+		case strings.Contains(strings.TrimSuffix(pattern, "/"), "/"):
+			pattern = strings.TrimPrefix(pattern, "/")
+			candidates = []string{relPath}
+		default:
+			candidates = []string{filepath.Base(relPath)}
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+		for _, candidate := range candidates {
+			if ok, _ := filepath.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func CreateTarGz(srcDir string, buf io.Writer) error {
+	return CreateTarGzWithOptions(srcDir, buf, CreateTarGzOptions{})
+}
+
+func CreateTarGzWithOptions(srcDir string, buf io.Writer, opts CreateTarGzOptions) error {
+	_, err := CreateLayer(srcDir, buf, opts)
+	return err
+}
+
+// CreateLayer is CreateTarGzWithOptions, but also returns the digest of the
+// uncompressed tar, i.e. the DiffID of the layer it creates.
+func CreateLayer(srcDir string, buf io.Writer, opts CreateTarGzOptions) (digest.Digest, error) {
+	digester := digest.SHA256.Digester()
+	switch opts.Compression {
+	case CompressionZstd:
+		zw, err := newZstdWriter(buf, opts.ZstdDict)
+		if err != nil {
+			return "", err
+		}
+		if err := writeTar(srcDir, io.MultiWriter(zw, digester.Hash()), opts); err != nil {
+			zw.Close()
+			return "", err
+		}
+		return digester.Digest(), zw.Close()
+	case CompressionNone:
+		if err := writeTar(srcDir, io.MultiWriter(buf, digester.Hash()), opts); err != nil {
+			return "", err
+		}
+		return digester.Digest(), nil
+	case "", CompressionGzip:
+	default:
+		return "", fmt.Errorf("unsupported compression format %q", opts.Compression)
+	}
 	gw := gzip.NewWriter(buf)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	if !opts.Deterministic {
+		gw.Name = opts.GzipName
+		gw.ModTime = opts.GzipModTime
+		if !gw.ModTime.IsZero() {
+			gw.ModTime = clampTime(gw.ModTime, opts.SourceDateEpoch)
+		}
+	}
+	if err := writeTar(srcDir, io.MultiWriter(gw, digester.Hash()), opts); err != nil {
+		gw.Close()
+		return "", err
+	}
+	return digester.Digest(), gw.Close()
+}
+
+// VerifyTarDigest checks that the TarDigest of srcDir is expected, returning an
+// error wrapping ErrDigestMismatch if it is not.
+func VerifyTarDigest(srcDir string, expected digest.Digest) error {
+	actual, err := TarDigest(srcDir)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, expected, actual)
+	}
+	return nil
+}
+
+// TarDigest returns the digest of the uncompressed tar which CreateTarGzWithOptions
+// would create from srcDir in Deterministic mode, without writing it anywhere. It
+// therefore identifies the contents, modes, and ownership of the tree, but not its
+// timestamps.
+func TarDigest(srcDir string) (digest.Digest, error) {
+	digester := digest.SHA256.Digester()
+	if err := writeTar(srcDir, digester.Hash(), CreateTarGzOptions{Deterministic: true}); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// writeTar writes the uncompressed tar of srcDir to w; the gzip options are ignored.
+func writeTar(srcDir string, w io.Writer, opts CreateTarGzOptions) error {
+	tw := tar.NewWriter(w)
 
 	absSrcDir, err := filepath.Abs(srcDir)
 	if err != nil {
 		return err
 	}
 
-	filepath.WalkDir(absSrcDir, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(absSrcDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -94,11 +853,23 @@ func CreateTarGz(srcDir string, buf io.Writer) error {
 		if err != nil {
 			return err
 		}
+		if relPath != "." && isExcluded(filepath.ToSlash(relPath), opts.Exclude) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
 		info, err := d.Info()
 		if err != nil {
 			return err
 		}
 		mode := info.Mode()
+		if mode&(os.ModeSocket|os.ModeIrregular) != 0 {
+			// sockets only exist while bound and irregular files have no portable
+			// representation, so neither can be meaningfully restored from a tar
+			fmt.Fprintf(os.Stderr, "WARNING: skipping %s: unsupported file type %s\n", path, mode.Type())
+			return nil
+		}
 
 		var link string
 		if mode&os.ModeSymlink != 0 {
@@ -113,7 +884,27 @@ func CreateTarGz(srcDir string, buf io.Writer) error {
 		if err != nil {
 			return err
 		}
-		h.Name = relPath
+		h.Name = filepath.ToSlash(relPath)
+		if d.IsDir() {
+			// tar.FileInfoHeader adds the trailing slash to directory names, but
+			// only to the base name, so it must be re-added to the relative path
+			h.Name += "/"
+		}
+		if opts.ReplayHeaders != nil {
+			opts.ReplayHeaders.replay(filepath.ToSlash(relPath), info, h)
+		}
+		if opts.SourceDateEpoch != nil {
+			h.ModTime = clampTime(h.ModTime, opts.SourceDateEpoch)
+			h.AccessTime = time.Time{}
+			h.ChangeTime = time.Time{}
+		}
+		if opts.Deterministic {
+			h.ModTime = time.Unix(0, 0)
+			h.AccessTime = time.Time{}
+			h.ChangeTime = time.Time{}
+			h.Uname = ""
+			h.Gname = ""
+		}
 		err = tw.WriteHeader(h)
 		if err != nil {
 			return err
@@ -131,8 +922,10 @@ func CreateTarGz(srcDir string, buf io.Writer) error {
 		}
 		return nil
 	})
-
-	return nil
+	if err != nil {
+		return err
+	}
+	return tw.Close()
 }
 
 func addFileToArchive(tw *tar.Writer, workingDir, path string) error {
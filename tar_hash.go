@@ -16,12 +16,12 @@ func GetTarSha256String(path string) (string, error) {
 	defer r.Close()
 	uncompressedReader, err := gzip.NewReader(r)
 	if err != nil {
-		return "", err
+		return "", archiveError(err)
 	}
 	defer uncompressedReader.Close()
 	h := sha256.New()
 	if _, err := io.Copy(h, uncompressedReader); err != nil {
-		return "", err
+		return "", archiveError(err)
 	}
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -0,0 +1,148 @@
+package acbrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractTarGzWithStats(t *testing.T) {
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	for _, entry := range []struct {
+		header   tar.Header
+		contents string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "etc/conf.d/", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/hostname", Mode: 0644}, "container\n"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/conf.d/app", Mode: 0644}, "setting=1\n"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/empty", Mode: 0644}, ""},
+		{tar.Header{Typeflag: tar.TypeLink, Name: "etc/hostname.bak", Linkname: "etc/hostname"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC"}, ""},
+	} {
+		entry.header.Size = int64(len(entry.contents))
+		if err := tw.WriteHeader(&entry.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := ExtractTarGzWithStats(&layer, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Duration <= 0 {
+		t.Errorf("expected the duration to be recorded, got %s", stats.Duration)
+	}
+	stats.Duration = 0
+	expected := ExtractStats{Files: 4, Dirs: 2, Symlinks: 1, Bytes: 20}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestCreateTarGzGzipMetadata(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "file"), []byte("contents\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	epoch := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	laterEpoch := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name         string
+		opts         CreateTarGzOptions
+		expectedName string
+		expectedTime time.Time
+	}{
+		{"unset", CreateTarGzOptions{}, "", time.Time{}},
+		{"set", CreateTarGzOptions{GzipName: "out.tar.gz", GzipModTime: modTime}, "out.tar.gz", modTime},
+		{"clamped", CreateTarGzOptions{GzipName: "out.tar.gz", GzipModTime: modTime, SourceDateEpoch: &epoch}, "out.tar.gz", epoch},
+		{"before epoch", CreateTarGzOptions{GzipModTime: modTime, SourceDateEpoch: &laterEpoch}, "", modTime},
+		{"deterministic", CreateTarGzOptions{GzipName: "out.tar.gz", GzipModTime: modTime, Deterministic: true}, "", time.Time{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := CreateTarGzWithOptions(srcDir, &buf, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			gr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gr.Name != tc.expectedName {
+				t.Errorf("expected the gzip name %q, got %q", tc.expectedName, gr.Name)
+			}
+			if !gr.ModTime.Equal(tc.expectedTime) {
+				t.Errorf("expected the gzip mtime %s, got %s", tc.expectedTime, gr.ModTime)
+			}
+		})
+	}
+}
+
+func TestExtractTarGzGlobalHeader(t *testing.T) {
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	for _, entry := range []struct {
+		header   tar.Header
+		contents string
+	}{
+		// as written by git archive, ahead of the files
+		{tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "0123456789abcdef"}}, ""},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/hostname", Mode: 0644}, "container\n"},
+		// one named after a file must not replace the file's recorded header
+		{tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "etc/hostname", PAXRecords: map[string]string{"comment": "again"}}, ""},
+	} {
+		entry.header.Size = int64(len(entry.contents))
+		if err := tw.WriteHeader(&entry.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	headers := NewTarHeaders()
+	stats, err := ExtractTarGzWithOptions(&layer, dst, ExtractOptions{RecordHeaders: headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 1 || stats.Dirs != 1 {
+		t.Errorf("expected 1 file and 1 dir, got %+v", stats)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "pax_global_header")); err == nil {
+		t.Error("expected the global header not to be extracted as a file")
+	}
+	if contents, err := os.ReadFile(filepath.Join(dst, "etc", "hostname")); err != nil || string(contents) != "container\n" {
+		t.Errorf("expected etc/hostname to be extracted, got %q (%v)", contents, err)
+	}
+	if _, ok := headers.entries["pax_global_header"]; ok {
+		t.Error("expected the global header not to be recorded")
+	}
+	if entry, ok := headers.entries["etc/hostname"]; !ok || entry.header.Typeflag != tar.TypeReg {
+		t.Errorf("expected the file's header to be recorded, got %+v", entry)
+	}
+}
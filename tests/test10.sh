@@ -0,0 +1,38 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records that it was started
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/started"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+MISSING="$WORK_DIR/does-not-exist"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --resolv-conf "$MISSING" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a missing bind mount source to be rejected"
+    exit 1
+fi
+if ! grep -qF "$MISSING: does not exist" "$WORK_DIR/stderr"; then
+    echo "error does not name the missing path:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$WORK_DIR/started" ]; then
+    echo "runc was started despite the missing bind mount source"
+    exit 1
+fi
@@ -0,0 +1,22 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+NGINX="$SCRIPTPATH/../sample-images/nginx-1.27.2.tar.gz"
+
+INSPECTION=$("$BINARY" --format json inspect "$NGINX")
+if ! echo "$INSPECTION" | grep -q '"layer_count":7,'; then
+    echo "expected nginx to have 7 layers: $INSPECTION"
+    exit 1
+fi
+if ! echo "$INSPECTION" | grep -q '"repo_tags":\["nginx:1.27.2"\]'; then
+    echo "expected nginx to be tagged nginx:1.27.2: $INSPECTION"
+    exit 1
+fi
+
+"$BINARY" inspect "$NGINX" | grep -q '^LAYERS  *7$'
@@ -0,0 +1,45 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which leaves a unix socket behind in the rootfs, as a daemon would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+python3 -c 'import socket; socket.socket(socket.AF_UNIX).bind("rootfs/a.sock")'
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+
+if ! grep -q "WARNING: skipping .*/a.sock" "$WORK_DIR/stderr"; then
+    echo "expected a warning about the skipped socket:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+mkdir "$WORK_DIR/out"
+tar -xzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+LAYER=$(ls "$WORK_DIR/out"/*.tar.gz)
+tar -tzf "$LAYER" > "$WORK_DIR/entries"
+if grep -q "a.sock" "$WORK_DIR/entries"; then
+    echo "the socket was archived"
+    exit 1
+fi
+# entries which are walked after the socket must still be archived
+if ! grep -q "^usr/bin/" "$WORK_DIR/entries"; then
+    echo "the output layer is incomplete"
+    exit 1
+fi
@@ -0,0 +1,46 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the process argv of the generated config.json, one per line
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print("\\n".join(json.load(open("config.json"))["process"]["args"]))' > "$WORK_DIR/args"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-shell-escape "$ALPINE" "$ALPINE_SHA256" \
+    printf '%s|' "it's" 'two words' '$HOME' '"quoted"' ''
+if [ "$(sed -n 1,2p "$WORK_DIR/args" | tr '\n' ' ')" != "sh -c " ]; then
+    echo "expected the command to be run with sh -c:"
+    cat "$WORK_DIR/args"
+    exit 1
+fi
+# the joined command must reproduce the original arguments when run by a shell
+OUTPUT=$(sh -c "$(sed -n '3,$p' "$WORK_DIR/args")")
+if [ "$OUTPUT" != "it's|two words|\$HOME|\"quoted\"||" ]; then
+    echo "command was not quoted correctly; got: $OUTPUT"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exec "$ALPINE" "$ALPINE_SHA256" \
+    echo "it's" 'two words' '$HOME'
+printf "echo\nit's\ntwo words\n\$HOME\n" > "$WORK_DIR/expected"
+if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/args"; then
+    echo "expected --exec to pass the arguments unchanged:"
+    cat "$WORK_DIR/args"
+    exit 1
+fi
@@ -0,0 +1,49 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+NAME="healthcheck-test-$$"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" "/tmp/acbrun-$NAME" "/tmp/acbrun-$NAME.lock"' EXIT
+
+# stub runtime whose container is always running, and whose healthcheck (the
+# "probe" command) only passes once it has been run $PASS_AFTER times
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+case "\$*" in
+state*)
+    echo '{"status":"running"}'
+    ;;
+*probe*)
+    echo x >> "$WORK_DIR/attempts"
+    [ "\$(wc -l < "$WORK_DIR/attempts")" -ge "\$PASS_AFTER" ]
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PASS_AFTER=3 PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" \
+    --healthcheck probe --wait-healthy 10s --healthcheck-interval 10ms \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(wc -l < "$WORK_DIR/attempts")" -ne 3 ]; then
+    echo "expected 3 healthcheck attempts; got $(wc -l < "$WORK_DIR/attempts")"
+    exit 1
+fi
+
+rm "$WORK_DIR/attempts"
+if PASS_AFTER=1000 PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" \
+    --healthcheck probe --wait-healthy 200ms --healthcheck-interval 10ms \
+    "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --wait-healthy to fail when the healthcheck never passes"
+    exit 1
+fi
@@ -0,0 +1,46 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+if [ "$(id -u)" != "0" ]; then
+    echo "skipping --rootfs-tmpfs test; mounting a tmpfs requires root"
+    exit 0
+fi
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the rootfs mount of the bundle
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+grep " \$PWD/rootfs " /proc/mounts > "$WORK_DIR/mounts"
+echo "\$PWD" > "$WORK_DIR/bundle"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs-tmpfs=256m "$ALPINE" "$ALPINE_SHA256" 'true'
+
+BUNDLE=$(cat "$WORK_DIR/bundle")
+if ! grep -q "^tmpfs $BUNDLE/rootfs tmpfs .*size=262144k" "$WORK_DIR/mounts"; then
+    echo "rootfs was not a 256m tmpfs:"
+    cat "$WORK_DIR/mounts"
+    exit 1
+fi
+if grep -q " $BUNDLE/rootfs " /proc/mounts; then
+    echo "tmpfs on $BUNDLE/rootfs was not unmounted"
+    exit 1
+fi
+if [ -e "$BUNDLE" ]; then
+    echo "working directory $BUNDLE was not removed"
+    exit 1
+fi
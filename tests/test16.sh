@@ -0,0 +1,66 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build an image whose config defines its own PATH
+mkdir -p "$WORK_DIR/layer/bin" "$WORK_DIR/image"
+tar -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+echo '{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/image/bin"]}}' > "$WORK_DIR/image/config.json"
+echo '[{"Config":"config.json","Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which saves the environment of the generated config.json, one per line
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print("\\n".join(json.load(open("config.json"))["process"]["env"]))' > "$WORK_DIR/env"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+expect_env() {
+    if ! grep -qx "$1" "$WORK_DIR/env"; then
+        echo "expected $1 in the container environment:"
+        cat "$WORK_DIR/env"
+        exit 1
+    fi
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --env-default PATH=/default/bin --env-default FOO=bar \
+    "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+expect_env PATH=/image/bin
+expect_env FOO=bar
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --env PATH=/explicit/bin --env-default PATH=/default/bin \
+    "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+expect_env PATH=/explicit/bin
+if [ "$(grep -c '^PATH=' "$WORK_DIR/env")" -ne 1 ]; then
+    echo "expected PATH to be defined exactly once:"
+    cat "$WORK_DIR/env"
+    exit 1
+fi
+
+# the output image's config keeps the same environment, without the variables
+# which only the runtime's template defines
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --env PATH=/explicit/bin --env-default FOO=bar \
+    --output "$WORK_DIR/output.tar.gz" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+mkdir "$WORK_DIR/output"
+tar -xzmf "$WORK_DIR/output.tar.gz" -C "$WORK_DIR/output"
+OUTPUT_ENV=$(python3 -c '
+import json, sys
+output = sys.argv[1]
+config = json.load(open(output + "/manifest.json"))[0]["Config"]
+print(" ".join(json.load(open(output + "/" + config))["config"]["Env"]))
+' "$WORK_DIR/output")
+if [ "$OUTPUT_ENV" != "PATH=/explicit/bin FOO=bar" ]; then
+    echo "expected the output image to keep the environment, got: $OUTPUT_ENV"
+    exit 1
+fi
@@ -0,0 +1,41 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the type and source of the /sys/fs/cgroup mount
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+for m in json.load(open("config.json"))["mounts"]:
+    if m["destination"] == "/sys/fs/cgroup":
+        print(m["type"], m["source"])
+' > "$WORK_DIR/cgroup-mount"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the host's cgroup version is faked with --cgroup-version rather than detected
+for version in 1 2; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cgroup-version $version "$ALPINE" "$ALPINE_SHA256" 'true'
+    case $version in
+    1) expected="cgroup cgroup" ;;
+    2) expected="cgroup2 cgroup2" ;;
+    esac
+    if [ "$(cat "$WORK_DIR/cgroup-mount")" != "$expected" ]; then
+        echo "expected a cgroup v$version host to mount /sys/fs/cgroup as \"$expected\"; got \"$(cat "$WORK_DIR/cgroup-mount")\""
+        exit 1
+    fi
+done
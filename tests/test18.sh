@@ -0,0 +1,50 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+NAME="reentrant-exec-test-$$"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" "/tmp/acbrun-$NAME" "/tmp/acbrun-$NAME.lock"' EXIT
+
+# stub runtime whose container is always running, and which saves the exec'd argv
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+case "\$1" in
+state)
+    echo '{"status":"running"}'
+    ;;
+exec)
+    shift 2
+    printf '%s\n' "\$@" > "$WORK_DIR/args"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" --exec \
+    "$ALPINE" "$ALPINE_SHA256" /bin/echo 'two words' '$HOME'
+printf '/bin/echo\ntwo words\n$HOME\n' > "$WORK_DIR/expected"
+if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/args"; then
+    echo "expected --exec to bypass the shell:"
+    cat "$WORK_DIR/args"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" \
+    "$ALPINE" "$ALPINE_SHA256" 'echo $HOME'
+printf '/bin/sh\n-c\necho $HOME\n' > "$WORK_DIR/expected"
+if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/args"; then
+    echo "expected the command to be run with /bin/sh -c by default:"
+    cat "$WORK_DIR/args"
+    exit 1
+fi
@@ -0,0 +1,43 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build an image containing links whose targets exceed the 100 byte ustar limit, so
+# that they must be recorded in PAX linkpath records
+LONG_DIR="this-directory-name-is-long/and-so-is-this-one-which-pushes-the-path/beyond-one-hundred-bytes"
+mkdir -p "$WORK_DIR/layer/$LONG_DIR" "$WORK_DIR/image"
+echo "target contents" > "$WORK_DIR/layer/$LONG_DIR/target"
+ln -s "/$LONG_DIR/target" "$WORK_DIR/layer/symlink"
+ln "$WORK_DIR/layer/$LONG_DIR/target" "$WORK_DIR/layer/hardlink"
+tar --format=pax -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" "${LONG_DIR%%/*}" symlink hardlink
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the extracted links
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+readlink rootfs/symlink > "$WORK_DIR/symlink-target"
+cat rootfs/hardlink > "$WORK_DIR/hardlink-contents"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+
+if [ "$(cat "$WORK_DIR/symlink-target")" != "/$LONG_DIR/target" ]; then
+    echo "long symlink target was not preserved; got $(cat "$WORK_DIR/symlink-target")"
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR/hardlink-contents")" != "target contents" ]; then
+    echo "long hard link target was not preserved"
+    exit 1
+fi
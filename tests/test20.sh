@@ -0,0 +1,46 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir -p "$WORK_DIR/rootfs/etc"
+echo "prepared" > "$WORK_DIR/rootfs/etc/marker"
+
+# stub runtime which saves the root path of the generated config.json, and adds a
+# file to the rootfs as the command would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.load(open("config.json"))["root"]["path"])' > "$WORK_DIR/root-path"
+echo "created" > "\$(cat "$WORK_DIR/root-path")/etc/created"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --output "$WORK_DIR/out.tar.gz" 'true'
+
+if [ "$(cat "$WORK_DIR/root-path")" != "$WORK_DIR/rootfs" ]; then
+    echo "expected the container root to be $WORK_DIR/rootfs; got $(cat "$WORK_DIR/root-path")"
+    exit 1
+fi
+
+mkdir "$WORK_DIR/out"
+tar -xmzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+tar -tzf "$(ls "$WORK_DIR/out"/*.tar.gz)" > "$WORK_DIR/entries"
+for entry in etc/marker etc/created; do
+    if ! grep -qx "$entry" "$WORK_DIR/entries"; then
+        echo "output image is missing $entry"
+        exit 1
+    fi
+done
+
+if [ ! -f "$WORK_DIR/rootfs/etc/marker" ]; then
+    echo "the prepared rootfs was removed"
+    exit 1
+fi
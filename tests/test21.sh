@@ -0,0 +1,45 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+BEFORE=$(date -u +%s)
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" --commit-message "install things" \
+    "$ALPINE" "$ALPINE_SHA256" 'apk add things'
+AFTER=$(date -u +%s)
+
+mkdir "$WORK_DIR/out"
+tar -xmzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+python3 - "$WORK_DIR/out" "$BEFORE" "$AFTER" <<'CHECK'
+import datetime, json, os, sys
+out, before, after = sys.argv[1], int(sys.argv[2]), int(sys.argv[3])
+manifest = json.load(open(os.path.join(out, "manifest.json")))[0]
+config = json.load(open(os.path.join(out, manifest["Config"])))
+
+def timestamp(s):
+    return datetime.datetime.fromisoformat(s.replace("Z", "+00:00")).timestamp()
+
+created = timestamp(config["created"])
+assert before <= created <= after + 1, "created %s is not the time of the run" % config["created"]
+assert len(config["history"]) == 1, config["history"]
+history = config["history"][0]
+assert history["created"] == config["created"], history
+assert history["created_by"] == "apk add things", history
+assert history["comment"] == "install things", history
+CHECK
@@ -0,0 +1,45 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which creates a file in the rootfs, as the command would; its
+# modification time differs between runs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo hello > rootfs/root/data
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the outputs share a file name, as it is recorded by --output-gzip-metadata
+PATH="$WORK_DIR/bin:$PATH" SOURCE_DATE_EPOCH=1700000000 "$BINARY" --output-gzip-metadata \
+    --output "$WORK_DIR/a/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'echo hello > /root/data'
+sleep 1
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --source-date-epoch 1700000000 --output-gzip-metadata \
+    --output "$WORK_DIR/b/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'echo hello > /root/data'
+
+A=$(sha256sum "$WORK_DIR/a/out.tar.gz" | cut -d ' ' -f 1)
+B=$(sha256sum "$WORK_DIR/b/out.tar.gz" | cut -d ' ' -f 1)
+if [ "$A" != "$B" ]; then
+    echo "outputs with the same source date epoch differ: $A != $B"
+    exit 1
+fi
+
+mkdir "$WORK_DIR/out"
+tar -xmzf "$WORK_DIR/a/out.tar.gz" -C "$WORK_DIR/out"
+if ! grep -q '"created":"2023-11-14T22:13:20Z"' "$WORK_DIR/out"/sha256:*; then
+    echo "output config was not created at the source date epoch"
+    exit 1
+fi
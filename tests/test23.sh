@@ -0,0 +1,73 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir -p "$WORK_DIR/src/dir/sub"
+echo "script" > "$WORK_DIR/src/run.sh"
+chmod 0750 "$WORK_DIR/src/run.sh"
+echo "nested" > "$WORK_DIR/src/dir/sub/file"
+chmod 0600 "$WORK_DIR/src/dir/sub/file"
+
+# stub runtime which records the copied files as seen in the rootfs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+stat -c '%n %a' rootfs/usr/local/bin/run.sh rootfs/opt/dir/sub/file > "$WORK_DIR/copied"
+cat rootfs/opt/dir/sub/file >> "$WORK_DIR/copied"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" \
+    --copy "$WORK_DIR/src/run.sh:/usr/local/bin/" \
+    --copy "$WORK_DIR/src/dir:/opt/dir" \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+
+printf 'rootfs/usr/local/bin/run.sh 750\nrootfs/opt/dir/sub/file 600\nnested\n' > "$WORK_DIR/expected"
+if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/copied"; then
+    echo "files were not copied into the rootfs with their modes:"
+    cat "$WORK_DIR/copied"
+    exit 1
+fi
+
+# symlinks in the destination are resolved within the rootfs, as they would be in
+# the container, rather than on the host; a symlink at the destination itself is
+# replaced rather than written through
+OUTSIDE="$WORK_DIR/outside"
+mkdir "$OUTSIDE"
+echo "original" > "$OUTSIDE/target"
+mkdir -p "$WORK_DIR/rootfs/etc" "$WORK_DIR/rootfs/run"
+ln -s /run "$WORK_DIR/rootfs/var-run"
+ln -s "$OUTSIDE" "$WORK_DIR/rootfs/escape"
+ln -s ../../../../../../../.. "$WORK_DIR/rootfs/up"
+ln -s "$OUTSIDE/target" "$WORK_DIR/rootfs/etc/target"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" \
+    --copy "$WORK_DIR/src/run.sh:/var-run/run.sh" \
+    --copy "$WORK_DIR/src/run.sh:/escape/pwned" \
+    --copy "$WORK_DIR/src/run.sh:/up$OUTSIDE/up" \
+    --copy "$WORK_DIR/src/run.sh:/etc/target" \
+    --copy "$WORK_DIR/src/dir:/escape/dir" \
+    'true'
+if [ "$(cat "$OUTSIDE/target")" != "original" ] || [ "$(ls "$OUTSIDE")" != "target" ]; then
+    echo "expected nothing to be copied outside of the rootfs:"
+    ls -l "$OUTSIDE"
+    exit 1
+fi
+for copied in run/run.sh "$OUTSIDE/pwned" "$OUTSIDE/up" etc/target "$OUTSIDE/dir/sub/file"; do
+    if [ ! -f "$WORK_DIR/rootfs/$copied" ] || [ -L "$WORK_DIR/rootfs/$copied" ]; then
+        echo "expected $copied to be copied into the rootfs"
+        exit 1
+    fi
+done
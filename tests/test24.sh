@@ -0,0 +1,84 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+DB="compose-db-$$"
+APP="compose-app-$$"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" /tmp/acbrun-$DB /tmp/acbrun-$DB.lock /tmp/acbrun-$APP /tmp/acbrun-$APP.lock' EXIT
+
+cat > "$WORK_DIR/spec.json" <<SPEC
+{
+  "containers": [
+    {"name": "$DB", "image": "$SCRIPTPATH/../sample-images/alpine-3.20.3.tar.gz", "sha256": "$ALPINE_SHA256", "command": "start-db"},
+    {"name": "$APP", "image": "$SCRIPTPATH/../sample-images/alpine-3.20.3.tar.gz", "sha256": "$ALPINE_SHA256",
+     "mounts": [{"source": "$WORK_DIR", "destination": "/work", "readonly": true},
+                {"source": "$WORK_DIR/state", "destination": "/work/state"}]}
+  ]
+}
+SPEC
+
+# stub runtime which tracks which containers it has started; the pid of each
+# container is recorded as 1000 plus its start order
+mkdir "$WORK_DIR/bin" "$WORK_DIR/state"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+[ "\$1" = "--log" ] && shift 2
+case "\$1" in
+state)
+    if [ ! -f "$WORK_DIR/state/\$2" ]; then
+        echo 'container "\$2" does not exist: "container does not exist"' >&2
+        exit 1
+    fi
+    echo "{\"status\":\"running\",\"pid\":\$(cat "$WORK_DIR/state/\$2")}"
+    ;;
+run)
+    echo "\$3" >> "$WORK_DIR/order"
+    echo \$((1000 + \$(wc -l < "$WORK_DIR/order"))) > "$WORK_DIR/state/\$3"
+    cp config.json "$WORK_DIR/\$3.json"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# each container is given only its own mounts
+ACBRUN_COMPOSE_MOUNTS='[{"source": "/", "destination": "/host"}]' PATH="$WORK_DIR/bin:$PATH" "$BINARY" --compose "$WORK_DIR/spec.json"
+
+if [ "$(cat "$WORK_DIR/order" | tr '\n' ' ')" != "$DB $APP " ]; then
+    echo "containers were not started in order: $(cat "$WORK_DIR/order")"
+    exit 1
+fi
+
+python3 - "$WORK_DIR/$DB.json" "$WORK_DIR/$APP.json" "$WORK_DIR" <<'CHECK'
+import json, sys
+db, app = json.load(open(sys.argv[1])), json.load(open(sys.argv[2]))
+network = lambda config: [ns for ns in config["linux"]["namespaces"] if ns["type"] == "network"]
+assert network(db) == [{"type": "network"}], network(db)
+assert network(app) == [{"type": "network", "path": "/proc/1001/ns/net"}], network(app)
+mounts = [m for m in app["mounts"] if m["destination"].startswith("/work")]
+assert [m["destination"] for m in mounts] == ["/work", "/work/state"], mounts
+assert mounts[0]["source"] == sys.argv[3] and "ro" in mounts[0]["options"], mounts
+assert mounts[1]["source"] == sys.argv[3] + "/state" and "ro" not in mounts[1]["options"], mounts
+assert not [m for m in db["mounts"] if m["destination"].startswith("/work")], db["mounts"]
+assert not [m for m in db["mounts"] + app["mounts"] if m["destination"] == "/host"], "mounts were inherited from acbrun's environment"
+CHECK
+
+# mount sources are validated before any container is started
+rm "$WORK_DIR/order"
+sed "s|\"$WORK_DIR/state\"|\"$WORK_DIR/missing\"|" "$WORK_DIR/spec.json" > "$WORK_DIR/invalid.json"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --compose "$WORK_DIR/invalid.json" 2>"$WORK_DIR/stderr"; then
+    echo "expected a mount of a missing source to be rejected"
+    exit 1
+fi
+if ! grep -q "bind mount source $WORK_DIR/missing: does not exist" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/order" ]; then
+    echo "expected the missing source to be reported before any container started, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
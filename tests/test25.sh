@@ -0,0 +1,45 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+if [ "$(id -u)" != "0" ]; then
+    echo "skipping noexec test; mounting a tmpfs requires root"
+    exit 0
+fi
+
+WORK_DIR=$(mktemp -d)
+mkdir "$WORK_DIR/noexec"
+mount -t tmpfs -o noexec tmpfs "$WORK_DIR/noexec"
+trap 'umount "$WORK_DIR/noexec"; rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the bundle directory
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+echo "\$PWD" > "$WORK_DIR/bundle"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" TMPDIR="$WORK_DIR/noexec" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+
+if ! grep -q "WARNING: $WORK_DIR/noexec is mounted noexec; using /var/tmp" "$WORK_DIR/stderr"; then
+    echo "expected a warning about the noexec mount:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+case "$(cat "$WORK_DIR/bundle")" in
+/var/tmp/acbrun-*) ;;
+*)
+    echo "expected the working directory to be relocated to /var/tmp; got $(cat "$WORK_DIR/bundle")"
+    exit 1
+    ;;
+esac
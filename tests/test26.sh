@@ -0,0 +1,46 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records that it was started
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/started"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+WRONG_DIGEST="sha256:0000000000000000000000000000000000000000000000000000000000000000"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --expect-rootfs-digest "$WRONG_DIGEST" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a mismatching rootfs digest to fail"
+    exit 1
+fi
+if [ -e "$WORK_DIR/started" ]; then
+    echo "runc was started despite the rootfs digest mismatch"
+    exit 1
+fi
+ACTUAL_DIGEST=$(sed -n 's/^error: rootfs digest mismatch: expected .*, got \(sha256:[0-9a-f]*\)$/\1/p' "$WORK_DIR/stderr")
+if [ -z "$ACTUAL_DIGEST" ]; then
+    echo "expected the mismatch to report the actual digest:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# the digest of a deterministic archive is the same for every extraction
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --expect-rootfs-digest "$ACTUAL_DIGEST" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ ! -e "$WORK_DIR/started" ]; then
+    echo "runc was not started despite the rootfs digest matching"
+    exit 1
+fi
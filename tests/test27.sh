@@ -0,0 +1,50 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image where the middle entry can't be extracted, as its
+# parent directory is not part of the layer
+mkdir -p "$WORK_DIR/layer/missing" "$WORK_DIR/image"
+echo "good" > "$WORK_DIR/layer/a"
+echo "bad" > "$WORK_DIR/layer/missing/bad"
+echo "good" > "$WORK_DIR/layer/b"
+tar --no-recursion -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" ./a ./missing/bad ./b
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records which files were extracted
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+ls rootfs > "$WORK_DIR/files"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2>/dev/null; then
+    echo "extraction should fail without --best-effort"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --best-effort "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+
+if [ "$(cat "$WORK_DIR/files" | tr '\n' ' ')" != "a b " ]; then
+    echo "expected a and b to be extracted; got: $(cat "$WORK_DIR/files")"
+    exit 1
+fi
+if ! grep -q "WARNING: skipping entry: .*missing/bad" "$WORK_DIR/stderr"; then
+    echo "expected a warning about the skipped entry; got: $(cat "$WORK_DIR/stderr")"
+    exit 1
+fi
+if ! grep -q "WARNING: 1 entries of layer.tar.gz could not be extracted" "$WORK_DIR/stderr"; then
+    echo "expected a summary of the skipped entries; got: $(cat "$WORK_DIR/stderr")"
+    exit 1
+fi
@@ -0,0 +1,44 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the process scheduler settings from config.json
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+scheduler = json.load(open("config.json"))["process"].get("scheduler", {})
+print(scheduler.get("policy"), scheduler.get("nice"))
+' > "$WORK_DIR/scheduler"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --nice 10 "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/scheduler")" != "SCHED_OTHER 10" ]; then
+    echo "expected the scheduler to be \"SCHED_OTHER 10\"; got \"$(cat "$WORK_DIR/scheduler")\""
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/scheduler")" != "None None" ]; then
+    echo "expected no scheduler without --nice; got \"$(cat "$WORK_DIR/scheduler")\""
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --nice 20 "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --nice 20 to be rejected"
+    exit 1
+fi
@@ -0,0 +1,35 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image with a world-writable sticky directory
+mkdir -p "$WORK_DIR/layer/tmp" "$WORK_DIR/image"
+chmod 1777 "$WORK_DIR/layer/tmp"
+tar -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the mode of the extracted directory
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+stat -c %a rootfs/tmp > "$WORK_DIR/mode"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the umask would otherwise clear the group and other write bits
+(umask 022 && PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true')
+
+if [ "$(cat "$WORK_DIR/mode")" != "1777" ]; then
+    echo "expected /tmp to have mode 1777; got $(cat "$WORK_DIR/mode")"
+    exit 1
+fi
@@ -0,0 +1,95 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+OUTPUT_DIR=$(mktemp -d)
+trap 'rm -rf "$OUTPUT_DIR"' EXIT
+
+# mkimage.py <path> writes a three-layer image to path, whose later layers remove
+# and replace files of the earlier ones, and prints its sha256 sum
+cat > "$OUTPUT_DIR/mkimage.py" <<'PY'
+import gzip, hashlib, io, json, sys, tarfile
+
+def build_tar(files):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w") as tf:
+        for name, data in files:
+            info = tarfile.TarInfo(name)
+            info.mtime = 1600000000
+            if data is None:
+                info.type, info.mode = tarfile.DIRTYPE, 0o755
+            else:
+                info.size = len(data)
+            tf.addfile(info, io.BytesIO(data) if data is not None else None)
+    return buf.getvalue()
+
+layers = [
+    build_tar([("etc", None), ("etc/removed", b"removed\n"), ("etc/replaced", b"old\n"), ("root", None),
+               ("usr", None), ("usr/share", None), ("usr/share/old", b"old\n")]),
+    # a whiteout removes a single file, and an opaque whiteout the rest of a directory
+    build_tar([("etc", None), ("etc/.wh.removed", b""), ("etc/added", b"added\n"),
+               ("usr", None), ("usr/share", None), ("usr/share/.wh..wh..opq", b""), ("usr/share/new", b"new\n")]),
+    build_tar([("etc", None), ("etc/replaced", b"new\n")]),
+]
+config = {"os": "linux", "architecture": "amd64",
+          "rootfs": {"type": "layers", "diff_ids": ["sha256:" + hashlib.sha256(l).hexdigest() for l in layers]}}
+names = ["%d/layer.tar.gz" % i for i in range(len(layers))]
+image = build_tar(
+    [("manifest.json", json.dumps([{"Config": "config.json", "Layers": names}]).encode()),
+     ("config.json", json.dumps(config).encode())]
+    + [(name, gzip.compress(layer, mtime=0)) for name, layer in zip(names, layers)]
+)
+with open(sys.argv[1], "wb") as f:
+    f.write(gzip.compress(image, mtime=0))
+print(hashlib.sha256(image).hexdigest())
+PY
+IMAGE_SHA256=$(python3 "$OUTPUT_DIR/mkimage.py" "$OUTPUT_DIR/image.tar.gz")
+
+# stub runtime which creates a file in the rootfs, as the command would; its
+# modification time differs between runs
+mkdir "$OUTPUT_DIR/bin"
+cat > "$OUTPUT_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo hello > rootfs/root/data
+STUB
+chmod +x "$OUTPUT_DIR/bin/runc"
+
+PATH="$OUTPUT_DIR/bin:$PATH" "$BINARY" --squash --output "$OUTPUT_DIR/a.tar.gz" "$OUTPUT_DIR/image.tar.gz" "$IMAGE_SHA256" 'echo hello > /root/data'
+sleep 1
+PATH="$OUTPUT_DIR/bin:$PATH" "$BINARY" --squash --output "$OUTPUT_DIR/b.tar.gz" "$OUTPUT_DIR/image.tar.gz" "$IMAGE_SHA256" 'echo hello > /root/data'
+
+A=$(sha256sum "$OUTPUT_DIR/a.tar.gz" | cut -d ' ' -f 1)
+B=$(sha256sum "$OUTPUT_DIR/b.tar.gz" | cut -d ' ' -f 1)
+if [ "$A" != "$B" ]; then
+    echo "squashed outputs differ: $A != $B"
+    exit 1
+fi
+
+# the output is a single layer holding the image's files as the container saw
+# them, without the whiteouts which removed the others
+if ! python3 - "$OUTPUT_DIR/a.tar.gz" <<'PY'
+import io, json, sys, tarfile
+with tarfile.open(sys.argv[1]) as image:
+    manifest = json.load(image.extractfile("manifest.json"))
+    assert len(manifest) == 1 and len(manifest[0]["Layers"]) == 1, manifest
+    config = json.load(image.extractfile(manifest[0]["Config"]))
+    assert len(config["rootfs"]["diff_ids"]) == 1, config["rootfs"]
+    layer = tarfile.open(fileobj=io.BytesIO(image.extractfile(manifest[0]["Layers"][0]).read()))
+    files = {m.name.lstrip("./"): layer.extractfile(m).read() for m in layer.getmembers() if m.isfile()}
+expected = {
+    "etc/added": b"added\n",
+    "etc/replaced": b"new\n",
+    "usr/share/new": b"new\n",
+    "root/data": b"hello\n",
+}
+assert files == expected, files
+PY
+then
+    echo "expected the squashed layer to hold the image's files after its whiteouts"
+    exit 1
+fi
@@ -0,0 +1,49 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+if [ "$(id -u)" != "0" ]; then
+    echo "skipping: ownership is only restored when running as root"
+    exit 0
+fi
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image whose entries are owned by a user which does not
+# exist on the host, including a setuid file
+mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+echo "contents" > "$WORK_DIR/layer/data/file"
+chmod 4755 "$WORK_DIR/layer/data/file"
+ln -s file "$WORK_DIR/layer/data/link"
+tar --owner=1234 --group=5678 --numeric-owner -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the ownership and mode of the extracted entries
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+stat -c '%n %u:%g %a' rootfs/data rootfs/data/file > "$WORK_DIR/owners"
+stat -c '%n %u:%g' rootfs/data/link >> "$WORK_DIR/owners"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+
+expected="rootfs/data 1234:5678 755
+rootfs/data/file 1234:5678 4755
+rootfs/data/link 1234:5678"
+if [ "$(cat "$WORK_DIR/owners")" != "$expected" ]; then
+    echo "expected:"
+    echo "$expected"
+    echo "got:"
+    cat "$WORK_DIR/owners"
+    exit 1
+fi
@@ -0,0 +1,44 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+# the nginx image has Entrypoint ["/docker-entrypoint.sh"] and Cmd ["nginx", "-g", "daemon off;"]
+NGINX="$SCRIPTPATH/../sample-images/nginx-1.27.2.tar.gz"
+NGINX_SHA256="2322bd348454db6e1feaacb2692475426162ad662b4cb02709d9de778b6c0d00"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the process argv of the generated config.json, separated by |
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print("|".join(json.load(open("config.json"))["process"]["args"]))' > "$WORK_DIR/args"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+check_args() {
+    if [ "$(cat "$WORK_DIR/args")" != "$1" ]; then
+        echo "$2: expected args \"$1\"; got \"$(cat "$WORK_DIR/args")\""
+        exit 1
+    fi
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$NGINX" "$NGINX_SHA256" 'nginx -v'
+check_args "sh|-c|nginx -v" "by default"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-from-image "$NGINX" "$NGINX_SHA256" -- nginx -v
+check_args "/docker-entrypoint.sh|nginx|-v" "with --entrypoint-from-image and arguments"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-from-image "$NGINX" "$NGINX_SHA256"
+check_args "/docker-entrypoint.sh|nginx|-g|daemon off;" "with --entrypoint-from-image and no arguments"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-from-image --exec "$NGINX" "$NGINX_SHA256" nginx 2>/dev/null; then
+    echo "expected --entrypoint-from-image to be rejected with --exec"
+    exit 1
+fi
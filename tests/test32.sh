@@ -0,0 +1,42 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the poststart hooks of the generated config.json
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+for hook in json.load(open("config.json")).get("hooks", {}).get("poststart", []):
+    print(hook["path"], "|".join(hook["args"]))
+' > "$WORK_DIR/hooks"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --poststart-hook "/bin/echo ready" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/hooks")" != "/bin/echo /bin/echo|ready" ]; then
+    echo "expected a poststart hook running /bin/echo ready; got: $(cat "$WORK_DIR/hooks")"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --poststart-hook "echo ready" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected a hook without an absolute path to be rejected"
+    exit 1
+fi
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --poststart-hook "$WORK_DIR/missing" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected a hook which does not exist to be rejected"
+    exit 1
+fi
@@ -0,0 +1,47 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a two layer image where the upper layer replaces the tool
+mkdir -p "$WORK_DIR/layer1/usr/bin" "$WORK_DIR/layer1/etc" "$WORK_DIR/layer2/usr/bin" "$WORK_DIR/image"
+echo "old tool" > "$WORK_DIR/layer1/usr/bin/tool"
+echo "other" > "$WORK_DIR/layer1/usr/bin/other"
+echo "config" > "$WORK_DIR/layer1/etc/config"
+echo "new tool" > "$WORK_DIR/layer2/usr/bin/tool"
+tar -czf "$WORK_DIR/image/layer1.tar.gz" -C "$WORK_DIR/layer1" .
+tar -czf "$WORK_DIR/image/layer2.tar.gz" -C "$WORK_DIR/layer2" .
+echo '[{"Layers":["layer1.tar.gz","layer2.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer1.tar.gz layer2.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# runc must not be run
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/runc-was-run"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --extract-only /usr/bin/tool --extract-dir "$WORK_DIR/out" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256"
+
+if [ -e "$WORK_DIR/runc-was-run" ]; then
+    echo "--extract-only should not run a container"
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR/out/usr/bin/tool")" != "new tool" ]; then
+    echo "expected the upper layer's tool to be extracted; got: $(cat "$WORK_DIR/out/usr/bin/tool")"
+    exit 1
+fi
+EXTRACTED=$(cd "$WORK_DIR/out" && find . | sort | tr '\n' ' ')
+if [ "$EXTRACTED" != ". ./usr ./usr/bin ./usr/bin/tool " ]; then
+    echo "expected only the tool and its parent directories to be extracted; got: $EXTRACTED"
+    exit 1
+fi
@@ -0,0 +1,44 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+mkdir "$WORK_DIR/empty"
+
+# a missing runc is reported as an error, rather than a panic
+if PATH="$WORK_DIR/empty" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected acbrun to fail without runc"
+    exit 1
+fi
+if ! grep -q "^error: runc was not found" "$WORK_DIR/stderr" || grep -q "panic" "$WORK_DIR/stderr"; then
+    echo "expected a runc was not found error; got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# entries which fail to extract are named in the error
+mkdir -p "$WORK_DIR/layer/missing" "$WORK_DIR/image"
+echo "bad" > "$WORK_DIR/layer/missing/bad"
+tar --no-recursion -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" ./missing/bad
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+if "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected extraction to fail"
+    exit 1
+fi
+if ! grep -q "extracting ./missing/bad: " "$WORK_DIR/stderr"; then
+    echo "expected the error to name the entry; got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
@@ -0,0 +1,53 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+NGINX="$SCRIPTPATH/../sample-images/nginx-1.27.2.tar.gz"
+NGINX_SHA256="2322bd348454db6e1feaacb2692475426162ad662b4cb02709d9de778b6c0d00"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which lists the type, mode, ownership, and contents of every path of
+# the rootfs to the file named by the LISTING environment variable
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+cd rootfs
+find . | sort | while read -r path; do
+    if [ -f "$path" ] && [ ! -L "$path" ]; then
+        echo "$(stat -c '%F %a %u:%g' "$path") $(sha256sum < "$path" | cut -d ' ' -f 1) $path"
+    elif [ -L "$path" ]; then
+        echo "symlink $(readlink "$path") $path"
+    else
+        echo "$(stat -c '%F %a %u:%g' "$path") $path"
+    fi
+done > "$LISTING"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+LISTING="$WORK_DIR/sequential" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --extract-concurrency 1 "$NGINX" "$NGINX_SHA256" 'true'
+LISTING="$WORK_DIR/concurrent" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --extract-concurrency 8 "$NGINX" "$NGINX_SHA256" 'true'
+LISTING="$WORK_DIR/dedup" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --dedup --extract-concurrency 8 "$NGINX" "$NGINX_SHA256" 'true'
+
+if [ "$(wc -l < "$WORK_DIR/sequential")" -lt 1000 ]; then
+    echo "expected the rootfs to be listed"
+    exit 1
+fi
+for mode in concurrent dedup; do
+    if ! cmp -s "$WORK_DIR/sequential" "$WORK_DIR/$mode"; then
+        echo "$mode extraction differs from sequential extraction:"
+        diff "$WORK_DIR/sequential" "$WORK_DIR/$mode" | head -20
+        exit 1
+    fi
+done
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --extract-concurrency -1 "$NGINX" "$NGINX_SHA256" 'true' 2>/dev/null; then
+    echo "expected a negative --extract-concurrency to be rejected"
+    exit 1
+fi
@@ -0,0 +1,59 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the type and destination of the mounts added to the template
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+for m in json.load(open("config.json"))["mounts"]:
+    if m["destination"].startswith("/data") or m["destination"] in ("/local-dir", "/scratch"):
+        print(m["type"], m["destination"])
+' > "$WORK_DIR/mounts"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+mkdir "$WORK_DIR/data"
+cat > "$WORK_DIR/mounts.json" <<EOF2
+[
+    {"destination": "/data", "type": "bind", "source": "$WORK_DIR/data", "options": ["rbind", "ro"]},
+    {"destination": "/scratch", "type": "tmpfs", "source": "tmpfs", "options": ["size=64m"]}
+]
+EOF2
+
+cd "$WORK_DIR"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --bind-local-dir --mounts-file "$WORK_DIR/mounts.json" "$ALPINE" "$ALPINE_SHA256" 'true'
+expected="bind /local-dir
+bind /data
+tmpfs /scratch"
+if [ "$(cat "$WORK_DIR/mounts")" != "$expected" ]; then
+    echo "expected mounts:"
+    echo "$expected"
+    echo "got:"
+    cat "$WORK_DIR/mounts"
+    exit 1
+fi
+
+echo '[{"destination": "/data", "source": "/tmp"}]' > "$WORK_DIR/invalid.json"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mounts-file "$WORK_DIR/invalid.json" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a mount without a type to be rejected"
+    exit 1
+fi
+if ! grep -q "mount 0 requires a type" "$WORK_DIR/stderr"; then
+    echo "unexpected error: $(cat "$WORK_DIR/stderr")"
+    exit 1
+fi
@@ -0,0 +1,47 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+LABEL="system_u:system_r:container_t:s0:c1,c2"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the process and mount labels of the generated config.json
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+config = json.load(open("config.json"))
+print(config["process"].get("selinuxLabel"), config["linux"].get("mountLabel"))
+' > "$WORK_DIR/labels"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --selinux-label "$LABEL" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/labels")" != "$LABEL $LABEL" ]; then
+    echo "expected the process and mount labels to be $LABEL; got: $(cat "$WORK_DIR/labels")"
+    exit 1
+fi
+
+# --selinux=disabled omits labels, even those set by an override
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --selinux=disabled --config-override "process.selinuxLabel=\"$LABEL\"" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/labels")" != "None None" ]; then
+    echo "expected no labels with --selinux=disabled; got: $(cat "$WORK_DIR/labels")"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --selinux-label "container_t" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected an invalid label to be rejected"
+    exit 1
+fi
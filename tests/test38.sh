@@ -0,0 +1,53 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# the lower layer holds files which the upper layer's whiteouts delete
+mkdir -p "$WORK_DIR/layer1/opaque" "$WORK_DIR/layer2/opaque"
+echo "kept" > "$WORK_DIR/layer1/kept"
+echo "deleted" > "$WORK_DIR/layer1/deleted"
+echo "hidden" > "$WORK_DIR/layer1/opaque/hidden"
+touch "$WORK_DIR/layer2/.wh.deleted" "$WORK_DIR/layer2/opaque/.wh..wh..opq"
+echo "new" > "$WORK_DIR/layer2/opaque/new"
+
+# stub runtime which lists the rootfs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+(cd rootfs && find . | sort | tr '\n' ' ') > "$WORK_DIR/files"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the upper layer is extracted both gzip compressed and uncompressed
+for layer2 in layer2.tar.gz layer2.tar; do
+    rm -rf "$WORK_DIR/image" && mkdir "$WORK_DIR/image"
+    tar -czf "$WORK_DIR/image/layer1.tar.gz" -C "$WORK_DIR/layer1" .
+    case $layer2 in
+    *.gz) tar -czf "$WORK_DIR/image/$layer2" -C "$WORK_DIR/layer2" . ;;
+    *) tar -cf "$WORK_DIR/image/$layer2" -C "$WORK_DIR/layer2" . ;;
+    esac
+    echo "[{\"Layers\":[\"layer1.tar.gz\",\"$layer2\"]}]" > "$WORK_DIR/image/manifest.json"
+    tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer1.tar.gz "$layer2"
+    IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --verbose "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+
+    if [ "$(cat "$WORK_DIR/files")" != ". ./kept ./opaque ./opaque/new " ]; then
+        echo "$layer2: whiteouts were not applied; got: $(cat "$WORK_DIR/files")"
+        exit 1
+    fi
+    DIFF_ID="sha256:$(gzip -dcf < "$WORK_DIR/image/$layer2" | sha256sum | cut -d ' ' -f 1)"
+    if ! grep -q "^extracted $layer2 ($DIFF_ID)" "$WORK_DIR/stderr"; then
+        echo "$layer2: expected its diff id $DIFF_ID to be reported; got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
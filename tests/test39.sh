@@ -0,0 +1,154 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$(readlink -f "$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz")"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+SERVER_PID=
+trap 'if [ -n "$SERVER_PID" ]; then kill $SERVER_PID; fi; rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which runs the container's process on the host
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+exec python3 -c '
+import json, subprocess, sys
+sys.exit(subprocess.call(json.load(open("config.json"))["process"]["args"]))
+'
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+SOCKET="$WORK_DIR/acbrun.sock"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" serve "$SOCKET" &
+SERVER_PID=$!
+for i in $(seq 50); do
+    if [ -S "$SOCKET" ]; then
+        break
+    fi
+    sleep 0.1
+done
+if [ "$(stat -c %a "$SOCKET")" != "600" ]; then
+    echo "expected only the socket's owner to be able to connect, got mode $(stat -c %a "$SOCKET")"
+    exit 1
+fi
+
+python3 - "$SOCKET" "$ALPINE" "$ALPINE_SHA256" > "$WORK_DIR/result" <<'CLIENT'
+import base64, json, socket, sys
+sock = socket.socket(socket.AF_UNIX)
+sock.connect(sys.argv[1])
+request = {"image": sys.argv[2], "sha256": sys.argv[3], "command": "echo hello; echo oops >&2"}
+sock.sendall(json.dumps(request).encode() + b"\n")
+output = {"stdout": b"", "stderr": b""}
+exit_code = None
+for line in sock.makefile():
+    response = json.loads(line)
+    if "error" in response:
+        sys.exit("error: " + response["error"])
+    if "stream" in response:
+        output[response["stream"]] += base64.b64decode(response["data"])
+    if "exit_code" in response:
+        exit_code = response["exit_code"]
+print(output["stdout"].decode().strip(), output["stderr"].decode().strip(), exit_code)
+CLIENT
+
+if [ "$(cat "$WORK_DIR/result")" != "hello oops 0" ]; then
+    echo "expected \"hello oops 0\"; got \"$(cat "$WORK_DIR/result")\""
+    exit 1
+fi
+
+# mounts are validated as those given on the command line are; the stub records
+# the mounts it is given
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.dumps([m for m in json.load(open("config.json"))["mounts"] if m["type"] == "bind"]))' > "$WORK_DIR/mounts"
+STUB
+mkdir "$WORK_DIR/src"
+python3 - "$SOCKET" "$ALPINE" "$ALPINE_SHA256" "$WORK_DIR" > "$WORK_DIR/result" <<'CLIENT'
+import json, socket, sys
+for source in (sys.argv[4] + "/src", sys.argv[4] + "/missing"):
+    sock = socket.socket(socket.AF_UNIX)
+    sock.connect(sys.argv[1])
+    request = {"image": sys.argv[2], "sha256": sys.argv[3], "command": "true",
+               "mounts": [{"source": source, "destination": "/src", "readonly": True}]}
+    sock.sendall(json.dumps(request).encode() + b"\n")
+    for line in sock.makefile():
+        response = json.loads(line)
+        if "error" in response:
+            print(response["error"])
+        if "exit_code" in response:
+            print(response["exit_code"])
+CLIENT
+expected="0
+bind mount source $WORK_DIR/missing: does not exist"
+if [ "$(cat "$WORK_DIR/result")" != "$expected" ]; then
+    echo "expected:"
+    echo "$expected"
+    echo "got:"
+    cat "$WORK_DIR/result"
+    exit 1
+fi
+if ! grep -q "\"options\": \[\"rbind\", \"rprivate\", \"ro\"\], \"source\": \"$WORK_DIR/src\"" "$WORK_DIR/mounts"; then
+    echo "expected the read-only mount to be passed to the runtime, got:"
+    cat "$WORK_DIR/mounts"
+    exit 1
+fi
+
+# connecting is root-equivalent, so others can not connect to the socket unless
+# they are in the --socket-group
+cat > "$WORK_DIR/connect.py" <<'CLIENT'
+import socket, sys
+try:
+    socket.socket(socket.AF_UNIX).connect(sys.argv[1])
+    print("connected")
+except PermissionError:
+    print("denied")
+CLIENT
+chmod 0711 "$WORK_DIR"
+chmod 0644 "$WORK_DIR/connect.py"
+# run as nobody, who may not be able to run a python installed for root
+PYTHON=/usr/bin/python3
+if [ ! -x "$PYTHON" ]; then
+    PYTHON=$(python3 -c 'import sys; print(sys.executable)')
+fi
+if [ "$(setpriv --reuid=65534 --regid=65534 --clear-groups "$PYTHON" "$WORK_DIR/connect.py" "$SOCKET")" != "denied" ]; then
+    echo "expected another user to be denied access to the socket"
+    exit 1
+fi
+
+GROUP_SOCKET="$WORK_DIR/group.sock"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --socket-group 65534 serve "$GROUP_SOCKET" &
+GROUP_SERVER_PID=$!
+trap 'kill $SERVER_PID $GROUP_SERVER_PID; rm -rf "$WORK_DIR"' EXIT
+for i in $(seq 50); do
+    if [ -S "$GROUP_SOCKET" ]; then
+        break
+    fi
+    sleep 0.1
+done
+sleep 0.1
+if [ "$(stat -c %a:%g "$GROUP_SOCKET")" != "660:65534" ]; then
+    echo "expected the socket to be shared with the group, got $(stat -c %a:%g "$GROUP_SOCKET")"
+    exit 1
+fi
+if [ "$(setpriv --reuid=65534 --regid=65534 --clear-groups "$PYTHON" "$WORK_DIR/connect.py" "$GROUP_SOCKET")" != "connected" ]; then
+    echo "expected a member of the --socket-group to be able to connect"
+    exit 1
+fi
+
+if "$BINARY" --socket-group no-such-group serve "$WORK_DIR/other.sock" 2>"$WORK_DIR/stderr"; then
+    echo "expected an unknown --socket-group to be rejected"
+    exit 1
+fi
+if ! grep -q "invalid --socket-group" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/other.sock" ]; then
+    echo "expected an invalid --socket-group error, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
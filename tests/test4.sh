@@ -0,0 +1,36 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+STUB_DIR=$(mktemp -d)
+trap 'rm -rf "$STUB_DIR" /tmp/acbrun-detach-test*' EXIT
+
+# stub runtime which records its arguments and reports every container as running
+cat > "$STUB_DIR/runc" <<STUB
+#!/bin/sh
+echo "\$@" >> "$STUB_DIR/calls"
+case "\$*" in
+state*) echo '{"status":"running"}' ;;
+esac
+STUB
+chmod +x "$STUB_DIR/runc"
+
+NAME=$(PATH="$STUB_DIR:$PATH" "$BINARY" --detach --name detach-test "$ALPINE" "$ALPINE_SHA256" 'sleep 100')
+if [ "$NAME" != "detach-test" ]; then
+    echo "expected the container name to be printed, got: $NAME"
+    exit 1
+fi
+if ! grep -q -- "run --detach detach-test" "$STUB_DIR/calls"; then
+    echo "runc was not started detached:"
+    cat "$STUB_DIR/calls"
+    exit 1
+fi
@@ -0,0 +1,40 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records that it was run
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/runc-was-run"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for name in "../escape" "my container" ".."; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --name "$name" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected the container name \"$name\" to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: invalid container name" "$WORK_DIR/stderr"; then
+        echo "expected an invalid container name error for \"$name\"; got: $(cat "$WORK_DIR/stderr")"
+        exit 1
+    fi
+done
+if [ -e "$WORK_DIR/runc-was-run" ]; then
+    echo "runc was run with an invalid container name"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --name "valid_name-1.0" "$ALPINE" "$ALPINE_SHA256" 'true'
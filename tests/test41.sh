@@ -0,0 +1,37 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" \
+    --tag myimage:1.0 --tag registry.example.com:5000/team/app \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+
+REPO_TAGS=$(tar -xzOf "$WORK_DIR/out.tar.gz" manifest.json | python3 -c 'import json, sys; print(" ".join(json.load(sys.stdin)[0]["RepoTags"]))')
+if [ "$REPO_TAGS" != "myimage:1.0 registry.example.com:5000/team/app:latest" ]; then
+    echo "unexpected RepoTags: $REPO_TAGS"
+    exit 1
+fi
+
+for tag in "MyImage" "myimage:" "myimage@sha256:abc" "my image"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/invalid.tar.gz" --tag "$tag" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected the tag \"$tag\" to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,49 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image whose gzip trailer holds the wrong CRC; the tar
+# entries themselves are intact, so only the trailer reveals the corruption
+mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+echo "contents" > "$WORK_DIR/layer/data/file"
+tar -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+python3 -c '
+import sys
+path = sys.argv[1]
+data = bytearray(open(path, "rb").read())
+data[-8] ^= 0xff
+open(path, "wb").write(data)
+' "$WORK_DIR/image/layer.tar.gz"
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records that it was run
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected the corrupt layer to be rejected"
+    exit 1
+fi
+if ! grep -q "checksum" "$WORK_DIR/stderr"; then
+    echo "expected a checksum error, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$WORK_DIR/ran" ]; then
+    echo "expected runc not to run"
+    exit 1
+fi
@@ -0,0 +1,61 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+echo "s3cr3t" > "$WORK_DIR/token"
+
+# stub runtime which checks that the secret is mounted read-only, then creates
+# its mount point in the rootfs as runc would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+mounts = json.load(open("config.json"))["mounts"]
+secret = [m for m in mounts if m["destination"] == "/run/secrets/token"]
+assert len(secret) == 1, mounts
+assert secret[0]["source"] == "$WORK_DIR/token", secret
+assert "ro" in secret[0]["options"], secret
+'
+mkdir -p rootfs/run/secrets
+cp "$WORK_DIR/token" rootfs/run/secrets/token
+echo "built" > rootfs/root/artifact
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+cd "$WORK_DIR"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" \
+    --mount-secret id=token,src=token,target=/run/secrets/token \
+    "$ALPINE" "$ALPINE_SHA256" 'cat /run/secrets/token'
+
+mkdir "$WORK_DIR/out"
+tar -xzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+LAYER=$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))[0]["Layers"][-1])' "$WORK_DIR/out/manifest.json")
+tar -tzf "$WORK_DIR/out/$LAYER" > "$WORK_DIR/entries"
+if ! grep -qx './root/artifact\|root/artifact' "$WORK_DIR/entries"; then
+    echo "expected the output image to contain root/artifact"
+    exit 1
+fi
+if grep -q 'run/secrets/token' "$WORK_DIR/entries"; then
+    echo "expected the secret to be left out of the output image"
+    exit 1
+fi
+
+for value in "src=token,target=/run/secrets/token" "id=token,target=/run/secrets/token" "id=token,src=token,target=relative" "id=token,src=token,target=/x,mode=0400"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mount-secret "$value" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --mount-secret $value to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,37 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+# a plain text file, and gzip compressed text which is not a tar
+seq 1 1000 > "$WORK_DIR/image.txt"
+gzip -c "$WORK_DIR/image.txt" > "$WORK_DIR/image.txt.gz"
+
+for image in "$WORK_DIR/image.txt" "$WORK_DIR/image.txt.gz"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$image" skip-sha256-validation 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected $image to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: input is not a valid gzip/tar image: " "$WORK_DIR/stderr"; then
+        echo "expected a clear error for $image, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+    if grep -q "^panic:" "$WORK_DIR/stderr"; then
+        echo "expected no stack trace for $image, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
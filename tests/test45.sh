@@ -0,0 +1,68 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+
+# build an image which no other run has a snapshot of
+mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+echo "unique contents $WORK_DIR $(date +%s%N)" > "$WORK_DIR/layer/data/file"
+ln -s file "$WORK_DIR/layer/data/link"
+tar -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+SNAPSHOT="/tmp/acbrun-snapshots/$IMAGE_SHA256"
+trap 'rm -rf "$WORK_DIR" "$SNAPSHOT"' EXIT
+
+# stub runtime which records the rootfs it is given, and then modifies it
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/data/file >> "$WORK_DIR/seen"
+readlink rootfs/data/link >> "$WORK_DIR/seen"
+echo "modified" > rootfs/data/file
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v --cache-snapshots "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/first"
+if ! grep -q "^saved snapshot $SNAPSHOT" "$WORK_DIR/first"; then
+    echo "expected the first run to save a snapshot, got:"
+    cat "$WORK_DIR/first"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v --cache-snapshots "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/second"
+if ! grep -q "^restored rootfs from snapshot $SNAPSHOT" "$WORK_DIR/second"; then
+    echo "expected the second run to be served from the snapshot, got:"
+    cat "$WORK_DIR/second"
+    exit 1
+fi
+if grep -q "^extracting" "$WORK_DIR/second"; then
+    echo "expected the second run not to extract the image, got:"
+    cat "$WORK_DIR/second"
+    exit 1
+fi
+
+# both runs see the image as it was, unaffected by the first run's changes
+expected="$(cat "$WORK_DIR/layer/data/file")
+file
+$(cat "$WORK_DIR/layer/data/file")
+file"
+if [ "$(cat "$WORK_DIR/seen")" != "$expected" ]; then
+    echo "expected:"
+    echo "$expected"
+    echo "got:"
+    cat "$WORK_DIR/seen"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cache-snapshots --overlay "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --cache-snapshots to be rejected with --overlay"
+    exit 1
+fi
@@ -0,0 +1,44 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the process args
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.dumps(json.load(open("config.json"))["process"]["args"]))' > "$WORK_DIR/args"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+ARGS_JSON='["echo", "hello world", "$HOME", "it'"'"'s \"quoted\""]'
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --args-json "$ARGS_JSON" "$ALPINE" "$ALPINE_SHA256"
+
+if ! python3 -c 'import json, sys; assert json.loads(sys.argv[1]) == json.load(open(sys.argv[2])), open(sys.argv[2]).read()' "$ARGS_JSON" "$WORK_DIR/args"; then
+    echo "expected the process args to match $ARGS_JSON"
+    exit 1
+fi
+
+for value in '[]' '["echo", 1]' '"echo hello"' '{"args": ["echo"]}' '[echo'; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --args-json "$value" "$ALPINE" "$ALPINE_SHA256" 2>/dev/null; then
+        echo "expected --args-json $value to be rejected"
+        exit 1
+    fi
+done
+
+# the command argument is replaced by --args-json
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --args-json '["true"]' "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --args-json to be rejected along with a command"
+    exit 1
+fi
@@ -0,0 +1,61 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which exits with the code given as the command, as runc run does
+# when the container's command exits
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+exit "$(python3 -c 'import json; print(json.load(open("config.json"))["process"]["args"][-1])')"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+status=0
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exit-code-file "$WORK_DIR/exit-code" "$ALPINE" "$ALPINE_SHA256" '3' 2> "$WORK_DIR/stderr" || status=$?
+if [ "$status" != "3" ]; then
+    echo "expected acbrun to exit with the command's exit code 3; got $status"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR/exit-code")" != "3" ]; then
+    echo "expected the exit code file to contain 3; got: $(cat "$WORK_DIR/exit-code")"
+    exit 1
+fi
+if grep -q "^panic:" "$WORK_DIR/stderr"; then
+    echo "expected no stack trace for a failing command, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exit-code-file "$WORK_DIR/exit-code" "$ALPINE" "$ALPINE_SHA256" '0'
+if [ "$(cat "$WORK_DIR/exit-code")" != "0" ]; then
+    echo "expected the exit code file to contain 0; got: $(cat "$WORK_DIR/exit-code")"
+    exit 1
+fi
+
+# without --exit-code-file, a failing command is reported as before rather than
+# passing its exit code on
+rm "$WORK_DIR/exit-code"
+status=0
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" '3' 2>/dev/null || status=$?
+if [ "$status" = "0" ] || [ "$status" = "3" ]; then
+    echo "expected acbrun's own exit status without --exit-code-file; got $status"
+    exit 1
+fi
+if [ -e "$WORK_DIR/exit-code" ]; then
+    echo "expected no exit code file without --exit-code-file"
+    exit 1
+fi
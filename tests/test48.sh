@@ -0,0 +1,54 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a two layer image whose second layer overwrites a file of the first, but
+# whose manifest lists the layers in the opposite order to the config's DiffIDs
+mkdir -p "$WORK_DIR/base/data" "$WORK_DIR/top/data" "$WORK_DIR/image"
+echo "base" > "$WORK_DIR/base/data/file"
+echo "top" > "$WORK_DIR/top/data/file"
+tar -cf "$WORK_DIR/base.tar" -C "$WORK_DIR/base" .
+tar -cf "$WORK_DIR/top.tar" -C "$WORK_DIR/top" .
+gzip -c "$WORK_DIR/base.tar" > "$WORK_DIR/image/base.tar.gz"
+gzip -c "$WORK_DIR/top.tar" > "$WORK_DIR/image/top.tar.gz"
+BASE_DIFF_ID=$(sha256sum "$WORK_DIR/base.tar" | cut -d ' ' -f 1)
+TOP_DIFF_ID=$(sha256sum "$WORK_DIR/top.tar" | cut -d ' ' -f 1)
+echo '{"rootfs":{"type":"layers","diff_ids":["sha256:'"$BASE_DIFF_ID"'","sha256:'"$TOP_DIFF_ID"'"]}}' > "$WORK_DIR/image/config.json"
+echo '[{"Config":"config.json","Layers":["top.tar.gz","base.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json top.tar.gz base.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the file both layers contain
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/data/file > "$WORK_DIR/file"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+if [ "$(cat "$WORK_DIR/file")" != "top" ]; then
+    echo "expected the layers to be applied in the config's order; got: $(cat "$WORK_DIR/file")"
+    exit 1
+fi
+if ! grep -q "^WARNING: the manifest lists the layers in a different order" "$WORK_DIR/stderr"; then
+    echo "expected a warning about the layer order, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# a config DiffID which no layer matches is an error
+echo '{"rootfs":{"type":"layers","diff_ids":["sha256:'"$BASE_DIFF_ID"'","sha256:'"$BASE_DIFF_ID"'"]}}' > "$WORK_DIR/image/config.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json top.tar.gz base.tar.gz
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2>/dev/null; then
+    echo "expected an image whose layers do not match its config to be rejected"
+    exit 1
+fi
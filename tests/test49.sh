@@ -0,0 +1,38 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the cpuset
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.load(open("config.json"))["linux"]["resources"]["cpu"]["cpus"])' > "$WORK_DIR/cpus"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for cpus in "0-3" "0,2" "1,4-7,9"; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cpuset-cpus "$cpus" "$ALPINE" "$ALPINE_SHA256" 'true'
+    if [ "$(cat "$WORK_DIR/cpus")" != "$cpus" ]; then
+        echo "expected linux.resources.cpu.cpus to be $cpus; got $(cat "$WORK_DIR/cpus")"
+        exit 1
+    fi
+done
+
+for cpus in "3-1" "0-" "-1" "a" "0,,1" "0 1" "1.5"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cpuset-cpus "$cpus" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --cpuset-cpus $cpus to be rejected"
+        exit 1
+    fi
+done
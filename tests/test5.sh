@@ -0,0 +1,47 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+STUB_DIR=$(mktemp -d)
+ACBRUN_TMPDIR=$(mktemp -d)
+trap 'rm -rf "$STUB_DIR" "$ACBRUN_TMPDIR"' EXIT
+
+# stub runtime which fills the rootfs with incompressible data, so that writing
+# the output takes long enough to be interrupted
+cat > "$STUB_DIR/runc" <<'STUB'
+#!/bin/sh
+head -c 268435456 /dev/urandom > rootfs/big
+STUB
+chmod +x "$STUB_DIR/runc"
+
+PATH="$STUB_DIR:$PATH" TMPDIR="$ACBRUN_TMPDIR" "$BINARY" --output "$STUB_DIR/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true' &
+PID=$!
+
+# the output directory is the only temporary directory without the acbrun- prefix
+i=0
+while [ -z "$(find "$ACBRUN_TMPDIR" -mindepth 1 -maxdepth 1 ! -name 'acbrun-*')" ]; do
+    i=$((i + 1))
+    if [ "$i" -gt 600 ]; then
+        echo "timed out waiting for acbrun to start writing its output"
+        kill "$PID"
+        exit 1
+    fi
+    sleep 0.1
+done
+kill -TERM "$PID"
+wait "$PID" || true
+
+LEFTOVER=$(ls -A "$ACBRUN_TMPDIR")
+if [ -n "$LEFTOVER" ]; then
+    echo "temporary directories were left behind: $LEFTOVER"
+    exit 1
+fi
@@ -0,0 +1,38 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the block IO weight
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.load(open("config.json"))["linux"]["resources"]["blockIO"]["weight"])' > "$WORK_DIR/weight"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for weight in 10 500 1000; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --blkio-weight "$weight" "$ALPINE" "$ALPINE_SHA256" 'true'
+    if [ "$(cat "$WORK_DIR/weight")" != "$weight" ]; then
+        echo "expected linux.resources.blockIO.weight to be $weight; got $(cat "$WORK_DIR/weight")"
+        exit 1
+    fi
+done
+
+for weight in 0 9 1001 abc; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --blkio-weight "$weight" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --blkio-weight $weight to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,76 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a two layer image whose entries are stored in a known, unsorted order;
+# the manifest lists the layers in the opposite order to which they are archived
+mkdir "$WORK_DIR/image"
+python3 - "$WORK_DIR/image" <<'BUILD'
+import io, sys, tarfile
+
+def layer(path, entries):
+    with tarfile.open(path, "w:gz") as tf:
+        for name, kind, mode, data in entries:
+            info = tarfile.TarInfo(name)
+            info.mode = mode
+            if kind == "dir":
+                info.type = tarfile.DIRTYPE
+                tf.addfile(info)
+            elif kind == "symlink":
+                info.type = tarfile.SYMTYPE
+                info.linkname = data
+                tf.addfile(info)
+            else:
+                info.size = len(data)
+                tf.addfile(info, io.BytesIO(data))
+
+layer(sys.argv[1] + "/base.tar.gz", [
+    ("zzz/", "dir", 0o755, None),
+    ("zzz/file", "file", 0o644, b"hello\n"),
+    ("aaa", "symlink", 0o777, "zzz/file"),
+])
+layer(sys.argv[1] + "/top.tar.gz", [
+    ("zzz/.wh.file", "file", 0o600, b""),
+    ("run.sh", "file", 0o4755, b"#!/bin/sh\n"),
+])
+BUILD
+echo '[{"Layers":["base.tar.gz","top.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json top.tar.gz base.tar.gz
+
+"$BINARY" --list-layer-entries "$WORK_DIR/image.tar.gz" > "$WORK_DIR/listing"
+expected="LAYER        TYPE     MODE        SIZE  NAME
+base.tar.gz  dir      drwxr-xr-x  0     zzz/
+base.tar.gz  file     -rw-r--r--  6     zzz/file
+base.tar.gz  symlink  Lrwxrwxrwx  0     aaa -> zzz/file
+top.tar.gz   file     -rw-------  0     zzz/.wh.file
+top.tar.gz   file     urwxr-xr-x  10    run.sh"
+if [ "$(cat "$WORK_DIR/listing")" != "$expected" ]; then
+    echo "expected:"
+    echo "$expected"
+    echo "got:"
+    cat "$WORK_DIR/listing"
+    exit 1
+fi
+
+"$BINARY" --format=json --list-layer-entries "$WORK_DIR/image.tar.gz" > "$WORK_DIR/listing.json"
+python3 - "$WORK_DIR/listing.json" <<'CHECK'
+import json, sys
+entries = [json.loads(line) for line in open(sys.argv[1])]
+assert [(e["layer"], e["name"]) for e in entries] == [
+    ("base.tar.gz", "zzz/"),
+    ("base.tar.gz", "zzz/file"),
+    ("base.tar.gz", "aaa"),
+    ("top.tar.gz", "zzz/.wh.file"),
+    ("top.tar.gz", "run.sh"),
+], entries
+assert entries[2]["type"] == "symlink" and entries[2]["linkname"] == "zzz/file", entries[2]
+assert entries[1]["size"] == 6, entries[1]
+CHECK
@@ -0,0 +1,76 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which adds a file to the rootfs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo "hello" > rootfs/root/data
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output-dir "$WORK_DIR/layout" --tag myimage:1.0 \
+    "$ALPINE" "$ALPINE_SHA256" 'echo hello > /root/data'
+
+python3 - "$WORK_DIR/layout" <<'CHECK'
+import gzip, hashlib, io, json, os, sys, tarfile
+layout = sys.argv[1]
+
+assert json.load(open(os.path.join(layout, "oci-layout"))) == {"imageLayoutVersion": "1.0.0"}
+
+# every blob is named after its digest
+blobs = os.path.join(layout, "blobs", "sha256")
+for name in os.listdir(blobs):
+    with open(os.path.join(blobs, name), "rb") as f:
+        assert hashlib.sha256(f.read()).hexdigest() == name, name
+
+def blob(descriptor):
+    algorithm, encoded = descriptor["digest"].split(":")
+    assert algorithm == "sha256", descriptor
+    data = open(os.path.join(blobs, encoded), "rb").read()
+    assert len(data) == descriptor["size"], descriptor
+    return data
+
+index = json.load(open(os.path.join(layout, "index.json")))
+assert index["schemaVersion"] == 2, index
+assert len(index["manifests"]) == 1, index
+descriptor = index["manifests"][0]
+assert descriptor["mediaType"] == "application/vnd.oci.image.manifest.v1+json", descriptor
+assert descriptor["annotations"]["org.opencontainers.image.ref.name"] == "myimage:1.0", descriptor
+
+manifest = json.loads(blob(descriptor))
+assert manifest["config"]["mediaType"] == "application/vnd.oci.image.config.v1+json", manifest
+config = json.loads(blob(manifest["config"]))
+assert len(manifest["layers"]) == 1, manifest
+layer = manifest["layers"][0]
+assert layer["mediaType"] == "application/vnd.oci.image.layer.v1.tar+gzip", layer
+
+uncompressed = gzip.decompress(blob(layer))
+assert config["rootfs"]["diff_ids"] == ["sha256:" + hashlib.sha256(uncompressed).hexdigest()], config
+with tarfile.open(fileobj=io.BytesIO(uncompressed)) as tf:
+    data = tf.extractfile(tf.getmember("root/data")).read()
+assert data == b"hello\n", data
+
+assert sorted(os.listdir(blobs)) == sorted(
+    d["digest"].split(":")[1] for d in [descriptor, manifest["config"], layer]
+), os.listdir(blobs)
+CHECK
+
+# the layout is never written over an existing directory's contents
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output-dir "$WORK_DIR/layout" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected a non-empty --output-dir to be rejected"
+    exit 1
+fi
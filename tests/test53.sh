@@ -0,0 +1,81 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+NAME="acbrun-test53-$$"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" "/tmp/acbrun-$NAME" "/tmp/acbrun-$NAME.lock"' EXIT
+
+# stub runtime whose container dies as soon as it has been seen running once
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+STATE="$WORK_DIR/state"
+if [ "\$1" = "--log" ]; then
+    shift 2
+fi
+case "\$1" in
+run)
+    echo "\$*" >> "$WORK_DIR/runs"
+    echo running > "\$STATE"
+    ;;
+state)
+    if [ ! -e "\$STATE" ]; then
+        echo '"container does not exist"' >&2
+        exit 1
+    fi
+    printf '{"status": "%s", "pid": 1}\n' "\$(cat "\$STATE")"
+    echo stopped > "\$STATE"
+    ;;
+delete)
+    echo "\$*" >> "$WORK_DIR/deletes"
+    rm -f "\$STATE"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+status=0
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" --restart --max-restarts 2 \
+    "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr" || status=$?
+if [ "$status" = "0" ]; then
+    echo "expected acbrun to fail once the container could no longer be restarted"
+    exit 1
+fi
+if ! grep -q "^error: container $NAME stopped after being restarted 2 times" "$WORK_DIR/stderr"; then
+    echo "expected a restart limit error, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ "$(grep -c "^WARNING: container $NAME is stopped; restarting it" "$WORK_DIR/stderr")" != "2" ]; then
+    echo "expected two restart warnings, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# the initial run, and one for each restart, each after deleting the stopped container
+if [ "$(wc -l < "$WORK_DIR/runs")" != "3" ]; then
+    echo "expected the container to be run 3 times, got:"
+    cat "$WORK_DIR/runs"
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR/deletes")" != "delete --force $NAME
+delete --force $NAME" ]; then
+    echo "expected the stopped container to be deleted before each restart, got:"
+    cat "$WORK_DIR/deletes"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --restart "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --restart to require --reentrant"
+    exit 1
+fi
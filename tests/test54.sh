@@ -0,0 +1,54 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build two images with the same layer, whose configs hold the same labels, but
+# in a different order
+mkdir -p "$WORK_DIR/layer/data"
+echo "contents" > "$WORK_DIR/layer/data/file"
+tar -czf "$WORK_DIR/layer.tar.gz" -C "$WORK_DIR/layer" .
+build_image() {
+    mkdir "$WORK_DIR/$1"
+    cp "$WORK_DIR/layer.tar.gz" "$WORK_DIR/$1/layer.tar.gz"
+    echo "$2" > "$WORK_DIR/$1/config.json"
+    echo '[{"Config":"config.json","Layers":["layer.tar.gz"]}]' > "$WORK_DIR/$1/manifest.json"
+    tar -czf "$WORK_DIR/$1.tar.gz" -C "$WORK_DIR/$1" manifest.json config.json layer.tar.gz
+}
+build_image first '{"config":{"Labels":{"zebra":"1","alpha":"<b>&","middle":"2"}}}'
+build_image second '{"config":{"Labels":{"middle":"2","alpha":"<b>&","zebra":"1"}}}'
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+for image in first second; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --squash --source-date-epoch 0 --output "$WORK_DIR/$image-out.tar.gz" \
+        "$WORK_DIR/$image.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+    mkdir "$WORK_DIR/$image-out"
+    tar -xzf "$WORK_DIR/$image-out.tar.gz" -C "$WORK_DIR/$image-out"
+done
+
+python3 - "$WORK_DIR/first-out" "$WORK_DIR/second-out" <<'CHECK'
+import json, os, sys
+configs = []
+for out in sys.argv[1:]:
+    manifest = json.load(open(os.path.join(out, "manifest.json")))[0]
+    configs.append((manifest["Config"], open(os.path.join(out, manifest["Config"]), "rb").read()))
+assert configs[0] == configs[1], configs
+
+config = configs[0][1].decode()
+parsed = json.loads(config)
+assert parsed["config"]["Labels"] == {"alpha": "<b>&", "middle": "2", "zebra": "1"}, parsed
+# canonical: sorted keys, no whitespace, and no escaping of HTML characters
+canonical = json.dumps(parsed, sort_keys=True, separators=(",", ":"), ensure_ascii=False)
+assert config == canonical, (config, canonical)
+CHECK
@@ -0,0 +1,50 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir "$WORK_DIR/src"
+
+# stub runtime which checks the bind mount
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+mounts = json.load(open("config.json"))["mounts"]
+binds = [m for m in mounts if m["destination"] == "/src"]
+assert len(binds) == 1, mounts
+assert binds[0]["type"] == "bind", binds
+assert binds[0]["source"] == "$WORK_DIR/src", binds
+assert "rbind" in binds[0]["options"], binds
+assert "ro" in binds[0]["options"], binds
+'
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# relative host paths are relative to the working directory
+cd "$WORK_DIR"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --ro-bind src:/src "$ALPINE" "$ALPINE_SHA256" 'ls /src'
+if [ ! -e "$WORK_DIR/ran" ]; then
+    echo "expected runc to run"
+    exit 1
+fi
+
+for value in "src" ":/src" "src:relative" "missing:/src"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --ro-bind "$value" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --ro-bind $value to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,72 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build an image in the format of older versions of docker save, where each layer
+# is an uncompressed <id>/layer.tar, alongside VERSION and json files; the second
+# layer is empty, as the layers of e.g. ENV instructions were
+mkdir -p "$WORK_DIR/base/data" "$WORK_DIR/top/data" "$WORK_DIR/empty" "$WORK_DIR/image"
+echo "base" > "$WORK_DIR/base/data/base"
+echo "top" > "$WORK_DIR/top/data/top"
+BASE_ID=1111111111111111111111111111111111111111111111111111111111111111
+EMPTY_ID=2222222222222222222222222222222222222222222222222222222222222222
+TOP_ID=3333333333333333333333333333333333333333333333333333333333333333
+for id in $BASE_ID $EMPTY_ID $TOP_ID; do
+    mkdir "$WORK_DIR/image/$id"
+    echo "1.0" > "$WORK_DIR/image/$id/VERSION"
+    echo '{"id":"'"$id"'"}' > "$WORK_DIR/image/$id/json"
+done
+tar -cf "$WORK_DIR/image/$BASE_ID/layer.tar" -C "$WORK_DIR/base" .
+head -c 1024 /dev/zero > "$WORK_DIR/image/$EMPTY_ID/layer.tar"
+tar -cf "$WORK_DIR/image/$TOP_ID/layer.tar" -C "$WORK_DIR/top" .
+diff_id() {
+    echo "sha256:$(sha256sum "$WORK_DIR/image/$1/layer.tar" | cut -d ' ' -f 1)"
+}
+CONFIG='{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["'$(diff_id $BASE_ID)'","'$(diff_id $EMPTY_ID)'","'$(diff_id $TOP_ID)'"]}}'
+CONFIG_ID=$(printf '%s' "$CONFIG" | sha256sum | cut -d ' ' -f 1)
+printf '%s' "$CONFIG" > "$WORK_DIR/image/$CONFIG_ID.json"
+echo '[{"Config":"'"$CONFIG_ID"'.json","RepoTags":["old:latest"],"Layers":["'$BASE_ID'/layer.tar","'$EMPTY_ID'/layer.tar","'$TOP_ID'/layer.tar"]}]' > "$WORK_DIR/image/manifest.json"
+echo '{"old":{"latest":"'"$TOP_ID"'"}}' > "$WORK_DIR/image/repositories"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" .
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the files of both layers
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/data/base rootfs/data/top > "$WORK_DIR/files"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/files")" != "base
+top" ]; then
+    echo "expected the files of both layers, got:"
+    cat "$WORK_DIR/files"
+    exit 1
+fi
+
+# the single layer fast path streams the layer out of the image
+echo '[{"Config":"'"$CONFIG_ID"'.json","Layers":["'$TOP_ID'/layer.tar"]}]' > "$WORK_DIR/image/manifest.json"
+CONFIG='{"rootfs":{"type":"layers","diff_ids":["'$(diff_id $TOP_ID)'"]}}'
+printf '%s' "$CONFIG" > "$WORK_DIR/image/$CONFIG_ID.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" .
+rm -rf "$WORK_DIR/files"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/data/top > "$WORK_DIR/files"
+STUB
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/files")" != "top" ]; then
+    echo "expected the file of the single layer, got:"
+    cat "$WORK_DIR/files"
+    exit 1
+fi
@@ -0,0 +1,60 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the namespaces and process args
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+config = json.load(open("config.json"))
+print(" ".join(ns["type"] for ns in config["linux"]["namespaces"]))
+print(" ".join(config["process"]["args"]))
+' > "$WORK_DIR/config"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(head -n 1 "$WORK_DIR/config")" != "pid ipc uts mount cgroup network" ]; then
+    echo "expected a private pid namespace by default, got: $(head -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --pid-namespace=private "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(head -n 1 "$WORK_DIR/config")" != "pid ipc uts mount cgroup network" ]; then
+    echo "expected a private pid namespace, got: $(head -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --pid-namespace=host "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(head -n 1 "$WORK_DIR/config")" != "ipc uts mount cgroup network" ]; then
+    echo "expected no pid namespace, got: $(head -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --pid-namespace=container "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected an unknown --pid-namespace to be rejected"
+    exit 1
+fi
+
+# without its own pid namespace, the init must register as a subreaper
+if command -v tini >/dev/null; then
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --init --pid-namespace=host "$ALPINE" "$ALPINE_SHA256" 'true'
+    if [ "$(tail -n 1 "$WORK_DIR/config")" != "/dev/init -s -- sh -c true" ]; then
+        echo "expected the init to run as a subreaper, got: $(tail -n 1 "$WORK_DIR/config")"
+        exit 1
+    fi
+fi
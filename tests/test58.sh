@@ -0,0 +1,64 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which changes the rootfs the way the container's command would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+grep " \$PWD/rootfs " /proc/mounts > "$WORK_DIR/mounts"
+if [ -f rootfs/root/counter ]; then
+    echo \$((\$(cat rootfs/root/counter) + 1)) > rootfs/root/counter
+else
+    echo 1 > rootfs/root/counter
+fi
+rm -f rootfs/etc/motd
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+UPPER="$WORK_DIR/upper"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --overlay --upperdir "$UPPER" "$ALPINE" "$ALPINE_SHA256" 'true'
+
+if ! grep -q "^overlay .* overlay .*upperdir=$UPPER[, ]" "$WORK_DIR/mounts"; then
+    echo "rootfs is not mounted with upperdir=$UPPER:"
+    cat "$WORK_DIR/mounts"
+    exit 1
+fi
+if [ "$(cat "$UPPER/root/counter")" != "1" ]; then
+    echo "expected the new file to be kept in the upperdir"
+    exit 1
+fi
+if [ ! -c "$UPPER/etc/motd" ]; then
+    echo "expected the removed file to be whited out in the upperdir"
+    exit 1
+fi
+
+# a second run sees the changes of the first one
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --overlay --upperdir "$UPPER" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$UPPER/root/counter")" != "2" ]; then
+    echo "expected the upperdir to be reused, got counter $(cat "$UPPER/root/counter")"
+    exit 1
+fi
+
+if [ "$(ls -A "$WORK_DIR" | sort | tr '\n' ' ')" != "bin mounts upper " ]; then
+    echo "overlay work directory was not removed:"
+    ls -A "$WORK_DIR"
+    exit 1
+fi
+
+if "$BINARY" --upperdir "$UPPER" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --upperdir without --overlay to be rejected"
+    exit 1
+fi
@@ -0,0 +1,85 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+UBUNTU="$SCRIPTPATH/../sample-images/ubuntu-25.04.tar.gz"
+UBUNTU_SHA256="6ab485077db0402298cbcbc5765f67b14b812dab9402c7c2fcf5435976ac1e80"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+if ldd "$BINARY" >/dev/null 2>&1; then
+    echo "--argv0 requires acbrun to be statically linked; build it with CGO_ENABLED=0"
+    exit 1
+fi
+
+# stub runtime which records the process args, then runs them chrooted into the
+# rootfs, with the bind mounts under /dev which acbrun adds
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+exec python3 -c '
+import json, os, subprocess
+config = json.load(open("config.json"))
+args = config["process"]["args"]
+open("$WORK_DIR/args", "w").write(json.dumps(args))
+mounts = [m for m in config["mounts"] if m["type"] == "bind" and m["destination"].startswith("/dev/")]
+for m in mounts:
+    target = "rootfs" + m["destination"]
+    os.makedirs(os.path.dirname(target), exist_ok=True)
+    open(target, "a").close()
+    subprocess.run(["mount", "--bind", m["source"], target], check=True)
+pid = os.fork()
+if pid == 0:
+    os.chroot("rootfs")
+    os.chdir("/")
+    os.execve(args[0], args, {"PATH": "/usr/sbin:/usr/bin:/sbin:/bin"})
+_, status = os.waitpid(pid, 0)
+for m in mounts:
+    subprocess.run(["umount", "rootfs" + m["destination"]], check=True)
+os._exit(os.waitstatus_to_exitcode(status))
+'
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# busybox picks the applet to run from argv[0], so /bin/false named echo prints
+OUTPUT=$(PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exec --argv0 echo "$ALPINE" "$ALPINE_SHA256" -- /bin/false hello)
+if [ "$(cat "$WORK_DIR/args")" != '["/dev/argv0", "argv0-exec", "echo", "/bin/false", "hello"]' ]; then
+    echo "unexpected process args: $(cat "$WORK_DIR/args")"
+    exit 1
+fi
+if [ "$OUTPUT" != "hello" ]; then
+    echo "expected the program to be run as echo, got: $OUTPUT"
+    exit 1
+fi
+
+# ubuntu's sh is dash, which has no exec -a; coreutils name themselves after
+# argv[0] in their errors, and the program is looked up in PATH
+status=0
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exec --argv0 custom-name "$UBUNTU" "$UBUNTU_SHA256" -- cat /nonexistent 2> "$WORK_DIR/stderr" || status=$?
+if ! grep -q "^custom-name: /nonexistent: No such file or directory" "$WORK_DIR/stderr"; then
+    echo "expected cat to be run as custom-name (status $status), got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# without --argv0, the args are used as given
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --exec "$ALPINE" "$ALPINE_SHA256" -- /bin/ls / >/dev/null
+if [ "$(cat "$WORK_DIR/args")" != '["/bin/ls", "/"]' ]; then
+    echo "unexpected process args: $(cat "$WORK_DIR/args")"
+    exit 1
+fi
+
+if "$BINARY" --argv0 custom-name "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --argv0 without --exec to be rejected"
+    exit 1
+fi
@@ -0,0 +1,51 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+NGINX="$SCRIPTPATH/../sample-images/nginx-1.27.2.tar.gz"
+NGINX_SHA256="2322bd348454db6e1feaacb2692475426162ad662b4cb02709d9de778b6c0d00"
+NGINX_LAYERS=7
+
+STUB_DIR=$(mktemp -d)
+trap 'rm -rf "$STUB_DIR"' EXIT
+
+# stub runtime which records the layer directories and the rootfs mount of the bundle
+cat > "$STUB_DIR/runc" <<STUB
+#!/bin/sh
+ls layers > "$STUB_DIR/layers"
+grep " \$PWD/rootfs " /proc/mounts > "$STUB_DIR/mounts"
+echo "\$PWD" > "$STUB_DIR/bundle"
+test -f rootfs/etc/nginx/nginx.conf
+STUB
+chmod +x "$STUB_DIR/runc"
+
+PATH="$STUB_DIR:$PATH" "$BINARY" --overlay "$NGINX" "$NGINX_SHA256" 'true'
+
+BUNDLE=$(cat "$STUB_DIR/bundle")
+if [ "$(cat "$STUB_DIR/layers" | sort -n | tr '\n' ' ')" != "$(seq 0 $((NGINX_LAYERS - 1)) | tr '\n' ' ')" ]; then
+    echo "unexpected layer directories:"
+    cat "$STUB_DIR/layers"
+    exit 1
+fi
+
+LOWERDIR="$BUNDLE/layers/$((NGINX_LAYERS - 1))"
+for n in $(seq $((NGINX_LAYERS - 2)) -1 0); do
+    LOWERDIR="$LOWERDIR:$BUNDLE/layers/$n"
+done
+for option in "lowerdir=$LOWERDIR" "upperdir=$BUNDLE/upper" "workdir=$BUNDLE/work"; do
+    if ! grep -q "^overlay $BUNDLE/rootfs overlay .*$option[, ]" "$STUB_DIR/mounts"; then
+        echo "rootfs is not mounted with $option:"
+        cat "$STUB_DIR/mounts"
+        exit 1
+    fi
+done
+
+if [ -e "$BUNDLE" ]; then
+    echo "working directory $BUNDLE was not removed"
+    exit 1
+fi
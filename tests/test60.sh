@@ -0,0 +1,54 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image whose config declares the given OS
+build_image() {
+    rm -rf "$WORK_DIR/image" "$WORK_DIR/layer"
+    mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+    echo "data" > "$WORK_DIR/layer/data/file"
+    tar -cf "$WORK_DIR/layer.tar" -C "$WORK_DIR/layer" .
+    gzip -c "$WORK_DIR/layer.tar" > "$WORK_DIR/image/layer.tar.gz"
+    DIFF_ID=$(sha256sum "$WORK_DIR/layer.tar" | cut -d ' ' -f 1)
+    echo '{"os":"'"$1"'","architecture":"amd64","rootfs":{"type":"layers","diff_ids":["sha256:'"$DIFF_ID"'"]}}' > "$WORK_DIR/image/config.json"
+    echo '[{"Config":"config.json","Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+    tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json layer.tar.gz
+}
+
+# stub runtime which records that it was run
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+build_image windows
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a windows image to be rejected"
+    exit 1
+fi
+if ! grep -q "^error: the image is built for the windows OS, but this host runs linux" "$WORK_DIR/stderr"; then
+    echo "expected an error about the image's OS, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$WORK_DIR/ran" ]; then
+    echo "expected the runtime not to be run for a windows image"
+    exit 1
+fi
+
+build_image linux
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true'
+if [ ! -e "$WORK_DIR/ran" ]; then
+    echo "expected the runtime to be run for a linux image"
+    exit 1
+fi
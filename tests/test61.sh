@@ -0,0 +1,85 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+if ! command -v zstd >/dev/null; then
+    echo "skipping: the zstd command is not installed"
+    exit 0
+fi
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# train a dictionary on files similar to the one the container writes
+mkdir "$WORK_DIR/samples"
+for i in $(seq 1 200); do
+    printf 'name=file%d\nvalue=%d\nhello world line %d\n' "$i" $((i * 7)) "$i" > "$WORK_DIR/samples/$i"
+done
+zstd --train -q "$WORK_DIR"/samples/* -o "$WORK_DIR/dict" --maxdict=1024 2>/dev/null
+
+# stub runtime which records the file the previous run wrote, if there is one, and
+# writes it into the rootfs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+if [ -e rootfs/root/data ]; then
+    head -n 1 rootfs/root/data > "$WORK_DIR/data"
+fi
+printf 'name=output\nvalue=42\nhello world line 42\n' > rootfs/root/data
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# check_layer <image> [<zstd args>...] decompresses the image's layer with the zstd
+# command and checks it contains the written file
+check_layer() {
+    image="$1"
+    shift
+    rm -rf "$WORK_DIR/image"
+    mkdir "$WORK_DIR/image"
+    tar -xzmf "$image" -C "$WORK_DIR/image"
+    LAYER=$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))[0]["Layers"][0])' "$WORK_DIR/image/manifest.json")
+    case "$LAYER" in
+        *.tar.zst) ;;
+        *) echo "expected a zstd layer, got $LAYER"; exit 1 ;;
+    esac
+    zstd --decompress --stdout --quiet "$@" "$WORK_DIR/image/$LAYER" > "$WORK_DIR/layer.tar"
+    if [ "$(tar -xOf "$WORK_DIR/layer.tar" root/data | head -n 1)" != "name=output" ]; then
+        echo "expected the layer of $image to contain root/data"
+        exit 1
+    fi
+    if [ "sha256:$(sha256sum "$WORK_DIR/layer.tar" | cut -d ' ' -f 1)" != "$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))["rootfs"]["diff_ids"][0])' "$WORK_DIR/image/$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))[0]["Config"])' "$WORK_DIR/image/manifest.json")")" ]; then
+        echo "expected the layer of $image to match its DiffID"
+        exit 1
+    fi
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output-compression=zstd --output "$WORK_DIR/plain.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+check_layer "$WORK_DIR/plain.tar.gz"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output-compression=zstd --zstd-dict "$WORK_DIR/dict" --output "$WORK_DIR/dict.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+check_layer "$WORK_DIR/dict.tar.gz" -D "$WORK_DIR/dict"
+if zstd --decompress --stdout --quiet "$WORK_DIR/image/$LAYER" > /dev/null 2>&1; then
+    echo "expected the layer compressed with a dictionary to need it"
+    exit 1
+fi
+
+# acbrun can run the output image given the same dictionary
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --zstd-dict "$WORK_DIR/dict" "$WORK_DIR/dict.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/data")" != "name=output" ]; then
+    echo "expected the output image to be extracted with the dictionary"
+    exit 1
+fi
+
+if "$BINARY" --zstd-dict "$WORK_DIR/missing" --output "$WORK_DIR/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected a missing --zstd-dict to be rejected"
+    exit 1
+fi
@@ -0,0 +1,62 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records its arguments, writes a debug line to its --log, and
+# fails to start the container
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+echo "\$@" > "$WORK_DIR/args"
+while [ \$# -gt 0 ]; do
+    if [ "\$1" = "--log" ]; then
+        echo 'level=debug msg="nsexec: failed to set up the stub container"' > "\$2"
+    fi
+    shift
+done
+exit 1
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --verbose-runc "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected the failing runtime to fail the run"
+    exit 1
+fi
+case "$(cat "$WORK_DIR/args")" in
+    "--debug --log "*"/runc.log run "*) ;;
+    *) echo "expected runc to be run with --debug and --log, got: $(cat "$WORK_DIR/args")"; exit 1 ;;
+esac
+if ! grep -q 'nsexec: failed to set up the stub container' "$WORK_DIR/stderr"; then
+    echo "expected the runc log to be shown, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# -vvv implies --verbose-runc
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -vvv "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null || true
+case "$(cat "$WORK_DIR/args")" in
+    "--debug --log "*) ;;
+    *) echo "expected -vvv to run runc with --debug, got: $(cat "$WORK_DIR/args")"; exit 1 ;;
+esac
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr" || true
+case "$(cat "$WORK_DIR/args")" in
+    "run "*) ;;
+    *) echo "expected runc to be run without --debug, got: $(cat "$WORK_DIR/args")"; exit 1 ;;
+esac
+if grep -q '^runc log:' "$WORK_DIR/stderr"; then
+    echo "expected no runc log without --verbose-runc"
+    exit 1
+fi
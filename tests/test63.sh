@@ -0,0 +1,51 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which alternates between writing to stdout and stderr
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo out-1
+echo err-1 >&2
+echo out-2
+echo err-2 >&2
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --merge-stderr "$ALPINE" "$ALPINE_SHA256" 'true' > "$WORK_DIR/stdout" 2> "$WORK_DIR/stderr"
+if [ "$(cat "$WORK_DIR/stdout" | tr '\n' ' ')" != "out-1 err-1 out-2 err-2 " ]; then
+    echo "expected both streams on stdout in order, got:"
+    cat "$WORK_DIR/stdout"
+    exit 1
+fi
+if [ -s "$WORK_DIR/stderr" ]; then
+    echo "expected nothing on stderr, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# without --merge-stderr, the streams are kept separate
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true' > "$WORK_DIR/stdout" 2> "$WORK_DIR/stderr"
+if [ "$(cat "$WORK_DIR/stdout" | tr '\n' ' ')" != "out-1 out-2 " ] || [ "$(cat "$WORK_DIR/stderr" | tr '\n' ' ')" != "err-1 err-2 " ]; then
+    echo "expected separate stdout and stderr, got:"
+    cat "$WORK_DIR/stdout" "$WORK_DIR/stderr"
+    exit 1
+fi
+
+if "$BINARY" --merge-stderr --detach "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --merge-stderr with --detach to be rejected"
+    exit 1
+fi
@@ -0,0 +1,40 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_LAYER="da9db072f522755cbeb85be2b3f84059b70571b229512f1571d9217b77e1087f.tar.gz"
+# the total size of the regular files in the alpine layer
+ALPINE_LAYER_SIZE=7792915
+
+NGINX="$SCRIPTPATH/../sample-images/nginx-1.27.2.tar.gz"
+
+OUTPUT=$("$BINARY" --estimate-size "$ALPINE")
+EXPECTED="LAYER                                                                    SIZE
+$ALPINE_LAYER  $ALPINE_LAYER_SIZE
+TOTAL                                                                    $ALPINE_LAYER_SIZE"
+if [ "$OUTPUT" != "$EXPECTED" ]; then
+    echo "unexpected --estimate-size output:"
+    echo "$OUTPUT"
+    exit 1
+fi
+
+# the total of a multi-layer image is the sum of its layers
+"$BINARY" --format json --estimate-size "$NGINX" | python3 -c '
+import json, sys
+estimate = json.load(sys.stdin)
+assert len(estimate["layers"]) == 7, estimate
+assert all(layer["size"] > 0 for layer in estimate["layers"]), estimate
+assert estimate["total"] == sum(layer["size"] for layer in estimate["layers"]), estimate
+'
+
+if "$BINARY" --estimate-size 2>/dev/null; then
+    echo "expected --estimate-size without an image to be rejected"
+    exit 1
+fi
@@ -0,0 +1,55 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the /dev mount and the device rules
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+config = json.load(open("config.json"))
+dev = [m for m in config["mounts"] if m["destination"] == "/dev"]
+print(json.dumps(dev))
+print(json.dumps(config["linux"]["resources"]["devices"]))
+' > "$WORK_DIR/config"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --dev-full "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+if [ "$(head -n 1 "$WORK_DIR/config")" != '[{"destination": "/dev", "options": ["rbind", "rprivate", "nosuid"], "source": "/dev", "type": "bind"}]' ]; then
+    echo "expected the host's /dev to be bind mounted, got: $(head -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
+if [ "$(tail -n 1 "$WORK_DIR/config")" != '[{"access": "rwm", "allow": true}]' ]; then
+    echo "expected access to all devices, got: $(tail -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
+if ! grep -q "^WARNING: --dev-full gives the container access to all of the host's devices" "$WORK_DIR/stderr"; then
+    echo "expected a warning about --dev-full, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# by default, /dev is a tmpfs with the minimal set of devices
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+case "$(head -n 1 "$WORK_DIR/config")" in
+    '[{"destination": "/dev", "type": "tmpfs", '*) ;;
+    *) echo "expected a tmpfs /dev, got: $(head -n 1 "$WORK_DIR/config")"; exit 1 ;;
+esac
+if [ "$(tail -n 1 "$WORK_DIR/config")" != '[{"allow": false, "access": "rwm"}]' ]; then
+    echo "expected access to devices to be denied, got: $(tail -n 1 "$WORK_DIR/config")"
+    exit 1
+fi
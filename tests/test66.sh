@@ -0,0 +1,53 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the process umask, in octal
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+umask = json.load(open("config.json"))["process"]["user"].get("umask")
+print("unset" if umask is None else "%04o" % umask)
+' > "$WORK_DIR/umask"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --umask 0027 "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/umask")" != "0027" ]; then
+    echo "expected a umask of 0027, got: $(cat "$WORK_DIR/umask")"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --umask 0 "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/umask")" != "0000" ]; then
+    echo "expected a umask of 0000, got: $(cat "$WORK_DIR/umask")"
+    exit 1
+fi
+
+# without --umask, the runtime's default is used
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/umask")" != "unset" ]; then
+    echo "expected no umask, got: $(cat "$WORK_DIR/umask")"
+    exit 1
+fi
+
+for umask in 0888 1777 022x; do
+    if "$BINARY" --umask "$umask" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --umask $umask to be rejected"
+        exit 1
+    fi
+done
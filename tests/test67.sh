@@ -0,0 +1,88 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# working directories are created in TMPDIR, which must be left empty
+mkdir "$WORK_DIR/tmp"
+export TMPDIR="$WORK_DIR/tmp"
+
+# build a single layer image whose manifest comes before its layer
+mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+echo "streamed" > "$WORK_DIR/layer/data/file"
+tar -cf "$WORK_DIR/layer.tar" -C "$WORK_DIR/layer" .
+gzip -c "$WORK_DIR/layer.tar" > "$WORK_DIR/image/layer.tar.gz"
+DIFF_ID=$(sha256sum "$WORK_DIR/layer.tar" | cut -d ' ' -f 1)
+echo '{"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:'"$DIFF_ID"'"]}}' > "$WORK_DIR/image/config.json"
+echo '[{"Config":"config.json","Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# the same image with an extra file after the layer
+echo "tampered" > "$WORK_DIR/image/extra"
+tar -czf "$WORK_DIR/tampered.tar.gz" -C "$WORK_DIR/image" manifest.json config.json layer.tar.gz extra
+
+# stub runtime which records the file from the layer
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/data/file > "$WORK_DIR/file" 2>/dev/null || cat rootfs/etc/alpine-release > "$WORK_DIR/file"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" - "$IMAGE_SHA256" 'true' < "$WORK_DIR/image.tar.gz"
+if [ "$(cat "$WORK_DIR/file")" != "streamed" ]; then
+    echo "expected the image to be read from stdin"
+    exit 1
+fi
+
+# images whose layers come first are read from stdin too
+rm "$WORK_DIR/file"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" - "$ALPINE_SHA256" 'true' < "$ALPINE"
+if [ "$(cat "$WORK_DIR/file")" != "$ALPINE_VERSION" ]; then
+    echo "expected alpine to be read from stdin"
+    exit 1
+fi
+if [ -n "$(ls -A "$WORK_DIR/tmp")" ]; then
+    echo "expected the working directories to be removed:"
+    ls -A "$WORK_DIR/tmp"
+    exit 1
+fi
+
+# the tampered image is spooled, but its sum is checked before its layer is
+# extracted; the run then fails without running anything
+rm "$WORK_DIR/file"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v - "$IMAGE_SHA256" 'true' < "$WORK_DIR/tampered.tar.gz" 2> "$WORK_DIR/stderr"; then
+    echo "expected the tampered image to be rejected"
+    exit 1
+fi
+if grep -q "^extracting layer.tar.gz" "$WORK_DIR/stderr" || ! grep -q "^expected sha256 sum $IMAGE_SHA256 does not match actual sum of -" "$WORK_DIR/stderr"; then
+    echo "expected the mismatch to be found before the layer was extracted, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$WORK_DIR/file" ]; then
+    echo "expected the runtime not to be run for the tampered image"
+    exit 1
+fi
+if [ -n "$(ls -A "$WORK_DIR/tmp")" ]; then
+    echo "expected the working directory of the tampered image to be removed:"
+    ls -A "$WORK_DIR/tmp"
+    exit 1
+fi
+
+if "$BINARY" --interactive - "$IMAGE_SHA256" 'true' < "$WORK_DIR/image.tar.gz" 2>/dev/null; then
+    echo "expected reading the image from stdin with --interactive to be rejected"
+    exit 1
+fi
@@ -0,0 +1,95 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image whose files have custom owners, an old modification
+# time, and an extended attribute
+mkdir "$WORK_DIR/image"
+python3 - "$WORK_DIR" <<'PY'
+import hashlib, io, json, sys, tarfile
+work_dir = sys.argv[1]
+layer = io.BytesIO()
+with tarfile.open(fileobj=layer, mode="w", format=tarfile.PAX_FORMAT) as tar:
+    def add(name, data=None):
+        info = tarfile.TarInfo(name)
+        info.uid, info.gid = 1234, 5678
+        info.uname, info.gname = "builder", "builders"
+        info.mtime = 1000000000
+        info.pax_headers = {"SCHILY.xattr.user.note": "hello"}
+        if data is None:
+            info.type, info.mode = tarfile.DIRTYPE, 0o755
+            tar.addfile(info)
+        else:
+            info.size, info.mode = len(data), 0o644
+            tar.addfile(info, io.BytesIO(data))
+    add("data")
+    add("data/unchanged", b"unchanged\n")
+    add("data/changed", b"changed\n")
+diff_id = hashlib.sha256(layer.getvalue()).hexdigest()
+with open(work_dir + "/image/layer.tar", "wb") as f:
+    f.write(layer.getvalue())
+with open(work_dir + "/image/config.json", "w") as f:
+    json.dump({"os": "linux", "rootfs": {"type": "layers", "diff_ids": ["sha256:" + diff_id]}}, f)
+with open(work_dir + "/image/manifest.json", "w") as f:
+    json.dump([{"Config": "config.json", "Layers": ["layer.tar"]}], f)
+PY
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json config.json layer.tar
+
+# stub runtime which changes one of the files
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo "changed by the command" > rootfs/data/changed
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# print_headers <image> prints the headers of the data files in the output layer
+print_headers() {
+    rm -rf "$WORK_DIR/output"
+    mkdir "$WORK_DIR/output"
+    tar -xzmf "$1" -C "$WORK_DIR/output"
+    python3 - "$WORK_DIR/output" <<'PY'
+import json, sys, tarfile
+output = sys.argv[1]
+layer = json.load(open(output + "/manifest.json"))[0]["Layers"][0]
+with tarfile.open(output + "/" + layer) as tar:
+    for info in tar:
+        if info.name.startswith("data/"):
+            print(info.name, info.uid, info.gid, info.uname, info.gname, int(info.mtime), info.pax_headers.get("SCHILY.xattr.user.note", "-"))
+PY
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --preserve-headers --output "$WORK_DIR/preserved.tar.gz" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+print_headers "$WORK_DIR/preserved.tar.gz" > "$WORK_DIR/headers"
+if ! grep -qx "data/unchanged 1234 5678 builder builders 1000000000 hello" "$WORK_DIR/headers"; then
+    echo "expected the unchanged file to keep its original header, got:"
+    cat "$WORK_DIR/headers"
+    exit 1
+fi
+if grep -q "^data/changed .* 1000000000 hello$" "$WORK_DIR/headers"; then
+    echo "expected the changed file not to get its original header back, got:"
+    cat "$WORK_DIR/headers"
+    exit 1
+fi
+
+# without --preserve-headers, the headers are built from the extracted files
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/rebuilt.tar.gz" "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+print_headers "$WORK_DIR/rebuilt.tar.gz" > "$WORK_DIR/headers"
+if grep -q "^data/unchanged .* builder builders 1000000000 hello$" "$WORK_DIR/headers"; then
+    echo "expected the header of the unchanged file to be rebuilt, got:"
+    cat "$WORK_DIR/headers"
+    exit 1
+fi
+
+if "$BINARY" --preserve-headers "$WORK_DIR/image.tar.gz" skip-sha256-validation 'true' 2>/dev/null; then
+    echo "expected --preserve-headers without --output to be rejected"
+    exit 1
+fi
@@ -0,0 +1,43 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the process args as a JSON array
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+print(json.dumps(json.load(open("config.json"))["process"]["args"]))
+' > "$WORK_DIR/args"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-wrapper '/sbin/tini --' "$ALPINE" "$ALPINE_SHA256" 'echo hello'
+if [ "$(cat "$WORK_DIR/args")" != '["/sbin/tini", "--", "sh", "-c", "echo hello"]' ]; then
+    echo "expected the wrapper to precede the shell, got: $(cat "$WORK_DIR/args")"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-wrapper 'chpst -u nobody' --exec "$ALPINE" "$ALPINE_SHA256" -- echo 'hello world'
+if [ "$(cat "$WORK_DIR/args")" != '["chpst", "-u", "nobody", "echo", "hello world"]' ]; then
+    echo "expected the wrapper to precede the command, got: $(cat "$WORK_DIR/args")"
+    exit 1
+fi
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --entrypoint-wrapper ' ' "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected an empty --entrypoint-wrapper to be rejected"
+    exit 1
+fi
@@ -0,0 +1,43 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# build a single layer image containing two identical files, and a third which differs
+mkdir -p "$WORK_DIR/layer/data" "$WORK_DIR/image"
+echo "identical contents" > "$WORK_DIR/layer/data/a"
+echo "identical contents" > "$WORK_DIR/layer/data/b"
+echo "different contents" > "$WORK_DIR/layer/data/c"
+tar -czf "$WORK_DIR/image/layer.tar.gz" -C "$WORK_DIR/layer" .
+echo '[{"Layers":["layer.tar.gz"]}]' > "$WORK_DIR/image/manifest.json"
+tar -czf "$WORK_DIR/image.tar.gz" -C "$WORK_DIR/image" manifest.json layer.tar.gz
+IMAGE_SHA256=$(gzip -dc "$WORK_DIR/image.tar.gz" | sha256sum | cut -d ' ' -f 1)
+
+# stub runtime which records the inode numbers of the extracted files
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+stat -c %i rootfs/data/a rootfs/data/b rootfs/data/c > "$WORK_DIR/inodes"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --dedup "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+
+A=$(sed -n 1p "$WORK_DIR/inodes")
+B=$(sed -n 2p "$WORK_DIR/inodes")
+C=$(sed -n 3p "$WORK_DIR/inodes")
+if [ "$A" != "$B" ]; then
+    echo "identical files were not deduplicated: $A != $B"
+    exit 1
+fi
+if [ "$A" = "$C" ]; then
+    echo "different files share an inode"
+    exit 1
+fi
@@ -0,0 +1,85 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# make_image <image> <user> builds an image whose config runs as the given user,
+# with a rootfs where alice belongs to several groups
+make_image() {
+    rm -rf "$WORK_DIR/image"
+    mkdir "$WORK_DIR/image"
+    python3 - "$WORK_DIR/image" "$2" <<'PY'
+import hashlib, io, json, sys, tarfile
+image_dir, user = sys.argv[1], sys.argv[2]
+files = {
+    "etc/passwd": "root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000:alice:/home/alice:/bin/sh\n",
+    "etc/group": "root:x:0:root\nalice:x:1000:\nwheel:x:10:root,alice\naudio:x:29:alice\nvideo:x:44:bob\nstaff:x:50:bob,alice\n",
+}
+layer = io.BytesIO()
+with tarfile.open(fileobj=layer, mode="w") as tar:
+    info = tarfile.TarInfo("etc")
+    info.type, info.mode = tarfile.DIRTYPE, 0o755
+    tar.addfile(info)
+    for name, data in files.items():
+        info = tarfile.TarInfo(name)
+        info.size, info.mode = len(data), 0o644
+        tar.addfile(info, io.BytesIO(data.encode()))
+with open(image_dir + "/layer.tar", "wb") as f:
+    f.write(layer.getvalue())
+diff_id = hashlib.sha256(layer.getvalue()).hexdigest()
+with open(image_dir + "/config.json", "w") as f:
+    json.dump({"os": "linux", "config": {"User": user}, "rootfs": {"type": "layers", "diff_ids": ["sha256:" + diff_id]}}, f)
+with open(image_dir + "/manifest.json", "w") as f:
+    json.dump([{"Config": "config.json", "Layers": ["layer.tar"]}], f)
+PY
+    tar -czf "$1" -C "$WORK_DIR/image" manifest.json config.json layer.tar
+}
+
+# stub runtime which records the process user
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+user = json.load(open("config.json"))["process"]["user"]
+print(user["uid"], user["gid"], ",".join(str(gid) for gid in user.get("additionalGids", [])))
+' > "$WORK_DIR/user"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+make_image "$WORK_DIR/alice.tar.gz" alice
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/alice.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/user")" != "1000 1000 0,10,29,50" ]; then
+    echo "expected alice to be given her supplementary groups, got: $(cat "$WORK_DIR/user")"
+    exit 1
+fi
+
+# the groups are merged with --group-add, without repeating any
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --group-add 29 --group-add 7 "$WORK_DIR/alice.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/user")" != "1000 1000 0,29,7,10,50" ]; then
+    echo "expected the groups to be merged with --group-add, got: $(cat "$WORK_DIR/user")"
+    exit 1
+fi
+
+# the primary group is not repeated as a supplementary group
+make_image "$WORK_DIR/staff.tar.gz" 1000:staff
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/staff.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/user")" != "1000 50 0,10,29" ]; then
+    echo "expected the primary group to be left out, got: $(cat "$WORK_DIR/user")"
+    exit 1
+fi
+
+# a uid without a passwd entry belongs to no groups from /etc/group
+make_image "$WORK_DIR/uid.tar.gz" 2000
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/uid.tar.gz" skip-sha256-validation 'true' 2>/dev/null
+if [ "$(cat "$WORK_DIR/user")" != "2000 0 0" ]; then
+    echo "expected no supplementary groups, got: $(cat "$WORK_DIR/user")"
+    exit 1
+fi
@@ -0,0 +1,99 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir -p "$WORK_DIR/rootfs/etc"
+echo "hello" > "$WORK_DIR/rootfs/etc/greeting"
+
+# stub runtime which leaves the rootfs as it is
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+OUTPUT="$WORK_DIR/out/image.tar.gz"
+mkdir "$WORK_DIR/out"
+
+# interrupt the first run while it writes the image, by making its temporary file
+# impossible to create
+mkdir "$OUTPUT.partial"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true' 2>"$WORK_DIR/log"; then
+    echo "expected writing the image to fail"
+    exit 1
+fi
+rmdir "$OUTPUT.partial"
+if [ -e "$OUTPUT" ]; then
+    echo "expected no output image after the interrupted run"
+    exit 1
+fi
+if [ ! -f "$OUTPUT.resume/layer.json" ]; then
+    echo "expected the interrupted run to leave its layer to resume from"
+    exit 1
+fi
+
+# the identical second run skips creating (and hashing) the layer
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true' 2>"$WORK_DIR/log"
+if ! grep -q "reusing the output layer of an earlier run" "$WORK_DIR/log"; then
+    echo "expected the second run to reuse the layer, got:"
+    cat "$WORK_DIR/log"
+    exit 1
+fi
+for leftover in "$OUTPUT.partial" "$OUTPUT.resume"; do
+    if [ -e "$leftover" ]; then
+        echo "expected $leftover to be removed once the image was written"
+        exit 1
+    fi
+done
+
+# the resumed image is the same as one written without interruption
+mkdir "$WORK_DIR/fresh"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --squash --rootfs "$WORK_DIR/rootfs" --output "$WORK_DIR/fresh/image.tar.gz" 'true'
+if ! cmp -s "$OUTPUT" "$WORK_DIR/fresh/image.tar.gz"; then
+    echo "expected the resumed image to match a freshly written one"
+    exit 1
+fi
+
+# the layer really is reused rather than recreated: swap the kept layer for one
+# compressed differently, which has the same DiffID but different bytes, and the
+# resumed image must contain exactly those bytes
+mkdir "$OUTPUT.partial"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true' 2>/dev/null || true
+rmdir "$OUTPUT.partial"
+LAYER=$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))["layer"])' "$OUTPUT.resume/layer.json")
+gzip -dc "$OUTPUT.resume/$LAYER" | gzip -1 -n > "$WORK_DIR/recompressed.tar.gz"
+if cmp -s "$OUTPUT.resume/$LAYER" "$WORK_DIR/recompressed.tar.gz"; then
+    echo "expected recompressing the layer to change its bytes"
+    exit 1
+fi
+cp "$WORK_DIR/recompressed.tar.gz" "$OUTPUT.resume/$LAYER"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true'
+mkdir "$WORK_DIR/reused"
+tar -xzmf "$OUTPUT" -C "$WORK_DIR/reused"
+if ! cmp -s "$WORK_DIR/reused/$LAYER" "$WORK_DIR/recompressed.tar.gz"; then
+    echo "expected the resumed image to contain the kept layer"
+    exit 1
+fi
+
+# a layer left behind is not reused once the rootfs changed
+mkdir "$OUTPUT.partial"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true' 2>/dev/null || true
+rmdir "$OUTPUT.partial"
+echo "goodbye" > "$WORK_DIR/rootfs/etc/greeting"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" -v --squash --rootfs "$WORK_DIR/rootfs" --output "$OUTPUT" 'true' 2>"$WORK_DIR/log"
+if grep -q "reusing the output layer" "$WORK_DIR/log"; then
+    echo "expected the layer of the unchanged rootfs not to be reused"
+    exit 1
+fi
+mkdir "$WORK_DIR/extracted"
+tar -xzmf "$OUTPUT" -C "$WORK_DIR/extracted"
+if [ "$(tar -xzOf "$WORK_DIR"/extracted/*.tar.gz etc/greeting)" != "goodbye" ]; then
+    echo "expected the output layer to hold the changed rootfs"
+    exit 1
+fi
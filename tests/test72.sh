@@ -0,0 +1,75 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records fields of the generated config
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json
+config = json.load(open("config.json"))
+print(config.get("hostname"))
+print(config.get("annotations", {}).get("org.example.base", "-"))
+print(config["root"]["path"])
+print(config["process"]["cwd"])
+print(" ".join(config["process"]["args"]))
+' > "$WORK_DIR/config"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+cat > "$WORK_DIR/base.json" <<'JSON'
+{
+  "ociVersion": "1.0.2",
+  "process": {
+    "user": {"uid": 0, "gid": 0},
+    "args": ["sh"],
+    "cwd": "/"
+  },
+  "root": {"path": "somewhere-else"},
+  "hostname": "custom",
+  "annotations": {"org.example.base": "yes"},
+  "linux": {"namespaces": [{"type": "pid"}, {"type": "mount"}]}
+}
+JSON
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --base-config "$WORK_DIR/base.json" --cwd /tmp "$ALPINE" "$ALPINE_SHA256" 'echo hello'
+expected="custom
+yes
+rootfs
+/tmp
+sh -c echo hello"
+if [ "$(cat "$WORK_DIR/config")" != "$expected" ]; then
+    echo "expected the flags to be applied on top of the base config, got:"
+    cat "$WORK_DIR/config"
+    exit 1
+fi
+
+# without it, the built-in template is used
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'echo hello'
+if [ "$(head -n 2 "$WORK_DIR/config")" != "runc
+-" ]; then
+    echo "expected the built-in template, got:"
+    cat "$WORK_DIR/config"
+    exit 1
+fi
+
+echo '[]' > "$WORK_DIR/array.json"
+for config in "$WORK_DIR/array.json" "$WORK_DIR/missing.json"; do
+    if "$BINARY" --base-config "$config" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+        echo "expected --base-config $config to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,83 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# fake CNI plugin which records how it was run
+mkdir "$WORK_DIR/cni"
+cat > "$WORK_DIR/cni/fakenet" <<STUB
+#!/bin/sh
+{
+    echo "command=\$CNI_COMMAND"
+    echo "containerid=\$CNI_CONTAINERID"
+    echo "netns=\${CNI_NETNS:-none}"
+    echo "ifname=\$CNI_IFNAME"
+    echo "path=\$CNI_PATH"
+    echo "config=\$(cat)"
+} > "$WORK_DIR/plugin-\$CNI_COMMAND"
+echo '{}'
+STUB
+chmod +x "$WORK_DIR/cni/fakenet"
+echo '{"cniVersion": "1.0.0", "name": "testnet", "type": "fakenet"}' > "$WORK_DIR/net.json"
+
+# stub runtime which records the network namespace and runs the prestart and
+# poststop hooks as runc does, passing them the container's state; its own pid
+# stands in for the container's process
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+echo \$\$ > "$WORK_DIR/pid"
+python3 -c '
+import json, subprocess, sys
+config = json.load(open("config.json"))
+print(json.dumps([ns for ns in config["linux"]["namespaces"] if ns["type"] == "network"]))
+for stage, pid in (("prestart", int(sys.argv[1])), ("poststop", 0)):
+    for hook in config["hooks"].get(stage, []):
+        state = {"ociVersion": "1.0.2", "id": "test-container", "status": "created", "pid": pid, "bundle": "."}
+        subprocess.run(hook["args"], executable=hook["path"], input=json.dumps(state).encode(), check=True)
+' \$\$ > "$WORK_DIR/netns"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+CNI_PATH="$WORK_DIR/cni" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cni "$WORK_DIR/net.json" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(cat "$WORK_DIR/netns")" != '[{"type": "network"}]' ]; then
+    echo "expected a new network namespace, got: $(cat "$WORK_DIR/netns")"
+    exit 1
+fi
+expected="command=ADD
+containerid=test-container
+netns=/proc/$(cat "$WORK_DIR/pid")/ns/net
+ifname=eth0
+path=$WORK_DIR/cni
+config=$(cat "$WORK_DIR/net.json")"
+if [ "$(cat "$WORK_DIR/plugin-ADD")" != "$expected" ]; then
+    echo "expected the prestart hook to add the container's netns, got:"
+    cat "$WORK_DIR/plugin-ADD"
+    exit 1
+fi
+if ! grep -qx "command=DEL" "$WORK_DIR/plugin-DEL" || ! grep -qx "containerid=test-container" "$WORK_DIR/plugin-DEL"; then
+    echo "expected the poststop hook to delete the container's network, got:"
+    cat "$WORK_DIR/plugin-DEL"
+    exit 1
+fi
+
+if CNI_PATH="$WORK_DIR/cni" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cni "$WORK_DIR/net.json" --network=host "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --cni to be rejected with --network=host"
+    exit 1
+fi
+echo '{"cniVersion": "1.0.0", "name": "testnet", "type": "missing"}' > "$WORK_DIR/missing.json"
+if CNI_PATH="$WORK_DIR/cni" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --cni "$WORK_DIR/missing.json" "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected --cni with a missing plugin to be rejected"
+    exit 1
+fi
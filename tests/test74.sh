@@ -0,0 +1,123 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+OUTSIDE="$WORK_DIR/outside"
+mkdir "$OUTSIDE"
+echo "original" > "$OUTSIDE/target"
+
+# mkimage.py <image> <layer>... builds an image whose layers are described by
+# lines of "<type> <name> [<link or contents>]", printing the sum to run it with
+cat > "$WORK_DIR/mkimage.py" <<'PY'
+import gzip, hashlib, io, json, sys, tarfile
+
+def build_tar(entries):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w", format=tarfile.PAX_FORMAT) as tf:
+        for kind, name, arg in entries:
+            info = tarfile.TarInfo(name)
+            data = None
+            if kind == "dir":
+                info.type, info.mode = tarfile.DIRTYPE, 0o700
+            elif kind == "file":
+                data = arg.encode()
+                info.size = len(data)
+            elif kind == "symlink":
+                info.type, info.linkname = tarfile.SYMTYPE, arg
+            elif kind == "hardlink":
+                info.type, info.linkname = tarfile.LNKTYPE, arg
+            tf.addfile(info, io.BytesIO(data) if data is not None else None)
+    return buf.getvalue()
+
+layers, diff_ids, files = [], [], []
+for i, spec in enumerate(sys.argv[2:]):
+    entries = []
+    for line in spec.strip().splitlines():
+        parts = line.split(" ", 2)
+        entries.append((parts[0], parts[1], parts[2] if len(parts) > 2 else ""))
+    layer = build_tar(entries)
+    diff_ids.append("sha256:" + hashlib.sha256(layer).hexdigest())
+    files.append(("layer%d.tar.gz" % i, gzip.compress(layer, mtime=0)))
+    layers.append("layer%d.tar.gz" % i)
+config = json.dumps({"os": "linux", "rootfs": {"type": "layers", "diff_ids": diff_ids}}).encode()
+manifest = json.dumps([{"Config": "config.json", "Layers": layers}]).encode()
+files = [("manifest.json", manifest), ("config.json", config)] + files
+image = io.BytesIO()
+with tarfile.open(fileobj=image, mode="w") as tf:
+    for name, data in files:
+        info = tarfile.TarInfo(name)
+        info.size = len(data)
+        tf.addfile(info, io.BytesIO(data))
+with open(sys.argv[1], "wb") as f:
+    f.write(gzip.compress(image.getvalue(), mtime=0))
+print(hashlib.sha256(image.getvalue()).hexdigest())
+PY
+
+# stub runtime which records that it ran
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# expect_rejected <description> <layer>... checks the image is refused without
+# anything outside of the rootfs being written
+expect_rejected() {
+    description="$1"
+    shift
+    rm -f "$WORK_DIR/ran"
+    sha=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/image.tar.gz" "$@")
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$sha" 'true' 2>"$WORK_DIR/stderr"; then
+        echo "expected $description to be rejected"
+        exit 1
+    fi
+    if [ -e "$WORK_DIR/ran" ]; then
+        echo "expected the runtime not to be run for $description"
+        exit 1
+    fi
+    if [ "$(cat "$OUTSIDE/target")" != "original" ] || [ "$(ls "$OUTSIDE")" != "target" ]; then
+        echo "expected $description not to write outside of the rootfs:"
+        ls -l "$OUTSIDE"
+        exit 1
+    fi
+}
+
+expect_rejected "an entry above the rootfs" "file ../../../../../../$OUTSIDE/pwned pwned"
+expect_rejected "an absolute entry" "file $OUTSIDE/pwned pwned"
+expect_rejected "a hard link to a file above the rootfs" "hardlink stolen ../../../../../../$OUTSIDE/target"
+expect_rejected "an entry beneath a symlink of the same layer" "symlink escape $OUTSIDE
+file escape/pwned pwned"
+expect_rejected "an entry beneath a symlink of a lower layer" "symlink escape $OUTSIDE" "file escape/pwned pwned"
+expect_rejected "a directory beneath a symlink of a lower layer" "symlink escape $OUTSIDE" "dir escape/sub"
+expect_rejected "a whiteout beneath a symlink of a lower layer" "symlink escape $OUTSIDE" "file escape/.wh.target"
+expect_rejected "an opaque whiteout beneath a symlink of a lower layer" "symlink escape $OUTSIDE" "file escape/sub/.wh..wh..opq"
+
+# a regular file replacing a symlink of a lower layer replaces the symlink, rather
+# than writing to what it points to, as does a directory whose mode is then set
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cat rootfs/replaced > "$WORK_DIR/replaced"
+stat -c %F rootfs/dir > "$WORK_DIR/dir"
+STUB
+chmod 0755 "$OUTSIDE"
+sha=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/image.tar.gz" "symlink replaced $OUTSIDE/target
+symlink dir $OUTSIDE" "file replaced upper
+dir dir")
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$sha" 'true'
+if [ "$(cat "$WORK_DIR/replaced")" != "upper" ] || [ "$(cat "$OUTSIDE/target")" != "original" ]; then
+    echo "expected the regular file to replace the symlink"
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR/dir")" != "directory" ] || [ "$(stat -c %a "$OUTSIDE")" != "755" ]; then
+    echo "expected the directory to replace the symlink"
+    exit 1
+fi
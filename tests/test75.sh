@@ -0,0 +1,54 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+OUTSIDE="$WORK_DIR/outside"
+mkdir "$OUTSIDE"
+mkdir -p "$WORK_DIR/rootfs/srv"
+# an absolute symlink, which points into the rootfs inside the container but
+# outside of it on the host
+ln -s "$OUTSIDE" "$WORK_DIR/rootfs/work"
+
+# stub runtime which records the working directory it was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.load(open("config.json"))["process"]["cwd"])' > "$WORK_DIR/cwd"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --cwd /srv/app 'true' 2>"$WORK_DIR/stderr"; then
+    echo "expected a missing working directory to be rejected without --cwd-create"
+    exit 1
+fi
+if ! grep -q "working directory /srv/app does not exist" "$WORK_DIR/stderr"; then
+    echo "expected an error about the missing working directory, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --cwd /srv/app --cwd-create 'true'
+if [ "$(cat "$WORK_DIR/cwd")" != "/srv/app" ] || [ ! -d "$WORK_DIR/rootfs/srv/app" ]; then
+    echo "expected the working directory to be created"
+    exit 1
+fi
+
+# the working directory is created beneath the symlink's target within the rootfs
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --cwd /work/sub --cwd-create 'true'
+if [ -n "$(ls -A "$OUTSIDE")" ]; then
+    echo "expected nothing to be created outside of the rootfs:"
+    ls -l "$OUTSIDE"
+    exit 1
+fi
+if [ ! -d "$WORK_DIR/rootfs/$OUTSIDE/sub" ]; then
+    echo "expected the working directory to be created within the rootfs"
+    exit 1
+fi
@@ -0,0 +1,48 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir "$WORK_DIR/rootfs"
+
+# stub runtime which records the process's oomScoreAdj, or "unset"
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(json.load(open("config.json"))["process"].get("oomScoreAdj", "unset"))' > "$WORK_DIR/oom"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for adj in -1000 0 500 1000; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --oom-score-adj "$adj" 'true'
+    if [ "$(cat "$WORK_DIR/oom")" != "$adj" ]; then
+        echo "expected oomScoreAdj to be $adj, got $(cat "$WORK_DIR/oom")"
+        exit 1
+    fi
+done
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" 'true'
+if [ "$(cat "$WORK_DIR/oom")" != "unset" ]; then
+    echo "expected oomScoreAdj not to be set without --oom-score-adj, got $(cat "$WORK_DIR/oom")"
+    exit 1
+fi
+
+for adj in -1001 1001; do
+    rm -f "$WORK_DIR/oom"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" --oom-score-adj "$adj" 'true' 2>"$WORK_DIR/stderr"; then
+        echo "expected --oom-score-adj $adj to be rejected"
+        exit 1
+    fi
+    if ! grep -q "must be between -1000 and 1000; got $adj" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/oom" ]; then
+        echo "expected an out of range error without running the container, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
@@ -0,0 +1,44 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir "$WORK_DIR/rootfs"
+
+# stub runtime which records that it ran
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for command in '' '   '; do
+    rm -f "$WORK_DIR/ran"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" "$command" 2>"$WORK_DIR/stderr"; then
+        echo "expected the empty command '$command' to be rejected"
+        exit 1
+    fi
+    if ! grep -q "command must not be empty" "$WORK_DIR/stderr"; then
+        echo "expected an empty command error, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+    if [ -e "$WORK_DIR/ran" ]; then
+        echo "expected the runtime not to be run for an empty command"
+        exit 1
+    fi
+done
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --rootfs "$WORK_DIR/rootfs" 'true'
+if [ ! -e "$WORK_DIR/ran" ]; then
+    echo "expected a non-empty command to be run"
+    exit 1
+fi
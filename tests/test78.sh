@@ -0,0 +1,98 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir "$WORK_DIR/rootfs"
+
+# use tini when it is installed, and otherwise a minimal init which, like tini,
+# runs the command after "--" and reaps every child until it exits
+INIT=$(command -v tini || true)
+if [ -z "$INIT" ]; then
+    if ! command -v cc >/dev/null; then
+        echo "neither tini nor a C compiler is installed; skipping"
+        exit 0
+    fi
+    cat > "$WORK_DIR/init.c" <<'C'
+#include <string.h>
+#include <sys/prctl.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+int main(int argc, char **argv) {
+    int i = 1;
+    if (i < argc && strcmp(argv[i], "-s") == 0) {
+        prctl(PR_SET_CHILD_SUBREAPER, 1);
+        i++;
+    }
+    if (i < argc && strcmp(argv[i], "--") == 0) {
+        i++;
+    }
+    pid_t child = fork();
+    if (child == 0) {
+        execvp(argv[i], argv + i);
+        _exit(127);
+    }
+    for (;;) {
+        int status;
+        pid_t pid = wait(&status);
+        if (pid == child) {
+            return WIFEXITED(status) ? WEXITSTATUS(status) : 128 + WTERMSIG(status);
+        }
+        if (pid < 0) {
+            return 1;
+        }
+    }
+}
+C
+    INIT="$WORK_DIR/init"
+    cc -static -o "$INIT" "$WORK_DIR/init.c"
+fi
+
+# stub runtime which runs the process as PID 1 of a new pid namespace on the host,
+# with the init at the source of its /dev/init mount
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c '
+import json, os, subprocess, sys
+config = json.load(open("config.json"))
+sources = {m["destination"]: m.get("source") for m in config["mounts"]}
+args = [sources.get(arg, arg) for arg in config["process"]["args"]]
+out = open(sys.argv[1], "w")
+sys.exit(subprocess.call(["unshare", "--pid", "--fork", "--mount-proc"] + args, stdout=out))
+' "$WORK_DIR/out"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the backgrounded sleep is orphaned when its subshell exits, and so reparented
+# to PID 1, which must reap it once it exits for no zombie to remain
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --init --init-path "$INIT" --rootfs "$WORK_DIR/rootfs" \
+    '(sleep 0.2 &); sleep 1; grep -l "^State:.*Z" /proc/[0-9]*/status | wc -l; cat /proc/1/cmdline | tr "\0" " "'
+if [ "$(head -n 1 "$WORK_DIR/out")" != "0" ]; then
+    echo "expected no zombies to remain with --init, got:"
+    cat "$WORK_DIR/out"
+    exit 1
+fi
+if ! tail -n 1 "$WORK_DIR/out" | grep -q "^$INIT -- sh -c "; then
+    echo "expected the init to run as PID 1, got: $(tail -n 1 "$WORK_DIR/out")"
+    exit 1
+fi
+
+# a dynamically linked init can not run inside an arbitrary rootfs
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --init --init-path /bin/sh --rootfs "$WORK_DIR/rootfs" 'true' 2>"$WORK_DIR/stderr"; then
+    echo "expected a dynamically linked init to be rejected"
+    exit 1
+fi
+if ! grep -q "is not statically linked" "$WORK_DIR/stderr"; then
+    echo "expected an error about the init not being statically linked, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
@@ -0,0 +1,55 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which leaves behind files, some of which are to be excluded
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+mkdir -p rootfs/src/.git/objects rootfs/src/lib/.git rootfs/root/.ssh rootfs/srv/root/.ssh
+echo ref > rootfs/src/.git/HEAD
+echo ref > rootfs/src/lib/.git/HEAD
+echo code > rootfs/src/main.c
+echo key > rootfs/root/.ssh/id_rsa
+echo kept > rootfs/root/.sshrc
+echo kept > rootfs/srv/root/.ssh/config
+echo log > rootfs/src/build.log
+echo log > rootfs/build.log
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" \
+    --output-exclude '**/.git' --output-exclude '/root/.ssh' --output-exclude '*.log' \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+
+mkdir "$WORK_DIR/out"
+tar -xzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+LAYER=$(ls "$WORK_DIR/out"/*.tar.gz)
+tar -tzf "$LAYER" > "$WORK_DIR/entries"
+
+for excluded in src/.git src/lib/.git root/.ssh build.log src/build.log; do
+    if grep -q "^$excluded\(/\|$\)" "$WORK_DIR/entries"; then
+        echo "expected $excluded to be excluded, got:"
+        grep "^$excluded" "$WORK_DIR/entries"
+        exit 1
+    fi
+done
+# anchored patterns only match at the root, and names must match in full
+for kept in src/main.c src/lib/ root/.sshrc srv/root/.ssh/config bin/busybox; do
+    if ! grep -q "^$kept$" "$WORK_DIR/entries"; then
+        echo "expected $kept to be archived"
+        exit 1
+    fi
+done
@@ -0,0 +1,30 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which does nothing, so that only acbrun's own phases are timed
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --summary-json "$WORK_DIR/summary.json" --output "$WORK_DIR/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+
+for phase in extract config run output; do
+    if ! grep -q "\"name\":\"$phase\",\"duration_ns\":[1-9]" "$WORK_DIR/summary.json"; then
+        echo "summary is missing a nonzero duration for the $phase phase:"
+        cat "$WORK_DIR/summary.json"
+        exit 1
+    fi
+done
@@ -0,0 +1,63 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which leaves behind empty directories
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+mkdir -p rootfs/empty/nested/deeper rootfs/srv/data
+chmod 0700 rootfs/srv/data
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+
+mkdir "$WORK_DIR/out"
+tar -xzf "$WORK_DIR/out.tar.gz" -C "$WORK_DIR/out"
+LAYER=$(ls "$WORK_DIR/out"/*.tar.gz)
+tar -tzf "$LAYER" > "$WORK_DIR/entries"
+
+# directories are archived with a trailing "/", as tar consumers expect
+for dir in empty/ empty/nested/ empty/nested/deeper/ srv/data/; do
+    if ! grep -q "^$dir$" "$WORK_DIR/entries"; then
+        echo "expected the directory entry $dir, got:"
+        grep "^empty\|^srv" "$WORK_DIR/entries"
+        exit 1
+    fi
+done
+
+# the empty directories are recreated by tar...
+mkdir "$WORK_DIR/extracted"
+tar -xzf "$LAYER" -C "$WORK_DIR/extracted"
+if [ ! -d "$WORK_DIR/extracted/empty/nested/deeper" ] || [ "$(stat -c %a "$WORK_DIR/extracted/srv/data")" != "700" ]; then
+    echo "expected tar to recreate the empty directories"
+    exit 1
+fi
+
+# ...and by running the output image
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+find rootfs/empty rootfs/srv/data -type d | sort > "$WORK_DIR/dirs"
+stat -c %a rootfs/srv/data >> "$WORK_DIR/dirs"
+STUB
+OUT_SHA256=$(gzip -dc "$WORK_DIR/out.tar.gz" | sha256sum | cut -d ' ' -f 1)
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/out.tar.gz" "$OUT_SHA256" 'true'
+printf 'rootfs/empty\nrootfs/empty/nested\nrootfs/empty/nested/deeper\nrootfs/srv/data\n700\n' > "$WORK_DIR/expected"
+if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/dirs"; then
+    echo "expected the empty directories to round-trip, got:"
+    cat "$WORK_DIR/dirs"
+    exit 1
+fi
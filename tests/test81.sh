@@ -0,0 +1,71 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+NAME="acbrun-test81-$$"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" "/tmp/acbrun-$NAME" "/tmp/acbrun-$NAME.lock"' EXIT
+
+# stub runtime whose detached start succeeds, but whose container dies straight
+# away, logging why
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+STATE="$WORK_DIR/state"
+if [ "\$1" = "--log" ]; then
+    LOG="\$2"
+    shift 2
+fi
+case "\$1" in
+run)
+    echo "container init exited: exec format error" >> "\$LOG"
+    echo stopped > "\$STATE"
+    ;;
+state)
+    if [ ! -e "\$STATE" ]; then
+        echo '"container does not exist"' >&2
+        exit 1
+    fi
+    printf '{"status": "%s", "pid": 0}\n' "\$(cat "\$STATE")"
+    ;;
+exec)
+    echo "\$*" >> "$WORK_DIR/execs"
+    ;;
+delete)
+    rm -f "\$STATE"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+status=0
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" \
+    "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr" || status=$?
+if [ "$status" = "0" ]; then
+    echo "expected acbrun to fail when the container dies after starting"
+    exit 1
+fi
+if ! grep -q "^error: container $NAME stopped immediately after starting" "$WORK_DIR/stderr"; then
+    echo "expected an error about the container stopping, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if ! grep -q "container init exited: exec format error" "$WORK_DIR/stderr"; then
+    echo "expected the runc log to be reported, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$WORK_DIR/execs" ]; then
+    echo "expected the command not to be exec'd in the dead container, got:"
+    cat "$WORK_DIR/execs"
+    exit 1
+fi
@@ -0,0 +1,98 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+NAME="acbrun-test82-$$"
+STATE_DIR="/tmp/acbrun-$NAME"
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR" "$STATE_DIR" "$STATE_DIR.lock"' EXIT
+
+# stub runtime whose detached containers keep running, and whose exec runs the
+# command against the rootfs on the host
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+STATE="$WORK_DIR/state"
+if [ "\$1" = "--log" ]; then
+    shift 2
+fi
+case "\$1" in
+run)
+    echo running > "\$STATE"
+    ;;
+state)
+    if [ ! -e "\$STATE" ]; then
+        echo '"container does not exist"' >&2
+        exit 1
+    fi
+    printf '{"status": "%s", "pid": 1}\n' "\$(cat "\$STATE")"
+    ;;
+exec)
+    test -x rootfs/bin/busybox && echo ok >> "$WORK_DIR/execs"
+    ;;
+delete)
+    rm -f "\$STATE"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# invocations racing to create the same container extract its image only once
+for i in 1 2 3 4; do
+    (
+        status=0
+        PATH="$WORK_DIR/bin:$PATH" "$BINARY" --verbose --reentrant --name "$NAME" \
+            "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr$i" || status=$?
+        echo "$status" > "$WORK_DIR/status$i"
+    ) &
+done
+wait
+if [ "$(cat "$WORK_DIR"/status*)" != "0
+0
+0
+0" ]; then
+    echo "expected every invocation to succeed, got:"
+    cat "$WORK_DIR"/stderr*
+    exit 1
+fi
+if [ "$(cat "$WORK_DIR"/stderr* | grep -c "did not find existing directory")" != "1" ]; then
+    echo "expected exactly one invocation to create the container, got:"
+    cat "$WORK_DIR"/stderr*
+    exit 1
+fi
+if [ "$(grep -c ok "$WORK_DIR/execs")" != "4" ]; then
+    echo "expected each invocation to exec its command in the fully extracted rootfs"
+    cat "$WORK_DIR"/stderr*
+    exit 1
+fi
+if [ ! -e "$STATE_DIR/.acbrun-created" ]; then
+    echo "expected the working directory to be marked as created"
+    exit 1
+fi
+
+# a working directory left behind by an invocation which died while creating it
+# is not reused
+rm -f "$WORK_DIR/state" "$WORK_DIR/execs"
+rm -rf "$STATE_DIR"
+mkdir -p "$STATE_DIR/rootfs/bin"
+echo partial > "$STATE_DIR/rootfs/partial"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --reentrant --name "$NAME" \
+    "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+if ! grep -q "^WARNING: removing $STATE_DIR, which was not fully created" "$WORK_DIR/stderr"; then
+    echo "expected a warning about the incomplete working directory, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ -e "$STATE_DIR/rootfs/partial" ] || [ "$(cat "$WORK_DIR/execs")" != "ok" ]; then
+    echo "expected the working directory to be created again"
+    exit 1
+fi
@@ -0,0 +1,61 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+if ! command -v zstd >/dev/null; then
+    echo "zstd is not installed; skipping"
+    exit 0
+fi
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# an image whose layers are gzip compressed, zstd compressed, and uncompressed,
+# each of which changes what the layer below it wrote
+mkdir -p "$WORK_DIR/l0/etc" "$WORK_DIR/l1/etc" "$WORK_DIR/l2/etc" "$WORK_DIR/image"
+echo gzip > "$WORK_DIR/l0/etc/base"
+echo gzip > "$WORK_DIR/l0/etc/gzip"
+echo zstd > "$WORK_DIR/l1/etc/base"
+echo zstd > "$WORK_DIR/l1/etc/zstd"
+echo none > "$WORK_DIR/l2/etc/none"
+for i in 0 1 2; do
+    tar -cf "$WORK_DIR/l$i.tar" -C "$WORK_DIR/l$i" etc
+done
+gzip -n -c "$WORK_DIR/l0.tar" > "$WORK_DIR/image/layer0.tar.gz"
+zstd -q -c "$WORK_DIR/l1.tar" > "$WORK_DIR/image/layer1.tar.zst"
+cp "$WORK_DIR/l2.tar" "$WORK_DIR/image/layer2.tar"
+DIFF_IDS=""
+for i in 0 1 2; do
+    DIFF_IDS="$DIFF_IDS${DIFF_IDS:+,}\"sha256:$(sha256sum "$WORK_DIR/l$i.tar" | cut -d ' ' -f 1)\""
+done
+echo "{\"os\":\"linux\",\"rootfs\":{\"type\":\"layers\",\"diff_ids\":[$DIFF_IDS]}}" > "$WORK_DIR/image/config.json"
+echo '[{"Config":"config.json","Layers":["layer0.tar.gz","layer1.tar.zst","layer2.tar"]}]' > "$WORK_DIR/image/manifest.json"
+tar -cf "$WORK_DIR/image.tar" -C "$WORK_DIR/image" manifest.json config.json layer0.tar.gz layer1.tar.zst layer2.tar
+gzip -n -c "$WORK_DIR/image.tar" > "$WORK_DIR/image.tar.gz"
+IMAGE_SHA256=$(sha256sum "$WORK_DIR/image.tar" | cut -d ' ' -f 1)
+
+# stub runtime which records the rootfs's files
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+for f in base gzip zstd none; do
+    echo "\$f: \$(cat rootfs/etc/\$f)"
+done > "$WORK_DIR/files"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+printf 'base: zstd\ngzip: gzip\nzstd: zstd\nnone: none\n' > "$WORK_DIR/expected"
+for flag in "" "--overlay"; do
+    rm -f "$WORK_DIR/files"
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" $flag "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true'
+    if ! cmp -s "$WORK_DIR/expected" "$WORK_DIR/files"; then
+        echo "expected each layer to be decompressed as it was compressed ${flag:+with $flag}, got:"
+        cat "$WORK_DIR/files"
+        exit 1
+    fi
+done
@@ -0,0 +1,50 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records that it ran
+mkdir "$WORK_DIR/bin" "$WORK_DIR/tmp"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# expect_no_leak <description> <error> <acbrun args>... checks acbrun fails with
+# the error without running anything, and removes its working directory as it exits
+expect_no_leak() {
+    description="$1"
+    error="$2"
+    shift 2
+    if PATH="$WORK_DIR/bin:$PATH" TMPDIR="$WORK_DIR/tmp" "$BINARY" "$@" 2>"$WORK_DIR/stderr"; then
+        echo "expected $description to be rejected"
+        exit 1
+    fi
+    if ! grep -q "$error" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/ran" ]; then
+        echo "expected an error for $description without running the container, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+    if [ -n "$(ls -A "$WORK_DIR/tmp")" ]; then
+        echo "expected the working directory to be removed after $description, got:"
+        ls -l "$WORK_DIR/tmp"
+        exit 1
+    fi
+}
+
+expect_no_leak "a missing --ro-bind source" "^error: bind mount source" --ro-bind "$WORK_DIR/missing:/mnt" "$ALPINE" "$ALPINE_SHA256" 'true'
+expect_no_leak "a missing --resolv-conf" "^error: invalid --resolv-conf: bind mount source" --resolv-conf "$WORK_DIR/missing" "$ALPINE" "$ALPINE_SHA256" 'true'
+expect_no_leak "a missing --hostname-file" "^error: invalid --hostname-file: bind mount source" --hostname-file "$WORK_DIR/missing" "$ALPINE" "$ALPINE_SHA256" 'true'
+expect_no_leak "an image whose sum does not match" "^expected sha256 sum .* does not match" "$ALPINE" "0000000000000000000000000000000000000000000000000000000000000000" 'true'
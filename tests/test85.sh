@@ -0,0 +1,59 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+mkdir "$WORK_DIR/rootfs"
+
+# stub runtime which records the namespaces the container is given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+python3 -c 'import json; print(" ".join(ns["type"] for ns in json.load(open("config.json"))["linux"]["namespaces"]))' > "$WORK_DIR/namespaces"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# expect_namespaces <namespaces> <acbrun flags>...
+expect_namespaces() {
+    expected="$1"
+    shift
+    rm -f "$WORK_DIR/namespaces"
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$@" --rootfs "$WORK_DIR/rootfs" 'true'
+    if [ "$(cat "$WORK_DIR/namespaces")" != "$expected" ]; then
+        echo "expected the namespaces \"$expected\" with $*, got \"$(cat "$WORK_DIR/namespaces")\""
+        exit 1
+    fi
+}
+
+expect_namespaces "pid ipc uts mount cgroup network"
+expect_namespaces "pid ipc uts mount cgroup network" --network=none
+expect_namespaces "pid ipc uts mount cgroup" --network=host
+expect_namespaces "pid ipc uts mount cgroup" --host-network
+expect_namespaces "pid ipc uts mount cgroup" --host-network --network=host
+
+# expect_rejected <error> <acbrun flags>...
+expect_rejected() {
+    error="$1"
+    shift
+    rm -f "$WORK_DIR/namespaces"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$@" --rootfs "$WORK_DIR/rootfs" 'true' 2>"$WORK_DIR/stderr"; then
+        echo "expected $* to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: $error" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/namespaces" ]; then
+        echo "expected \"$error\" for $* without running the container, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+}
+
+expect_rejected "--host-network conflicts with --network=none" --host-network --network=none
+expect_rejected "--network=bridge is not yet supported" --network=bridge
+expect_rejected "invalid --network \"isolated\"" --network=isolated
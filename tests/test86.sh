@@ -0,0 +1,63 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records that it ran
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+touch "$WORK_DIR/ran"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the sum is read from the file given as @<path>, in any of the forms it is
+# commonly written in
+printf '  %s\n\n' "$ALPINE_SHA256" > "$WORK_DIR/whitespace"
+echo "sha256:$ALPINE_SHA256" > "$WORK_DIR/prefixed"
+echo "$ALPINE_SHA256  alpine-$ALPINE_VERSION.tar.gz" > "$WORK_DIR/sha256sum"
+for sumFile in whitespace prefixed sha256sum; do
+    rm -f "$WORK_DIR/ran"
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "@$WORK_DIR/$sumFile" 'true'
+    if [ ! -e "$WORK_DIR/ran" ]; then
+        echo "expected the sum in the $sumFile file to be accepted"
+        exit 1
+    fi
+done
+
+# the sum read is checked as one given directly is
+echo "0000000000000000000000000000000000000000000000000000000000000000" > "$WORK_DIR/wrong"
+rm -f "$WORK_DIR/ran"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "@$WORK_DIR/wrong" 'true' 2>"$WORK_DIR/stderr"; then
+    echo "expected a wrong sum read from a file to be rejected"
+    exit 1
+fi
+if ! grep -q "^expected sha256 sum 0000000000000000000000000000000000000000000000000000000000000000 does not match" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/ran" ]; then
+    echo "expected a sum mismatch error, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+for sumFile in missing empty; do
+    : > "$WORK_DIR/empty"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "@$WORK_DIR/$sumFile" 'true' 2>"$WORK_DIR/stderr"; then
+        echo "expected the $sumFile sum file to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: unable to read expected sha256 sum" "$WORK_DIR/stderr"; then
+        echo "expected an error reading the $sumFile sum file, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
@@ -0,0 +1,61 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+# gzip_header <file> prints the name and mtime recorded in a gzip header
+gzip_header() {
+    python3 - "$1" <<'PY'
+import struct, sys
+data = open(sys.argv[1], "rb").read(4096)
+flags, mtime = data[3], struct.unpack("<I", data[4:8])[0]
+offset, name = 10, ""
+if flags & 0x04:
+    offset += 2 + struct.unpack("<H", data[10:12])[0]
+if flags & 0x08:
+    name = data[offset:data.index(b"\0", offset)].decode()
+print("name=%s mtime=%d" % (name, mtime))
+PY
+}
+
+# without --output-gzip-metadata, neither is recorded
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/plain.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(gzip_header "$WORK_DIR/plain.tar.gz")" != "name= mtime=0" ]; then
+    echo "expected an empty gzip header, got: $(gzip_header "$WORK_DIR/plain.tar.gz")"
+    exit 1
+fi
+
+# with it, the output's file name and the time it was written are
+BEFORE=$(date +%s)
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output-gzip-metadata --output "$WORK_DIR/named.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+AFTER=$(date +%s)
+HEADER=$(gzip_header "$WORK_DIR/named.tar.gz")
+MTIME=${HEADER##*mtime=}
+if [ "${HEADER%% *}" != "name=named.tar.gz" ] || [ "$MTIME" -lt "$BEFORE" ] || [ "$MTIME" -gt "$AFTER" ]; then
+    echo "expected the output's name and a time between $BEFORE and $AFTER, got: $HEADER"
+    exit 1
+fi
+
+# ...clamped to SOURCE_DATE_EPOCH
+PATH="$WORK_DIR/bin:$PATH" SOURCE_DATE_EPOCH=1700000000 "$BINARY" --output-gzip-metadata \
+    --output "$WORK_DIR/clamped.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(gzip_header "$WORK_DIR/clamped.tar.gz")" != "name=clamped.tar.gz mtime=1700000000" ]; then
+    echo "expected the mtime to be clamped to SOURCE_DATE_EPOCH, got: $(gzip_header "$WORK_DIR/clamped.tar.gz")"
+    exit 1
+fi
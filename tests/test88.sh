@@ -0,0 +1,100 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# mkimage.py <image> <architecture> [<variant>] builds a single layer image for the
+# platform, printing the sum to run it with
+cat > "$WORK_DIR/mkimage.py" <<'PY'
+import gzip, hashlib, io, json, sys, tarfile
+
+def build_tar(files):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w") as tf:
+        for name, data in files:
+            info = tarfile.TarInfo(name)
+            if data is None:
+                info.type, info.mode = tarfile.DIRTYPE, 0o755
+            else:
+                info.size = len(data)
+            tf.addfile(info, io.BytesIO(data) if data is not None else None)
+    return buf.getvalue()
+
+layer = build_tar([("etc", None), ("etc/hostname", b"arm\n")])
+config = {"os": "linux", "architecture": sys.argv[2],
+          "rootfs": {"type": "layers", "diff_ids": ["sha256:" + hashlib.sha256(layer).hexdigest()]}}
+if len(sys.argv) > 3:
+    config["variant"] = sys.argv[3]
+image = build_tar([
+    ("manifest.json", json.dumps([{"Config": "config.json", "Layers": ["layer.tar.gz"]}]).encode()),
+    ("config.json", json.dumps(config).encode()),
+    ("layer.tar.gz", gzip.compress(layer, mtime=0)),
+])
+with open(sys.argv[1], "wb") as f:
+    f.write(gzip.compress(image, mtime=0))
+print(hashlib.sha256(image).hexdigest())
+PY
+
+# output_platform <image> prints the platform recorded by an output image's config
+output_platform() {
+    python3 - "$1" <<'PY'
+import json, sys, tarfile
+with tarfile.open(sys.argv[1]) as tf:
+    manifest = json.load(tf.extractfile("manifest.json"))
+    config = json.load(tf.extractfile(manifest[0]["Config"]))
+print("%s/%s/%s" % (config["os"], config["architecture"], config.get("variant", "")))
+PY
+}
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+V7_SHA256=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/arm-v7.tar.gz" arm v7)
+ARM_SHA256=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/arm.tar.gz" arm)
+
+# expect_platform <platform> <acbrun args>...
+expect_platform() {
+    expected="$1"
+    shift
+    rm -f "$WORK_DIR/out.tar.gz"
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/out.tar.gz" "$@" 'true'
+    if [ "$(output_platform "$WORK_DIR/out.tar.gz")" != "$expected" ]; then
+        echo "expected the output platform $expected with $*, got $(output_platform "$WORK_DIR/out.tar.gz")"
+        exit 1
+    fi
+}
+
+# the variant selected is recorded in the output, whether it is given or taken
+# from the image's own config
+expect_platform linux/arm/v7 --platform-variant v7 "$WORK_DIR/arm-v7.tar.gz" "$V7_SHA256"
+expect_platform linux/arm/v7 "$WORK_DIR/arm-v7.tar.gz" "$V7_SHA256"
+expect_platform linux/arm/v7 --platform-variant v7 "$WORK_DIR/arm.tar.gz" "$ARM_SHA256"
+expect_platform linux/arm/ "$WORK_DIR/arm.tar.gz" "$ARM_SHA256"
+
+# expect_rejected <error> <acbrun args>...
+expect_rejected() {
+    error="$1"
+    shift
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$@" 'true' 2>"$WORK_DIR/stderr"; then
+        echo "expected $* to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: $error" "$WORK_DIR/stderr"; then
+        echo "expected \"$error\" for $*, got:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+}
+
+expect_rejected "--platform-variant=v6 does not match the image's arm/v7 platform" \
+    --platform-variant v6 "$WORK_DIR/arm-v7.tar.gz" "$V7_SHA256"
+expect_rejected "invalid --platform-variant \"7\"" --platform-variant 7 "$WORK_DIR/arm-v7.tar.gz" "$V7_SHA256"
@@ -0,0 +1,61 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which fails to start the container, recording its bundle's rootfs
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+echo "\$PWD/rootfs" > "$WORK_DIR/rootfs"
+echo "container_linux.go: starting container process caused: exec: \"sh\": executable file not found" >&2
+exit 1
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --dump-bundle "$WORK_DIR/dump" "$ALPINE" "$ALPINE_SHA256" 'true' 2>"$WORK_DIR/stderr"; then
+    echo "expected acbrun to fail when runc does"
+    exit 1
+fi
+if ! grep -q "^dumped bundle to $WORK_DIR/dump" "$WORK_DIR/stderr"; then
+    echo "expected the bundle to be dumped, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+
+# the dumped config points at the rootfs, so that it can be re-run by hand with --keep
+ROOT_PATH=$(python3 -c 'import json, sys; print(json.load(open(sys.argv[1]))["root"]["path"])' "$WORK_DIR/dump/config.json")
+if [ "$ROOT_PATH" != "$(cat "$WORK_DIR/rootfs")" ]; then
+    echo "expected the dumped config's root.path to be $(cat "$WORK_DIR/rootfs"), got $ROOT_PATH"
+    exit 1
+fi
+if ! python3 -c 'import json, sys; assert json.load(open(sys.argv[1]))["process"]["args"] == ["sh", "-c", "true"]' "$WORK_DIR/dump/config.json"; then
+    echo "expected the dumped config to be the one runc was given"
+    exit 1
+fi
+
+# and the listing of the rootfs shows each file's mode, size, and path
+if ! grep -q "^-rwxr-xr-x \+[0-9]\+ bin/busybox$" "$WORK_DIR/dump/rootfs.txt" || ! grep -q "^d[-rwx]\{9\} \+[0-9]\+ etc$" "$WORK_DIR/dump/rootfs.txt"; then
+    echo "expected the rootfs listing to hold the image's files, got:"
+    head "$WORK_DIR/dump/rootfs.txt"
+    exit 1
+fi
+
+# nothing is dumped when the container runs
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --dump-bundle "$WORK_DIR/dump-ok" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ -e "$WORK_DIR/dump-ok" ]; then
+    echo "expected no bundle to be dumped when the container runs"
+    exit 1
+fi
@@ -0,0 +1,40 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which saves the generated config.json
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" \
+    --config-override 'hostname="overridden"' \
+    --config-override 'annotations.org\.example\.test={"nested":[1,2]}' \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+
+for expected in '"hostname":"overridden"' '"org.example.test":{"nested":[1,2]}'; do
+    if ! tr -d ' \n' < "$WORK_DIR/config.json" | grep -qF "$expected"; then
+        echo "config.json does not contain $expected"
+        exit 1
+    fi
+done
+
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --config-override 'hostname=not-json' "$ALPINE" "$ALPINE_SHA256" 'true' 2>/dev/null; then
+    echo "expected an invalid --config-override value to be rejected"
+    exit 1
+fi
@@ -0,0 +1,58 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the config it was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+echo "nameserver 192.0.2.1" > "$WORK_DIR/resolv.conf"
+echo "box" > "$WORK_DIR/hostname"
+
+# the files are given relative to the working directory, and mounted by their absolute paths
+(cd "$WORK_DIR" && PATH="$WORK_DIR/bin:$PATH" "$BINARY" --resolv-conf resolv.conf --hostname-file hostname "$ALPINE" "$ALPINE_SHA256" 'true')
+
+python3 - "$WORK_DIR" <<'PY'
+import json, sys
+work_dir = sys.argv[1]
+mounts = json.load(open(work_dir + "/config.json"))["mounts"]
+for destination, source in (("/etc/resolv.conf", "resolv.conf"), ("/etc/hostname", "hostname")):
+    found = [m for m in mounts if m["destination"] == destination]
+    expected = {"destination": destination, "type": "bind", "source": work_dir + "/" + source, "options": ["rbind", "rprivate", "ro"]}
+    if found != [expected]:
+        sys.exit("expected %s to be bind mounted read-only from %s, got %s" % (destination, source, found))
+PY
+
+# without the flags, neither file is mounted
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+if ! python3 -c 'import json, sys; sys.exit(any(m["destination"] in ("/etc/resolv.conf", "/etc/hostname") for m in json.load(open(sys.argv[1]))["mounts"]))' "$WORK_DIR/config.json"; then
+    echo "expected no /etc/resolv.conf or /etc/hostname mounts without the flags"
+    exit 1
+fi
+
+# a directory cannot be mounted over a file
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --hostname-file "$WORK_DIR/bin" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a directory to be rejected by --hostname-file"
+    exit 1
+fi
+if ! grep -qF "error: invalid --hostname-file: $WORK_DIR/bin is not a regular file" "$WORK_DIR/stderr"; then
+    echo "unexpected error:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
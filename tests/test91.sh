@@ -0,0 +1,95 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# mkimage.py <path> writes a two-layer image to path and prints its sha256 sum
+cat > "$WORK_DIR/mkimage.py" <<'PY'
+import gzip, hashlib, io, json, sys, tarfile
+
+def build_tar(files):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w") as tf:
+        for name, data in files:
+            info = tarfile.TarInfo(name)
+            if data is None:
+                info.type, info.mode = tarfile.DIRTYPE, 0o755
+            else:
+                info.size = len(data)
+            tf.addfile(info, io.BytesIO(data) if data is not None else None)
+    return buf.getvalue()
+
+layers = [
+    build_tar([("etc", None), ("etc/hostname", b"base\n")]),
+    build_tar([("etc", None), ("etc/motd", b"hello\n")]),
+]
+config = {"os": "linux", "architecture": "amd64",
+          "rootfs": {"type": "layers", "diff_ids": ["sha256:" + hashlib.sha256(l).hexdigest() for l in layers]}}
+names = ["%d/layer.tar.gz" % i for i in range(len(layers))]
+image = build_tar(
+    [("manifest.json", json.dumps([{"Config": "config.json", "Layers": names}]).encode()),
+     ("config.json", json.dumps(config).encode())]
+    + [(name, gzip.compress(layer, mtime=0)) for name, layer in zip(names, layers)]
+)
+with open(sys.argv[1], "wb") as f:
+    f.write(gzip.compress(image, mtime=0))
+print(hashlib.sha256(image).hexdigest())
+PY
+IMAGE_SHA256=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/image.tar.gz")
+
+# stub runtime which does nothing
+mkdir "$WORK_DIR/bin"
+printf '#!/bin/sh\n' > "$WORK_DIR/bin/runc"
+chmod +x "$WORK_DIR/bin/runc"
+
+mkdir "$WORK_DIR/tmp"
+TMPDIR="$WORK_DIR/tmp" PATH="$WORK_DIR/bin:$PATH" "$BINARY" --keep-layers "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+
+# each layer's path is printed, in the order they were applied, and the archive
+# remains there once acbrun exits
+sed -n 's/^keeping layer //p' "$WORK_DIR/stderr" > "$WORK_DIR/kept"
+if [ "$(wc -l < "$WORK_DIR/kept")" -ne 2 ]; then
+    echo "expected the paths of both layers to be printed, got:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+n=0
+while read -r layer; do
+    case "$layer" in
+    "$WORK_DIR"/tmp/acbrun-*/$n/layer.tar.gz) ;;
+    *)
+        echo "expected layer $n to be kept in the working directory, got $layer"
+        exit 1
+        ;;
+    esac
+    if [ ! -f "$layer" ]; then
+        echo "expected $layer to remain after acbrun exits"
+        exit 1
+    fi
+    n=$((n + 1))
+done < "$WORK_DIR/kept"
+if ! tar -tzf "$(sed -n 2p "$WORK_DIR/kept")" | grep -qx 'etc/motd'; then
+    echo "expected the second kept layer to hold etc/motd"
+    exit 1
+fi
+
+# the rest of the working directory is removed
+if [ -e "$(dirname "$(dirname "$(sed -n 1p "$WORK_DIR/kept")")")/rootfs" ]; then
+    echo "expected the rootfs to be removed when keeping layers"
+    exit 1
+fi
+
+# without the flag, no layers are printed or kept
+rm -rf "$WORK_DIR/tmp"/*
+TMPDIR="$WORK_DIR/tmp" PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$WORK_DIR/image.tar.gz" "$IMAGE_SHA256" 'true' 2> "$WORK_DIR/stderr"
+if grep -q "^keeping layer" "$WORK_DIR/stderr" || [ -n "$(ls -A "$WORK_DIR/tmp")" ]; then
+    echo "expected no layers to be kept without --keep-layers"
+    exit 1
+fi
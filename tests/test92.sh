@@ -0,0 +1,110 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# mkimage.py <path> [stop signal] writes an image to path, whose config gives the
+# stop signal if any, and prints its sha256 sum
+cat > "$WORK_DIR/mkimage.py" <<'PY'
+import gzip, hashlib, io, json, sys, tarfile
+
+def build_tar(files):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w") as tf:
+        for name, data in files:
+            info = tarfile.TarInfo(name)
+            if data is None:
+                info.type, info.mode = tarfile.DIRTYPE, 0o755
+            else:
+                info.size = len(data)
+            tf.addfile(info, io.BytesIO(data) if data is not None else None)
+    return buf.getvalue()
+
+layer = build_tar([("etc", None), ("etc/hostname", b"box\n")])
+config = {"os": "linux", "architecture": "amd64", "config": {},
+          "rootfs": {"type": "layers", "diff_ids": ["sha256:" + hashlib.sha256(layer).hexdigest()]}}
+if len(sys.argv) > 2:
+    config["config"]["StopSignal"] = sys.argv[2]
+image = build_tar([
+    ("manifest.json", json.dumps([{"Config": "config.json", "Layers": ["layer.tar.gz"]}]).encode()),
+    ("config.json", json.dumps(config).encode()),
+    ("layer.tar.gz", gzip.compress(layer, mtime=0)),
+])
+with open(sys.argv[1], "wb") as f:
+    f.write(gzip.compress(image, mtime=0))
+print(hashlib.sha256(image).hexdigest())
+PY
+PLAIN_SHA256=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/plain.tar.gz")
+QUIT_SHA256=$(python3 "$WORK_DIR/mkimage.py" "$WORK_DIR/quit.tar.gz" SIGQUIT)
+
+# stub runtime whose container runs until it is killed, recording the signal
+# which runc kill was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+case " \$* " in
+*" run "*)
+    touch "$WORK_DIR/started"
+    for i in \$(seq 100); do
+        [ -e "$WORK_DIR/signal" ] && exit 0
+        sleep 0.1
+    done
+    exit 1
+    ;;
+*" kill "*)
+    # the signal is the last argument
+    for arg; do signal="\$arg"; done
+    echo "\$signal" > "$WORK_DIR/signal"
+    ;;
+esac
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# expect_stop_signal <signal number> <acbrun args>... checks that terminating acbrun
+# stops its container with the signal
+expect_stop_signal() {
+    expected="$1"
+    shift
+    rm -f "$WORK_DIR/started" "$WORK_DIR/signal"
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$@" 'true' &
+    pid=$!
+    for i in $(seq 100); do
+        [ -e "$WORK_DIR/started" ] && break
+        sleep 0.1
+    done
+    kill -TERM "$pid"
+    wait "$pid" || true
+    if [ "$(cat "$WORK_DIR/signal" 2>/dev/null)" != "$expected" ]; then
+        echo "expected the container to be stopped with signal $expected with $*, got $(cat "$WORK_DIR/signal" 2>/dev/null)"
+        exit 1
+    fi
+}
+
+expect_stop_signal 15 "$WORK_DIR/plain.tar.gz" "$PLAIN_SHA256"
+expect_stop_signal 2 --stop-signal SIGINT "$WORK_DIR/plain.tar.gz" "$PLAIN_SHA256"
+expect_stop_signal 1 --stop-signal hup "$WORK_DIR/plain.tar.gz" "$PLAIN_SHA256"
+expect_stop_signal 10 --stop-signal 10 "$WORK_DIR/plain.tar.gz" "$PLAIN_SHA256"
+
+# the image's StopSignal is the default, and the flag overrides it
+expect_stop_signal 3 "$WORK_DIR/quit.tar.gz" "$QUIT_SHA256"
+expect_stop_signal 9 --stop-signal KILL "$WORK_DIR/quit.tar.gz" "$QUIT_SHA256"
+
+# invalid signals are rejected before anything is run
+for signal in SIGBOGUS 0 65; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --stop-signal "$signal" "$WORK_DIR/plain.tar.gz" "$PLAIN_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected --stop-signal $signal to be rejected"
+        exit 1
+    fi
+    if ! grep -q "^error: invalid --stop-signal" "$WORK_DIR/stderr"; then
+        echo "unexpected error for --stop-signal $signal:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
@@ -0,0 +1,75 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+CACHE_ID="test93-$$"
+CACHE_DIR="/var/lib/acbrun/cache/$CACHE_ID"
+trap 'rm -rf "$WORK_DIR" "$CACHE_DIR"' EXIT
+
+# stub runtime which appends a line to the file count in whatever is mounted at
+# /cache, as the command would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+source=\$(python3 -c 'import json; print([m["source"] for m in json.load(open("config.json"))["mounts"] if m["destination"] == "/cache"][0])')
+echo run >> "\$source/count"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+for i in 1 2; do
+    PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mount-cache "id=$CACHE_ID,target=/cache" "$ALPINE" "$ALPINE_SHA256" 'echo run >> /cache/count'
+done
+
+# the cache is kept out of /tmp, private to root, and persists between runs
+if ! python3 - "$WORK_DIR/config.json" "$CACHE_DIR" <<'PY'
+import json, sys
+mounts = [m for m in json.load(open(sys.argv[1]))["mounts"] if m["destination"] == "/cache"]
+assert mounts == [{"destination": "/cache", "type": "bind", "source": sys.argv[2], "options": ["rbind", "rprivate"]}], mounts
+PY
+then
+    echo "expected $CACHE_DIR to be bind mounted read-write at /cache"
+    exit 1
+fi
+if [ "$(stat -c %a:%u /var/lib/acbrun/cache)" != "700:0" ] || [ "$(stat -c %a:%u "$CACHE_DIR")" != "700:0" ]; then
+    echo "expected the cache and its parent to be 0700 and owned by root"
+    stat -c '%n %a:%u' /var/lib/acbrun/cache "$CACHE_DIR"
+    exit 1
+fi
+if [ "$(wc -l < "$CACHE_DIR/count")" -ne 2 ]; then
+    echo "expected the cache to persist between runs"
+    exit 1
+fi
+
+# a cache which another user has taken over is refused
+chown 65534 "$CACHE_DIR"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mount-cache "id=$CACHE_ID,target=/cache" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+    echo "expected a cache owned by another user to be refused"
+    exit 1
+fi
+if ! grep -q "^error: unable to use cache $CACHE_ID: $CACHE_DIR is not a directory owned by uid 0" "$WORK_DIR/stderr"; then
+    echo "unexpected error:"
+    cat "$WORK_DIR/stderr"
+    exit 1
+fi
+if [ "$(wc -l < "$CACHE_DIR/count")" -ne 2 ]; then
+    echo "expected the container not to run with a refused cache"
+    exit 1
+fi
+
+for value in "id=../x,target=/cache" "id=x,target=cache" "id=x,target=/cache,mode=ro"; do
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mount-cache "$value" "$ALPINE" "$ALPINE_SHA256" 'true' 2> /dev/null; then
+        echo "expected --mount-cache $value to be rejected"
+        exit 1
+    fi
+done
@@ -0,0 +1,65 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the config it was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+printf '#!/bin/sh\n' > "$WORK_DIR/flush"
+chmod +x "$WORK_DIR/flush"
+printf '#!/bin/sh\n' > "$WORK_DIR/notify"
+chmod +x "$WORK_DIR/notify"
+
+# each hook is added in order, with its path and the whole command as its args
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --poststop-hook "$WORK_DIR/flush --all  --quiet" --poststop-hook "$WORK_DIR/notify" \
+    "$ALPINE" "$ALPINE_SHA256" 'true'
+if ! python3 - "$WORK_DIR/config.json" "$WORK_DIR" <<'PY'
+import json, sys
+hooks = json.load(open(sys.argv[1]))["hooks"]["poststop"]
+flush, notify = sys.argv[2] + "/flush", sys.argv[2] + "/notify"
+assert hooks == [
+    {"path": flush, "args": [flush, "--all", "--quiet"]},
+    {"path": notify, "args": [notify]},
+], hooks
+PY
+then
+    echo "expected the poststop hooks to be written to config.json"
+    exit 1
+fi
+
+# expect_rejected <error> <hook command> checks that the hook is refused before anything is run
+expect_rejected() {
+    rm -f "$WORK_DIR/config.json"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --poststop-hook "$2" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected --poststop-hook \"$2\" to be rejected"
+        exit 1
+    fi
+    if ! grep -qF "error: invalid --poststop-hook \"$2\": $1" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/config.json" ]; then
+        echo "unexpected error for --poststop-hook \"$2\":"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+}
+expect_rejected "empty command" " "
+expect_rejected "flush must be an absolute path" "flush --all"
+expect_rejected "stat $WORK_DIR/missing: no such file or directory" "$WORK_DIR/missing"
+expect_rejected "$WORK_DIR/bin is not executable" "$WORK_DIR/bin"
+chmod -x "$WORK_DIR/flush"
+expect_rejected "$WORK_DIR/flush is not executable" "$WORK_DIR/flush"
@@ -0,0 +1,54 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which creates a file in the rootfs, as the command would
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<'STUB'
+#!/bin/sh
+echo hello > rootfs/root/data
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# the missing parent directories of a nested output path are created, and a
+# relative path is taken from the directory acbrun is run in
+mkdir "$WORK_DIR/cwd"
+(cd "$WORK_DIR/cwd" && PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output out/images/alpine/out.tar.gz "$ALPINE" "$ALPINE_SHA256" 'echo hello > /root/data')
+OUTPUT="$WORK_DIR/cwd/out/images/alpine/out.tar.gz"
+if [ ! -f "$OUTPUT" ]; then
+    echo "expected the output to be written to $OUTPUT"
+    find "$WORK_DIR/cwd"
+    exit 1
+fi
+if [ "$(stat -c %a "$WORK_DIR/cwd/out/images")" != "755" ]; then
+    echo "expected the output's parent directories to be created with mode 755"
+    exit 1
+fi
+if [ "$(ls -A "$WORK_DIR/cwd/out/images/alpine")" != "out.tar.gz" ]; then
+    echo "expected only the output in its directory, got:"
+    ls -A "$WORK_DIR/cwd/out/images/alpine"
+    exit 1
+fi
+if ! tar -tzf "$OUTPUT" | grep -qx manifest.json; then
+    echo "expected the output to be an image"
+    exit 1
+fi
+
+# an output whose parent cannot be created is an error
+touch "$WORK_DIR/file"
+if PATH="$WORK_DIR/bin:$PATH" "$BINARY" --output "$WORK_DIR/file/out.tar.gz" "$ALPINE" "$ALPINE_SHA256" 'true' 2> /dev/null; then
+    echo "expected an output beneath a file to fail"
+    exit 1
+fi
@@ -0,0 +1,65 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the config it was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# paths <key> prints the paths listed under linux.<key> in the recorded config
+paths() {
+    python3 -c 'import json, sys; print(" ".join(json.load(open(sys.argv[1]))["linux"][sys.argv[2]]))' "$WORK_DIR/config.json" "$1"
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+DEFAULT_MASKED=$(paths maskedPaths)
+DEFAULT_READONLY=$(paths readonlyPaths)
+case " $DEFAULT_MASKED " in
+*" /proc/kcore "*) ;;
+*)
+    echo "expected /proc/kcore to be masked by default, got $DEFAULT_MASKED"
+    exit 1
+    ;;
+esac
+
+# the given paths are added after the defaults, in order
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --mask-path /etc/secret --mask-path /proc/cpuinfo \
+    --readonly-path /etc --readonly-path /usr/lib "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(paths maskedPaths)" != "$DEFAULT_MASKED /etc/secret /proc/cpuinfo" ]; then
+    echo "expected the masked paths to be added to linux.maskedPaths, got $(paths maskedPaths)"
+    exit 1
+fi
+if [ "$(paths readonlyPaths)" != "$DEFAULT_READONLY /etc /usr/lib" ]; then
+    echo "expected the read-only paths to be added to linux.readonlyPaths, got $(paths readonlyPaths)"
+    exit 1
+fi
+
+# relative paths are rejected before anything is run
+for flag in --mask-path --readonly-path; do
+    rm -f "$WORK_DIR/config.json"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$flag" etc/secret "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected a relative $flag to be rejected"
+        exit 1
+    fi
+    if ! grep -q '^error: --mask-path and --readonly-path values must be absolute paths; got "etc/secret"' "$WORK_DIR/stderr" || [ -e "$WORK_DIR/config.json" ]; then
+        echo "unexpected error for a relative $flag:"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
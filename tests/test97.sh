@@ -0,0 +1,55 @@
+#!/bin/sh
+set -e
+
+SCRIPT=$(readlink -f "$0")
+# Absolute path this script is in, thus /home/user/bin
+SCRIPTPATH=$(dirname "$SCRIPT")
+
+BINARY="${BINARY:-$SCRIPTPATH/../acbrun}"
+
+ALPINE_VERSION="3.20.3"
+ALPINE="$SCRIPTPATH/../sample-images/alpine-$ALPINE_VERSION.tar.gz"
+ALPINE_SHA256="c0d141e28aea48a56c28650de3ceef70767e3d14da5e6d13f4cc68489e97a3e8"
+
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+# stub runtime which records the config it was given
+mkdir "$WORK_DIR/bin"
+cat > "$WORK_DIR/bin/runc" <<STUB
+#!/bin/sh
+cp config.json "$WORK_DIR/config.json"
+STUB
+chmod +x "$WORK_DIR/bin/runc"
+
+# additional_gids prints the recorded config's process.user.additionalGids
+additional_gids() {
+    python3 -c 'import json, sys; print(" ".join(str(g) for g in json.load(open(sys.argv[1]))["process"]["user"].get("additionalGids", [])))' "$WORK_DIR/config.json"
+}
+
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(additional_gids)" != "0" ]; then
+    echo "expected only the root group by default, got $(additional_gids)"
+    exit 1
+fi
+
+# each gid is added after it in order, up to the largest 32 bit gid
+PATH="$WORK_DIR/bin:$PATH" "$BINARY" --group-add 10 --group-add 0 --group-add 4294967295 "$ALPINE" "$ALPINE_SHA256" 'true'
+if [ "$(additional_gids)" != "0 10 0 4294967295" ]; then
+    echo "expected the additional gids 0 10 0 4294967295, got $(additional_gids)"
+    exit 1
+fi
+
+# group names and gids which do not fit in 32 bits are rejected before anything is run
+for gid in wheel -1 4294967296 ""; do
+    rm -f "$WORK_DIR/config.json"
+    if PATH="$WORK_DIR/bin:$PATH" "$BINARY" "--group-add=$gid" "$ALPINE" "$ALPINE_SHA256" 'true' 2> "$WORK_DIR/stderr"; then
+        echo "expected --group-add \"$gid\" to be rejected"
+        exit 1
+    fi
+    if ! grep -qF "error: --group-add requires a numeric gid; got \"$gid\"" "$WORK_DIR/stderr" || [ -e "$WORK_DIR/config.json" ]; then
+        echo "unexpected error for --group-add \"$gid\":"
+        cat "$WORK_DIR/stderr"
+        exit 1
+    fi
+done
@@ -0,0 +1,146 @@
+package acbrun
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// readColonFile parses an /etc/passwd or /etc/group style file into its fields.
+func readColonFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
+
+func parseID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// ResolveUser resolves an image config User value, which may take the form
+// "user", "uid", "user:group", "uid:gid", "user:gid", or "uid:group", to numeric
+// ids using the /etc/passwd and /etc/group files found in rootFS. When no group is
+// given, the user's primary group from /etc/passwd is used (or 0 for a numeric uid
+// without a passwd entry).
+func ResolveUser(rootFS, user string) (uid, gid uint32, err error) {
+	userPart, groupPart, hasGroup := strings.Cut(user, ":")
+	if userPart == "" {
+		return 0, 0, fmt.Errorf("invalid user %q", user)
+	}
+
+	passwd, err := readColonFile(filepath.Join(rootFS, "etc", "passwd"))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	found := false
+	for _, entry := range passwd {
+		if len(entry) < 4 {
+			continue
+		}
+		entryUID, ok1 := parseID(entry[2])
+		entryGID, ok2 := parseID(entry[3])
+		if !ok1 || !ok2 {
+			continue
+		}
+		if entry[0] == userPart || entry[2] == userPart {
+			uid, gid, found = entryUID, entryGID, true
+			break
+		}
+	}
+	if !found {
+		var ok bool
+		uid, ok = parseID(userPart)
+		if !ok {
+			return 0, 0, fmt.Errorf("unable to find user %s in /etc/passwd", userPart)
+		}
+		gid = 0
+	}
+
+	if !hasGroup {
+		return uid, gid, nil
+	}
+	if groupPart == "" {
+		return 0, 0, fmt.Errorf("invalid user %q", user)
+	}
+	if id, ok := parseID(groupPart); ok {
+		return uid, id, nil
+	}
+	groups, err := readColonFile(filepath.Join(rootFS, "etc", "group"))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	for _, entry := range groups {
+		if len(entry) < 3 || entry[0] != groupPart {
+			continue
+		}
+		if id, ok := parseID(entry[2]); ok {
+			return uid, id, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unable to find group %s in /etc/group", groupPart)
+}
+
+// AdditionalGids returns the ids of the supplementary groups of the user of an
+// image config User value (see ResolveUser), which are the groups listing the user
+// as a member in the /etc/group file found in rootFS, in the order they appear
+// there. gid, the user's resolved primary group, is left out. A user without an
+// /etc/passwd entry, such as a bare uid, belongs to no supplementary groups.
+func AdditionalGids(rootFS, user string, gid uint32) ([]uint32, error) {
+	userPart, _, _ := strings.Cut(user, ":")
+	passwd, err := readColonFile(filepath.Join(rootFS, "etc", "passwd"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	name := ""
+	for _, entry := range passwd {
+		if len(entry) < 4 {
+			continue
+		}
+		if entry[0] == userPart || entry[2] == userPart {
+			name = entry[0]
+			break
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	groups, err := readColonFile(filepath.Join(rootFS, "etc", "group"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var gids []uint32
+	for _, entry := range groups {
+		if len(entry) < 4 {
+			continue
+		}
+		id, ok := parseID(entry[2])
+		if !ok || id == gid || slices.Contains(gids, id) {
+			continue
+		}
+		if slices.Contains(strings.Split(entry[3], ","), name) {
+			gids = append(gids, id)
+		}
+	}
+	return gids, nil
+}
@@ -0,0 +1,59 @@
+package acbrun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	rootFS := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootFS, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\n" +
+		"# comments and malformed lines are skipped\n" +
+		"broken:x:notanumber:101\n" +
+		"nginx:x:101:102:nginx:/var/lib/nginx:/sbin/nologin\n"
+	group := "root:x:0:root\nnginx:x:102:nginx\nwww-data:x:33:nginx\n"
+	if err := os.WriteFile(filepath.Join(rootFS, "etc", "passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootFS, "etc", "group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		user     string
+		uid, gid uint32
+	}{
+		{"root", 0, 0},
+		{"nginx", 101, 102},
+		{"101", 101, 102},
+		{"nginx:www-data", 101, 33},
+		{"nginx:0", 101, 0},
+		{"1000", 1000, 0},
+		{"1000:www-data", 1000, 33},
+		{"1000:1000", 1000, 1000},
+	} {
+		uid, gid, err := ResolveUser(rootFS, tc.user)
+		if err != nil {
+			t.Errorf("%s: %s", tc.user, err)
+			continue
+		}
+		if uid != tc.uid || gid != tc.gid {
+			t.Errorf("%s: expected %d:%d, got %d:%d", tc.user, tc.uid, tc.gid, uid, gid)
+		}
+	}
+
+	for _, user := range []string{"postgres", "broken", "nginx:wheel", "", ":33", "nginx:"} {
+		if uid, gid, err := ResolveUser(rootFS, user); err == nil {
+			t.Errorf("%q: expected an error, got %d:%d", user, uid, gid)
+		}
+	}
+
+	// a rootfs without an /etc/passwd can still be given numeric ids
+	if uid, gid, err := ResolveUser(t.TempDir(), "1000:1000"); err != nil || uid != 1000 || gid != 1000 {
+		t.Errorf("expected 1000:1000 without an /etc/passwd, got %d:%d (%v)", uid, gid, err)
+	}
+}